@@ -0,0 +1,111 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Name given to attacks in vegeta results.
+const attackName = "cegen"
+
+// attackOpts are the options of an attack.
+type attackOpts struct {
+	pacer    vegeta.Pacer
+	duration time.Duration
+
+	workers     uint64
+	maxWorkers  uint64
+	connections int
+	timeout     time.Duration
+	http2       bool
+
+	// path of the file to write vegeta results to
+	resultsFile string
+}
+
+// runAttack sends the targets yielded by the given targeter at the rate
+// defined in the attack options, until either the configured duration
+// elapses or ctx is cancelled.
+// Results are summarized in a text report written to stdout, and optionally
+// written in vegeta's binary format to a results file.
+func runAttack(ctx context.Context, tr vegeta.Targeter, opts *attackOpts, stdout io.Writer) error {
+	encode := func(*vegeta.Result) error { return nil }
+
+	if opts.resultsFile != "" {
+		f, err := os.Create(opts.resultsFile)
+		if err != nil {
+			return fmt.Errorf("creating results file: %w", err)
+		}
+		defer f.Close()
+
+		w := bufio.NewWriter(f)
+		defer w.Flush()
+
+		encode = vegeta.NewEncoder(w).Encode
+	}
+
+	atk := vegeta.NewAttacker(
+		vegeta.Workers(opts.workers),
+		vegeta.MaxWorkers(opts.maxWorkers),
+		vegeta.Connections(opts.connections),
+		vegeta.Timeout(opts.timeout),
+		vegeta.KeepAlive(true),
+		vegeta.HTTP2(opts.http2),
+	)
+
+	results := atk.Attack(tr, opts.pacer, opts.duration, attackName)
+
+	var m vegeta.Metrics
+	var encodeErr error
+
+	done := ctx.Done()
+
+	for {
+		select {
+		case <-done:
+			// keep collecting the results of in-flight requests
+			// until the attacker closes the results channel
+			atk.Stop()
+			done = nil
+			continue
+
+		case r, ok := <-results:
+			if !ok {
+				m.Close()
+
+				if encodeErr != nil {
+					return fmt.Errorf("writing results: %w", encodeErr)
+				}
+				return vegeta.NewTextReporter(&m).Report(stdout)
+			}
+
+			m.Add(r)
+
+			if encodeErr == nil {
+				encodeErr = encode(r)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func TestAttack(t *testing.T) {
+	var received int64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Ce-Id") != "" {
+			atomic.AddInt64(&received, 1)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	tmpDir, err := ioutil.TempDir("", "cegen")
+	if err != nil {
+		t.Fatal("Error creating temp dir:", err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Fatal("Failed to remove temp dir:", err)
+		}
+	})
+
+	resultsFile := filepath.Join(tmpDir, "results.bin")
+
+	var stdout strings.Builder
+	var stderr strings.Builder
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*testTimeout)
+	defer cancel()
+
+	err = run(ctx, []string{tCmd, "-u=" + srv.URL, "-d={}", "-attack",
+		"-load=constant:rate=100", "-duration=200ms", "-o=" + resultsFile}, &stdout, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected runtime error:", err)
+	}
+
+	if n := atomic.LoadInt64(&received); n == 0 {
+		t.Fatal("Expected the target to receive events")
+	}
+
+	report := stdout.String()
+	if !strings.Contains(report, "Status Codes  [code:count]") || !strings.Contains(report, "202:") {
+		t.Errorf("Unexpected report:\n%s", report)
+	}
+
+	f, err := os.Open(resultsFile)
+	if err != nil {
+		t.Fatal("Error opening results file:", err)
+	}
+	defer f.Close()
+
+	dec := vegeta.NewDecoder(f)
+
+	var numResults int64
+	for {
+		var r vegeta.Result
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("Error decoding result:", err)
+		}
+
+		if r.Code != http.StatusAccepted {
+			t.Errorf("Unexpected status code in result: %d", r.Code)
+		}
+		numResults++
+	}
+
+	if n := atomic.LoadInt64(&received); numResults != n {
+		t.Errorf("Expected %d results, got %d", n, numResults)
+	}
+}
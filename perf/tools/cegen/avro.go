@@ -0,0 +1,58 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// Media type of data encoded in the Avro binary format.
+const contentTypeAvro = "application/avro"
+
+// readAvroSchema returns a codec for the Avro schema contained in the file at
+// the given path.
+func readAvroSchema(path string) (*goavro.Codec, error) {
+	schema, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading Avro schema: %w", err)
+	}
+
+	codec, err := goavro.NewCodec(string(schema))
+	if err != nil {
+		return nil, fmt.Errorf("parsing Avro schema: %w", err)
+	}
+
+	return codec, nil
+}
+
+// avroFromText converts data in Avro's JSON encoding to the Avro binary
+// encoding, appends it to dst and returns the extended buffer.
+func avroFromText(dst []byte, codec *goavro.Codec, text []byte) ([]byte, error) {
+	native, _, err := codec.NativeFromTextual(text)
+	if err != nil {
+		return dst, fmt.Errorf("decoding Avro data from JSON: %w", err)
+	}
+
+	if dst, err = codec.BinaryFromNative(dst, native); err != nil {
+		return dst, fmt.Errorf("encoding Avro data: %w", err)
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mailru/easyjson/jlexer"
+)
+
+const testAvroSchema = `{
+  "type": "record",
+  "name": "Reading",
+  "fields": [
+    {"name": "seq", "type": "long"},
+    {"name": "sensor", "type": "string"}
+  ]
+}`
+
+func TestAvroData(t *testing.T) {
+	codec, err := readAvroSchema(writeTestAvroSchema(t))
+	if err != nil {
+		t.Fatal("Error reading Avro schema:", err)
+	}
+
+	tmpl, err := parseDataTemplate([]byte(`{"seq":{{seq}},"sensor":"s1"}`))
+	if err != nil {
+		t.Fatal("Error parsing template:", err)
+	}
+
+	testCases := []struct {
+		name    string
+		profile *EventProfile
+	}{
+		{
+			name: "static data",
+			profile: &EventProfile{
+				Data:      []byte(`{"seq":1,"sensor":"s1"}`),
+				AvroCodec: codec,
+			},
+		},
+		{
+			name: "templated data",
+			profile: &EventProfile{
+				Template:  tmpl,
+				AvroCodec: codec,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.profile.Type = "test.event"
+			tc.profile.Source = "cegen/go/test"
+
+			g := NewWeightedCloudEventTargetsGenerator("http://localhost", []*EventProfile{tc.profile})
+
+			trg, err := g.Generate()
+			if err != nil {
+				t.Fatal("Generate returned an error:", err)
+			}
+
+			var decTrg jsonTarget
+			decTrg.decode(&jlexer.Lexer{Data: trg})
+
+			if ct := decTrg.Header.Get("Content-Type"); ct != contentTypeAvro {
+				t.Errorf("Expected Content-Type %q, got %q", contentTypeAvro, ct)
+			}
+
+			native, rest, err := codec.NativeFromBinary(decTrg.Body)
+			if err != nil {
+				t.Fatalf("Body isn't valid Avro data: %s\n%q", err, decTrg.Body)
+			}
+			if len(rest) != 0 {
+				t.Errorf("Unexpected trailing bytes %q", rest)
+			}
+
+			expect := map[string]interface{}{
+				"seq":    int64(1),
+				"sensor": "s1",
+			}
+			if !reflect.DeepEqual(native, expect) {
+				t.Errorf("Expected data %v, got %v", expect, native)
+			}
+		})
+	}
+
+	t.Run("data not matching the schema", func(t *testing.T) {
+		g := NewWeightedCloudEventTargetsGenerator("http://localhost", []*EventProfile{{
+			Type:      "test.event",
+			Source:    "cegen/go/test",
+			Data:      []byte(`{"seq":"one"}`),
+			AvroCodec: codec,
+		}})
+
+		_, err := g.Generate()
+		if err == nil {
+			t.Fatal("Expected Generate to fail")
+		}
+
+		const expectMsg = "decoding Avro data from JSON"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Errorf("Expected error to contain %q, got %q", expectMsg, errStr)
+		}
+	})
+}
+
+// writeTestAvroSchema writes testAvroSchema to a temporary file and returns
+// the path of that file.
+func writeTestAvroSchema(t *testing.T) string {
+	t.Helper()
+
+	schemaFile := filepath.Join(t.TempDir(), "schema.avsc")
+	if err := ioutil.WriteFile(schemaFile, []byte(testAvroSchema), 0o644); err != nil {
+		t.Fatal("Error writing Avro schema:", err)
+	}
+	return schemaFile
+}
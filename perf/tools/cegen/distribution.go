@@ -0,0 +1,102 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "strconv"
+
+// Strategies for distributing targets across multiple URLs.
+const (
+	distRoundRobin = "roundrobin"
+	distRandom     = "random"
+	distHash       = "hash"
+)
+
+// Extension attribute carrying the partition key of an event.
+// https://github.com/cloudevents/spec/blob/v1.0.1/extensions/partitioning.md
+const (
+	extPartitionKey    = "partitionkey"
+	headerPartitionKey = "Ce-Partitionkey"
+)
+
+// WithTargetURLs sets the generator to distribute targets across the given
+// URLs, instead of the URL passed to its constructor, using the given
+// distribution strategy.
+// The "hash" strategy requires partition keys to be enabled with
+// WithPartitionKeys.
+func WithTargetURLs(urls []string, distribution string) GeneratorOption {
+	return func(g *CloudEventTargetsGenerator) {
+		g.targetURLs = urls
+		g.distribution = distribution
+	}
+}
+
+// WithPartitionKeys sets the generator to set a partition key extension
+// attribute on each event, which is drawn randomly from the given number of
+// distinct keys, which must not exceed math.MaxInt64. All events within a
+// same target share the same key.
+func WithPartitionKeys(cardinality uint64) GeneratorOption {
+	return func(g *CloudEventTargetsGenerator) {
+		g.numPartitionKeys = cardinality
+	}
+}
+
+// nextTargetURL draws the partition key of the next target, when partition
+// keys are enabled, and returns the URL to send that target to.
+func (g *CloudEventTargetsGenerator) nextTargetURL() string {
+	if g.numPartitionKeys > 0 {
+		key := uint64(g.tmplCtx.rand.Int63n(int64(g.numPartitionKeys)))
+		g.partitionKey = strconv.FormatUint(key, 10)
+	}
+
+	if len(g.targetURLs) == 1 {
+		return g.targetURLs[0]
+	}
+
+	var i int
+
+	switch g.distribution {
+	case distRandom:
+		i = g.tmplCtx.rand.Intn(len(g.targetURLs))
+
+	case distHash:
+		i = int(fnv32a(g.partitionKey) % uint32(len(g.targetURLs)))
+
+	default:
+		i = g.nextURLPos
+		if g.nextURLPos++; g.nextURLPos == len(g.targetURLs) {
+			g.nextURLPos = 0
+		}
+	}
+
+	return g.targetURLs[i]
+}
+
+// fnv32a returns the 32-bit FNV-1a hash of s.
+// It is equivalent to hashing s with hash/fnv, without allocating.
+func fnv32a(s string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+
+	h := uint32(offset32)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= prime32
+	}
+	return h
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+	"testing"
+
+	"github.com/mailru/easyjson/jlexer"
+)
+
+func TestTargetDistribution(t *testing.T) {
+	urls := []string{"http://a", "http://b", "http://c"}
+
+	const numTargets = 300
+
+	// generate returns the URL and partition key of targets yielded by
+	// a generator created with the given options.
+	generate := func(t *testing.T, opts ...GeneratorOption) (trgURLs, keys []string) {
+		t.Helper()
+
+		g := NewCloudEventTargetsGenerator("http://ignored", "test.event", "cegen/go/test", []byte(`{}`),
+			append(opts, WithSeed(1))...)
+
+		for i := 0; i < numTargets; i++ {
+			trg, err := g.Generate()
+			if err != nil {
+				t.Fatal("Generate returned an error:", err)
+			}
+
+			var decTrg jsonTarget
+			decTrg.decode(&jlexer.Lexer{Data: trg})
+
+			trgURLs = append(trgURLs, decTrg.URL)
+			keys = append(keys, decTrg.Header.Get(headerPartitionKey))
+		}
+
+		return trgURLs, keys
+	}
+
+	t.Run("round-robin", func(t *testing.T) {
+		trgURLs, keys := generate(t, WithTargetURLs(urls, distRoundRobin))
+
+		for i, u := range trgURLs {
+			if expect := urls[i%len(urls)]; u != expect {
+				t.Fatalf("Expected target %d to be sent to %s, got %s", i, expect, u)
+			}
+		}
+
+		if keys[0] != "" {
+			t.Errorf("Expected no partition key, got %q", keys[0])
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		trgURLs, _ := generate(t, WithTargetURLs(urls, distRandom))
+
+		counts := make(map[string]int)
+		for _, u := range trgURLs {
+			counts[u]++
+		}
+
+		for _, u := range urls {
+			if c := counts[u]; c < numTargets/len(urls)/2 {
+				t.Errorf("Expected about %d targets sent to %s, got %d", numTargets/len(urls), u, c)
+			}
+		}
+	})
+
+	t.Run("hash of partition key", func(t *testing.T) {
+		const numKeys = 10
+
+		trgURLs, keys := generate(t, WithTargetURLs(urls, distHash), WithPartitionKeys(numKeys))
+
+		urlsByKey := make(map[string]string)
+
+		for i, k := range keys {
+			n, err := strconv.Atoi(k)
+			if err != nil || n < 0 || n >= numKeys {
+				t.Fatalf("Invalid partition key %q", k)
+			}
+
+			if u, isSet := urlsByKey[k]; isSet && u != trgURLs[i] {
+				t.Fatalf("Partition key %s was sent to both %s and %s", k, u, trgURLs[i])
+			}
+			urlsByKey[k] = trgURLs[i]
+		}
+
+		if l := len(urlsByKey); l != numKeys {
+			t.Errorf("Expected %d distinct partition keys, got %d", numKeys, l)
+		}
+	})
+
+	t.Run("partition key in batch", func(t *testing.T) {
+		g := NewCloudEventTargetsGenerator("http://localhost", "test.event", "cegen/go/test", []byte(`{}`),
+			WithPartitionKeys(1000), WithBatchMode(5))
+
+		trg, err := g.Generate()
+		if err != nil {
+			t.Fatal("Generate returned an error:", err)
+		}
+
+		var decTrg jsonTarget
+		decTrg.decode(&jlexer.Lexer{Data: trg})
+
+		var es []map[string]interface{}
+		if err := json.Unmarshal(decTrg.Body, &es); err != nil {
+			t.Fatalf("Body isn't a valid batch of events: %s\n%s", err, decTrg.Body)
+		}
+
+		for _, e := range es {
+			if k := e[extPartitionKey]; k == nil || k != es[0][extPartitionKey] {
+				t.Errorf("Expected all events of the batch to share the same partition key, got %v", k)
+			}
+		}
+	})
+}
+
+func TestFNV32a(t *testing.T) {
+	for _, s := range []string{"", "0", "partition-42"} {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(s))
+
+		if expect, got := h.Sum32(), fnv32a(s); expect != got {
+			t.Errorf("Expected hash of %q to be %d, got %d", s, expect, got)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of faults injected into faulty requests.
+const (
+	// The event has no ID.
+	faultMissingID = "missing-id"
+	// The event has no source.
+	faultMissingSource = "missing-source"
+	// The event declares a spec version which doesn't exist.
+	faultSpecVersion = "specversion"
+	// The event has the same ID as the last valid event.
+	faultDuplicateID = "duplicate-id"
+	// The data of the event is padded to an oversized payload. In batch
+	// mode, only the first event of the batch is oversized.
+	faultOversized = "oversized"
+	// The body isn't valid JSON, or isn't a valid protobuf message.
+	faultInvalidJSON = "invalid-json"
+	// The Content-Type header doesn't match the content mode.
+	faultContentType = "content-type"
+)
+
+// faultKinds are all kinds of faults, in the order they are documented.
+var faultKinds = []string{
+	faultMissingID,
+	faultMissingSource,
+	faultSpecVersion,
+	faultDuplicateID,
+	faultOversized,
+	faultInvalidJSON,
+	faultContentType,
+}
+
+// Header which identifies faulty requests, and contains the kind of fault.
+const headerFault = "X-Cegen-Fault"
+
+// Spec version set on events with a faultSpecVersion.
+const faultySpecVersion = "0.9"
+
+// Data of events with a faultInvalidJSON, in binary content mode.
+const malformedJSON = `{"data":"malformed"`
+
+// defaultOversizedDataSize is the default size in bytes of the data of events
+// with a faultOversized.
+const defaultOversizedDataSize = 4 << 20
+
+// WithFaults sets the generator to yield a faulty request instead of a valid
+// one with the given probability. The kind of each fault is drawn from the
+// given kinds, and the data of oversized events has the given size in bytes.
+//
+// Faulty requests don't consume sequence numbers and aren't stamped, so that
+// receivers can keep track of valid events. They carry a X-Cegen-Fault header.
+func WithFaults(rate float64, kinds []string, oversizedDataSize int) GeneratorOption {
+	return func(g *CloudEventTargetsGenerator) {
+		g.faultRate = rate
+		g.faultKinds = kinds
+		g.oversizedDataSize = oversizedDataSize
+	}
+}
+
+// parseFaultKinds returns the kinds of faults contained in the given
+// comma-separated list.
+func parseFaultKinds(list string) ([]string, error) {
+	var kinds []string
+
+	for _, k := range strings.Split(list, ",") {
+		if !isFaultKind(k) {
+			return nil, fmt.Errorf("invalid fault kind %q", k)
+		}
+		kinds = append(kinds, k)
+	}
+
+	return kinds, nil
+}
+
+// isFaultKind returns whether the given string is a known kind of fault.
+func isFaultKind(k string) bool {
+	for _, fk := range faultKinds {
+		if k == fk {
+			return true
+		}
+	}
+	return false
+}
+
+// initFaults prepares the payload of oversized events, if needed.
+func (g *CloudEventTargetsGenerator) initFaults() {
+	for _, k := range g.faultKinds {
+		if k == faultOversized {
+			synth := newSyntheticData(fixedSizeDistribution(g.oversizedDataSize), g.tmplCtx.rand)
+			g.oversizedData = synth.render(nil, g.oversizedDataSize)
+			return
+		}
+	}
+}
+
+// drawFault determines whether the next target is faulty, and which kind of
+// fault it contains. It returns whether the target is faulty.
+func (g *CloudEventTargetsGenerator) drawFault() bool {
+	g.fault = ""
+	g.oversizedPending = false
+
+	if g.faultRate == 0 || g.tmplCtx.rand.Float64() >= g.faultRate {
+		return false
+	}
+
+	g.fault = g.faultKinds[g.tmplCtx.rand.Intn(len(g.faultKinds))]
+	g.oversizedPending = g.fault == faultOversized
+	return true
+}
+
+// eventID returns a unique event ID, or the ID of the last valid event when
+// the current target contains a faultDuplicateID.
+func (g *CloudEventTargetsGenerator) eventID() string {
+	if g.fault == faultDuplicateID && g.lastID != "" {
+		return g.lastID
+	}
+
+	id := g.uuidGen.Hex128()
+	if g.fault == "" {
+		g.lastID = id
+	}
+	return id
+}
+
+// applyFault alters the given target according to the kind of fault of the
+// current target.
+func (g *CloudEventTargetsGenerator) applyFault(t *jsonTarget) {
+	switch g.fault {
+	case faultInvalidJSON:
+		if g.mode == modeBinary {
+			t.Header["Content-Type"] = []string{contentTypeJSON}
+			t.Body = []byte(malformedJSON)
+		} else {
+			// a truncated body is neither a valid JSON document nor
+			// a valid protobuf message
+			t.Body = t.Body[:len(t.Body)/2]
+		}
+
+	case faultContentType:
+		t.Header["Content-Type"] = []string{g.mismatchedContentType()}
+
+	case faultMissingID, faultMissingSource, faultSpecVersion:
+		// in structured and batch modes, attributes are already
+		// omitted or altered in the body
+		if g.mode == modeBinary {
+			switch g.fault {
+			case faultMissingID:
+				delete(t.Header, "Ce-Id")
+			case faultMissingSource:
+				delete(t.Header, "Ce-Source")
+			case faultSpecVersion:
+				t.Header["Ce-Specversion"] = []string{faultySpecVersion}
+			}
+		}
+	}
+
+	t.Header[headerFault] = []string{g.fault}
+}
+
+// mismatchedContentType returns a content type which belongs to a different
+// content mode than the generator's.
+func (g *CloudEventTargetsGenerator) mismatchedContentType() string {
+	switch {
+	case g.mode == modeBinary:
+		// the data isn't a structured event
+		return contentTypeCEJSON
+	case g.mode == modeStructured && g.protobuf:
+		return contentTypeCEBatchProtobuf
+	case g.mode == modeStructured:
+		// the body isn't an array of events
+		return contentTypeCEBatchJSON
+	case g.protobuf:
+		return contentTypeCEProtobuf
+	default:
+		// the body isn't a single event
+		return contentTypeCEJSON
+	}
+}
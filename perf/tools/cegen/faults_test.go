@@ -0,0 +1,295 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/mailru/easyjson/jlexer"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func TestFaults(t *testing.T) {
+	const oversizedDataSize = 1024
+
+	// faults are injected with a rate close to 1, so that the first
+	// target is faulty regardless of the random source
+	const faultRate = 0.999999
+
+	testCases := []struct {
+		fault        string
+		mode         string
+		expectTarget func(t *testing.T, trg *jsonTarget)
+	}{
+		{
+			fault: faultMissingID,
+			mode:  modeBinary,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if _, ok := trg.Header["Ce-Id"]; ok {
+					t.Error("Expected Ce-Id header to be omitted")
+				}
+			},
+		},
+		{
+			fault: faultMissingID,
+			mode:  modeStructured,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if e := decodeTestEventAttrs(t, trg.Body); e["id"] != nil {
+					t.Errorf("Expected id to be omitted, got %v", e["id"])
+				}
+			},
+		},
+		{
+			fault: faultMissingSource,
+			mode:  modeBinary,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if _, ok := trg.Header["Ce-Source"]; ok {
+					t.Error("Expected Ce-Source header to be omitted")
+				}
+			},
+		},
+		{
+			fault: faultMissingSource,
+			mode:  modeStructured,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if e := decodeTestEventAttrs(t, trg.Body); e["source"] != nil {
+					t.Errorf("Expected source to be omitted, got %v", e["source"])
+				}
+			},
+		},
+		{
+			fault: faultSpecVersion,
+			mode:  modeBinary,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if v := trg.Header.Get("Ce-Specversion"); v != faultySpecVersion {
+					t.Errorf("Expected Ce-Specversion %q, got %q", faultySpecVersion, v)
+				}
+			},
+		},
+		{
+			fault: faultSpecVersion,
+			mode:  modeStructured,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if e := decodeTestEventAttrs(t, trg.Body); e["specversion"] != faultySpecVersion {
+					t.Errorf("Expected specversion %q, got %v", faultySpecVersion, e["specversion"])
+				}
+			},
+		},
+		{
+			fault: faultOversized,
+			mode:  modeBinary,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if l := len(trg.Body); l != oversizedDataSize {
+					t.Errorf("Expected body of %d bytes, got %d", oversizedDataSize, l)
+				}
+			},
+		},
+		{
+			fault: faultOversized,
+			mode:  modeBatch,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				// a single event of the batch is oversized
+				if l, max := len(trg.Body), oversizedDataSize+1024; l > max {
+					t.Errorf("Expected body of at most %d bytes, got %d", max, l)
+				}
+
+				var events []map[string]json.RawMessage
+				if err := json.Unmarshal(trg.Body, &events); err != nil {
+					t.Fatalf("Body isn't a valid batch of events: %s", err)
+				}
+				if l := len(events[0]["data"]); l != oversizedDataSize {
+					t.Errorf("Expected data of %d bytes in the first event, got %d", oversizedDataSize, l)
+				}
+				if d := string(events[1]["data"]); d != `{"msg":"hello"}` {
+					t.Errorf("Expected the second event to carry its regular data, got %s", d)
+				}
+			},
+		},
+		{
+			fault: faultInvalidJSON,
+			mode:  modeBinary,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if ct := trg.Header.Get("Content-Type"); ct != contentTypeJSON {
+					t.Errorf("Expected Content-Type %q, got %q", contentTypeJSON, ct)
+				}
+				if json.Valid(trg.Body) {
+					t.Errorf("Expected body to be invalid JSON, got %s", trg.Body)
+				}
+			},
+		},
+		{
+			fault: faultInvalidJSON,
+			mode:  modeBatch,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if json.Valid(trg.Body) {
+					t.Errorf("Expected body to be invalid JSON, got %s", trg.Body)
+				}
+			},
+		},
+		{
+			fault: faultContentType,
+			mode:  modeBinary,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if ct := trg.Header.Get("Content-Type"); ct != contentTypeCEJSON {
+					t.Errorf("Expected Content-Type %q, got %q", contentTypeCEJSON, ct)
+				}
+			},
+		},
+		{
+			fault: faultContentType,
+			mode:  modeStructured,
+			expectTarget: func(t *testing.T, trg *jsonTarget) {
+				if ct := trg.Header.Get("Content-Type"); ct != contentTypeCEBatchJSON {
+					t.Errorf("Expected Content-Type %q, got %q", contentTypeCEBatchJSON, ct)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.fault+" in "+tc.mode+" mode", func(t *testing.T) {
+			opts := []GeneratorOption{
+				WithStamping(),
+				WithFaults(faultRate, []string{tc.fault}, oversizedDataSize),
+			}
+			switch tc.mode {
+			case modeStructured:
+				opts = append(opts, WithStructuredMode())
+			case modeBatch:
+				opts = append(opts, WithBatchMode(2))
+			}
+
+			g := NewCloudEventTargetsGenerator("http://localhost", "test.event", "cegen/go/test",
+				[]byte(`{"msg":"hello"}`), opts...)
+
+			trg, err := g.Generate()
+			if err != nil {
+				t.Fatal("Generate returned an error:", err)
+			}
+
+			var decTrg jsonTarget
+			decTrg.decode(&jlexer.Lexer{Data: trg})
+
+			if f := decTrg.Header.Get(headerFault); f != tc.fault {
+				t.Errorf("Expected %s header %q, got %q", headerFault, tc.fault, f)
+			}
+			if _, ok := decTrg.Header[headerStampSeq]; ok {
+				t.Error("Expected faulty event not to be stamped")
+			}
+
+			tc.expectTarget(t, &decTrg)
+		})
+	}
+
+	t.Run("duplicate ID", func(t *testing.T) {
+		g := NewCloudEventTargetsGenerator("http://localhost", "test.event", "cegen/go/test",
+			[]byte(`{"msg":"hello"}`), WithFaults(0.5, []string{faultDuplicateID}, 0), WithSeed(1))
+
+		var lastValidID string
+		var numFaults int
+
+		for i := 0; i < 100; i++ {
+			trg, err := g.Generate()
+			if err != nil {
+				t.Fatal("Generate returned an error:", err)
+			}
+
+			var decTrg jsonTarget
+			decTrg.decode(&jlexer.Lexer{Data: trg})
+
+			id := decTrg.Header.Get("Ce-Id")
+
+			if decTrg.Header.Get(headerFault) == "" {
+				lastValidID = id
+				continue
+			}
+
+			numFaults++
+			if lastValidID != "" && id != lastValidID {
+				t.Errorf("Expected ID of last valid event %s, got %s", lastValidID, id)
+			}
+		}
+
+		if numFaults == 0 {
+			t.Error("Expected some faulty targets")
+		}
+	})
+
+	t.Run("duplicate ID of the first target", func(t *testing.T) {
+		g := NewCloudEventTargetsGenerator("http://localhost", "test.event", "cegen/go/test",
+			[]byte(`{"msg":"hello"}`), WithFaults(faultRate, []string{faultDuplicateID}, 0))
+
+		g.initBufPools()
+
+		// the ID of the sample target encoded by initBufPools is never
+		// emitted, and must not be duplicated
+		if g.lastID != "" {
+			t.Errorf("Expected no previous valid event ID, got %s", g.lastID)
+		}
+	})
+
+	t.Run("sequence of valid events", func(t *testing.T) {
+		g := NewCloudEventTargetsGenerator("http://localhost", "test.event", "cegen/go/test",
+			[]byte(`{"msg":"hello"}`), WithStamping(), WithFaults(0.3, faultKinds, 64))
+
+		next := g.Targeter()
+
+		var seq uint64
+		for i := 0; i < 100; i++ {
+			var trg vegeta.Target
+			if err := next(&trg); err != nil {
+				t.Fatal("Error generating target:", err)
+			}
+
+			if trg.Header.Get(headerFault) != "" {
+				continue
+			}
+
+			seq++
+			if trgSeq := trg.Header.Get(headerStampSeq); trgSeq != strconv.FormatUint(seq, 10) {
+				t.Fatalf("Expected sequence number %d, got %s", seq, trgSeq)
+			}
+		}
+	})
+}
+
+// decodeTestEventAttrs decodes the attributes of a structured event.
+func decodeTestEventAttrs(t *testing.T, body []byte) map[string]interface{} {
+	t.Helper()
+
+	var e map[string]interface{}
+	if err := json.Unmarshal(body, &e); err != nil {
+		t.Fatalf("Body isn't a valid structured event: %s\n%s", err, body)
+	}
+	return e
+}
+
+func TestParseFaultKinds(t *testing.T) {
+	kinds, err := parseFaultKinds("missing-id,oversized")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(kinds) != 2 || kinds[0] != faultMissingID || kinds[1] != faultOversized {
+		t.Errorf("Unexpected fault kinds %v", kinds)
+	}
+
+	if _, err := parseFaultKinds("missing-id,unknown"); err == nil {
+		t.Error("Expected parsing to fail")
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// keyValueFlag is a repeatable flag.Value in the format "key=value".
+type keyValueFlag map[string]string
+
+var _ flag.Value = (keyValueFlag)(nil)
+
+// String implements flag.Value.
+func (f keyValueFlag) String() string {
+	kvs := make([]string, 0, len(f))
+	for k, v := range f {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+// Set implements flag.Value.
+func (f keyValueFlag) Set(kv string) error {
+	i := strings.IndexByte(kv, '=')
+	if i < 1 {
+		return fmt.Errorf("expected key=value, got %q", kv)
+	}
+
+	k, v := kv[:i], kv[i+1:]
+	if _, isSet := f[k]; isSet {
+		return fmt.Errorf("duplicate key %q", k)
+	}
+	f[k] = v
+
+	return nil
+}
+
+// headerFlag is a repeatable flag.Value in the format "Key: value".
+type headerFlag http.Header
+
+var _ flag.Value = (headerFlag)(nil)
+
+// String implements flag.Value.
+func (f headerFlag) String() string {
+	hs := make([]string, 0, len(f))
+	for k, vs := range f {
+		for _, v := range vs {
+			hs = append(hs, k+": "+v)
+		}
+	}
+	sort.Strings(hs)
+	return strings.Join(hs, ",")
+}
+
+// Set implements flag.Value.
+func (f headerFlag) Set(h string) error {
+	i := strings.IndexByte(h, ':')
+	if i < 1 {
+		return fmt.Errorf("expected 'Key: value', got %q", h)
+	}
+
+	k, v := strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:])
+	if k == "" || strings.ContainsAny(k, " \t") {
+		return fmt.Errorf("invalid header name %q", k)
+	}
+	http.Header(f).Add(k, v)
+
+	return nil
+}
+
+// stringsFlag is a repeatable flag.Value which accumulates values in order.
+type stringsFlag []string
+
+var _ flag.Value = (*stringsFlag)(nil)
+
+// String implements flag.Value.
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set implements flag.Value.
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
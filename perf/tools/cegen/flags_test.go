@@ -0,0 +1,72 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestKeyValueFlag(t *testing.T) {
+	f := make(keyValueFlag)
+
+	for _, kv := range []string{"region=eu", "empty=", "expr=a=b"} {
+		if err := f.Set(kv); err != nil {
+			t.Fatalf("Unexpected error setting %q: %s", kv, err)
+		}
+	}
+
+	expect := keyValueFlag{"region": "eu", "empty": "", "expr": "a=b"}
+	if !reflect.DeepEqual(f, expect) {
+		t.Errorf("Expected %v, got %v", expect, f)
+	}
+
+	if s := f.String(); s != "empty=,expr=a=b,region=eu" {
+		t.Errorf("Unexpected string representation %q", s)
+	}
+
+	for _, kv := range []string{"novalue", "=value", "region=us"} {
+		if err := f.Set(kv); err == nil {
+			t.Errorf("Expected setting %q to fail", kv)
+		}
+	}
+}
+
+func TestHeaderFlag(t *testing.T) {
+	f := make(headerFlag)
+
+	for _, h := range []string{"Authorization: Bearer abc", "x-custom:a", "X-Custom: b"} {
+		if err := f.Set(h); err != nil {
+			t.Fatalf("Unexpected error setting %q: %s", h, err)
+		}
+	}
+
+	expect := headerFlag{
+		"Authorization": []string{"Bearer abc"},
+		"X-Custom":      []string{"a", "b"},
+	}
+	if !reflect.DeepEqual(f, expect) {
+		t.Errorf("Expected %v, got %v", http.Header(expect), http.Header(f))
+	}
+
+	for _, h := range []string{"novalue", ": value", "Invalid Name: value"} {
+		if err := f.Set(h); err == nil {
+			t.Errorf("Expected setting %q to fail", h)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	jwriter "github.com/mailru/easyjson/jwriter"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Output formats of generated targets.
+const (
+	// vegeta JSON targets, one per line.
+	formatJSON = "json"
+	// vegeta HTTP targets, with bodies written to separate files.
+	// https://github.com/tsenart/vegeta#http-format
+	formatHTTP = "http"
+	// Structured CloudEvents, one per line.
+	formatNDJSON = "ndjson"
+	// JSON array of requests, which can be loaded by a k6 script.
+	formatK6 = "k6"
+)
+
+// targetWriter writes generated targets to an output, in a given format.
+type targetWriter interface {
+	// write writes a single target.
+	write(t *vegeta.Target) error
+	// close writes any remaining output after the last target.
+	close() error
+}
+
+// jsonTargetWriter is a targetWriter which writes targets in vegeta's JSON
+// format, one per line.
+type jsonTargetWriter struct {
+	w io.Writer
+}
+
+var _ targetWriter = (*jsonTargetWriter)(nil)
+
+// write implements targetWriter.
+func (w *jsonTargetWriter) write(t *vegeta.Target) error {
+	var jw jwriter.Writer
+
+	(*jsonTarget)(t).encode(&jw)
+	jw.RawByte('\n')
+
+	_, err := jw.DumpTo(w.w)
+	return err
+}
+
+// close implements targetWriter.
+func (*jsonTargetWriter) close() error {
+	return nil
+}
+
+// httpTargetWriter is a targetWriter which writes targets in vegeta's HTTP
+// format. The body of each target is written to a distinct file inside
+// bodyDir.
+type httpTargetWriter struct {
+	w       io.Writer
+	bodyDir string
+
+	// number of written targets
+	n   uint64
+	buf []byte
+}
+
+var _ targetWriter = (*httpTargetWriter)(nil)
+
+// newHTTPTargetWriter returns an httpTargetWriter which writes targets to w
+// and bodies to files inside bodyDir. The directory is created if it doesn't
+// exist.
+func newHTTPTargetWriter(w io.Writer, bodyDir string) (*httpTargetWriter, error) {
+	absDir, err := filepath.Abs(bodyDir)
+	if err != nil {
+		return nil, fmt.Errorf("converting %q to an absolute path: %w", bodyDir, err)
+	}
+
+	if err := os.MkdirAll(absDir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating body directory: %w", err)
+	}
+
+	return &httpTargetWriter{
+		w:       w,
+		bodyDir: absDir,
+	}, nil
+}
+
+// write implements targetWriter.
+func (w *httpTargetWriter) write(t *vegeta.Target) error {
+	w.n++
+
+	w.buf = append(w.buf[:0], t.Method...)
+	w.buf = append(w.buf, ' ')
+	w.buf = append(w.buf, t.URL...)
+	w.buf = append(w.buf, '\n')
+
+	for _, k := range sortedHeaderKeys(t.Header) {
+		for _, v := range t.Header[k] {
+			w.buf = append(w.buf, k...)
+			w.buf = append(w.buf, ": "...)
+			w.buf = append(w.buf, v...)
+			w.buf = append(w.buf, '\n')
+		}
+	}
+
+	if len(t.Body) > 0 {
+		bodyFile := filepath.Join(w.bodyDir, strconv.FormatUint(w.n, 10)+".body")
+		if err := ioutil.WriteFile(bodyFile, t.Body, 0o644); err != nil {
+			return fmt.Errorf("writing body file: %w", err)
+		}
+
+		w.buf = append(w.buf, '@')
+		w.buf = append(w.buf, bodyFile...)
+		w.buf = append(w.buf, '\n')
+	}
+
+	// targets are separated by empty lines
+	w.buf = append(w.buf, '\n')
+
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// close implements targetWriter.
+func (*httpTargetWriter) close() error {
+	return nil
+}
+
+// ndjsonTargetWriter is a targetWriter which writes the body of each target,
+// followed by a newline. Targets are expected to contain CloudEvents in
+// structured content mode.
+type ndjsonTargetWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+var _ targetWriter = (*ndjsonTargetWriter)(nil)
+
+// write implements targetWriter.
+func (w *ndjsonTargetWriter) write(t *vegeta.Target) error {
+	w.buf = append(w.buf[:0], t.Body...)
+	w.buf = append(w.buf, '\n')
+
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// close implements targetWriter.
+func (*ndjsonTargetWriter) close() error {
+	return nil
+}
+
+// k6TargetWriter is a targetWriter which writes targets as a JSON array of
+// request objects that can be passed to k6's http.request() function. Bodies
+// which aren't valid UTF-8 are base64-encoded in a "body_base64" attribute.
+// https://k6.io/docs/javascript-api/k6-http/request
+type k6TargetWriter struct {
+	w io.Writer
+
+	// number of written targets
+	n uint64
+}
+
+var _ targetWriter = (*k6TargetWriter)(nil)
+
+// write implements targetWriter.
+func (w *k6TargetWriter) write(t *vegeta.Target) error {
+	var jw jwriter.Writer
+
+	if w.n == 0 {
+		jw.RawString("[\n")
+	} else {
+		jw.RawString(",\n")
+	}
+	w.n++
+
+	jw.RawString(`{"method":`)
+	jw.String(t.Method)
+	jw.RawString(`,"url":`)
+	jw.String(t.URL)
+
+	jw.RawString(`,"headers":{`)
+	for i, k := range sortedHeaderKeys(t.Header) {
+		if i > 0 {
+			jw.RawByte(',')
+		}
+		jw.String(k)
+		jw.RawByte(':')
+		jw.String(strings.Join(t.Header[k], ", "))
+	}
+	jw.RawByte('}')
+
+	// binary bodies can't be represented as JSON strings
+	if utf8.Valid(t.Body) {
+		jw.RawString(`,"body":`)
+		jw.String(string(t.Body))
+	} else {
+		jw.RawString(`,"body_base64":`)
+		jw.Base64Bytes(t.Body)
+	}
+	jw.RawByte('}')
+
+	_, err := jw.DumpTo(w.w)
+	return err
+}
+
+// close implements targetWriter.
+func (w *k6TargetWriter) close() error {
+	end := "\n]\n"
+	if w.n == 0 {
+		end = "[]\n"
+	}
+
+	_, err := io.WriteString(w.w, end)
+	return err
+}
+
+// k6Script is a k6 script which sends the requests contained in a file
+// written by a k6TargetWriter, in order, and starts over from the first
+// request after the last one has been sent.
+const k6Script = `import http from 'k6/http';
+import exec from 'k6/execution';
+import encoding from 'k6/encoding';
+import { SharedArray } from 'k6/data';
+
+// Requests generated by cegen with the '-format=k6' flag.
+const targets = new SharedArray('targets', function () {
+  return JSON.parse(open(__ENV.CEGEN_TARGETS || './targets.json'));
+});
+
+export default function () {
+  const t = targets[exec.scenario.iterationInTest % targets.length];
+  const body = t.body_base64 !== undefined ? encoding.b64decode(t.body_base64) : t.body;
+  http.request(t.method, t.url, body, { headers: t.headers });
+}
+`
+
+// writeK6Script writes a k6 script which sends the requests written by a
+// k6TargetWriter to the file at the given path.
+func writeK6Script(path string) error {
+	if err := ioutil.WriteFile(path, []byte(k6Script), 0o644); err != nil {
+		return fmt.Errorf("writing k6 script: %w", err)
+	}
+	return nil
+}
+
+// sortedHeaderKeys returns the keys of the given HTTP headers, sorted.
+func sortedHeaderKeys(h map[string][]string) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+const numFormatTargets = 3
+
+func TestHTTPTargetWriter(t *testing.T) {
+	bodyDir := filepath.Join(t.TempDir(), "bodies")
+
+	var out strings.Builder
+
+	tw, err := newHTTPTargetWriter(&out, bodyDir)
+	if err != nil {
+		t.Fatal("Error creating target writer:", err)
+	}
+
+	writeTargets(t, tw, NewCloudEventTargetsGenerator("http://localhost", "test.event", "cegen/go/test",
+		[]byte(`{"msg":"hi"}`)))
+
+	// the output must be readable by vegeta
+	next := vegeta.NewHTTPTargeter(strings.NewReader(out.String()), nil, nil)
+
+	for i := 0; i < numFormatTargets; i++ {
+		var trg vegeta.Target
+		if err := next(&trg); err != nil {
+			t.Fatalf("Error reading target %d: %s\n%s", i, err, out.String())
+		}
+
+		if trg.Method != http.MethodPost {
+			t.Errorf("Unexpected method %q", trg.Method)
+		}
+		if trg.URL != "http://localhost" {
+			t.Errorf("Unexpected URL %q", trg.URL)
+		}
+		if typ := trg.Header.Get("Ce-Type"); typ != "test.event" {
+			t.Errorf("Unexpected Ce-Type header %q", typ)
+		}
+		if body := string(trg.Body); body != `{"msg":"hi"}` {
+			t.Errorf("Unexpected body %q", body)
+		}
+	}
+
+	if err := next(&vegeta.Target{}); err != vegeta.ErrNoTargets {
+		t.Error("Expected no more targets, got error:", err)
+	}
+
+	bodyFiles, err := ioutil.ReadDir(bodyDir)
+	if err != nil {
+		t.Fatal("Error reading body directory:", err)
+	}
+	if l := len(bodyFiles); l != numFormatTargets {
+		t.Errorf("Expected %d body files, got %d", numFormatTargets, l)
+	}
+}
+
+func TestNDJSONTargetWriter(t *testing.T) {
+	var out strings.Builder
+
+	tw := &ndjsonTargetWriter{w: &out}
+
+	writeTargets(t, tw, NewCloudEventTargetsGenerator("http://localhost", "test.event", "cegen/go/test",
+		[]byte(`{"msg":"hi"}`), WithStructuredMode()))
+
+	s := bufio.NewScanner(strings.NewReader(out.String()))
+
+	var numEvents int
+	for ; s.Scan(); numEvents++ {
+		var e testStructuredEvent
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("Line isn't a valid event: %s\n%s", err, s.Bytes())
+		}
+		assertStructuredEvent(t, e, "test.event", "cegen/go/test")
+	}
+
+	if numEvents != numFormatTargets {
+		t.Errorf("Expected %d events, got %d", numFormatTargets, numEvents)
+	}
+}
+
+func TestK6TargetWriter(t *testing.T) {
+	type k6Request struct {
+		Method     string            `json:"method"`
+		URL        string            `json:"url"`
+		Headers    map[string]string `json:"headers"`
+		Body       string            `json:"body"`
+		BodyBase64 []byte            `json:"body_base64"`
+	}
+
+	t.Run("with targets", func(t *testing.T) {
+		var out strings.Builder
+
+		tw := &k6TargetWriter{w: &out}
+
+		writeTargets(t, tw, NewCloudEventTargetsGenerator("http://localhost", "test.event", "cegen/go/test",
+			[]byte(`{"msg":"hi"}`)))
+
+		var reqs []k6Request
+		if err := json.Unmarshal([]byte(out.String()), &reqs); err != nil {
+			t.Fatalf("Output isn't a valid JSON array: %s\n%s", err, out.String())
+		}
+
+		if l := len(reqs); l != numFormatTargets {
+			t.Fatalf("Expected %d requests, got %d", numFormatTargets, l)
+		}
+
+		for _, r := range reqs {
+			if r.Method != http.MethodPost || r.URL != "http://localhost" {
+				t.Errorf("Unexpected request line %s %s", r.Method, r.URL)
+			}
+			if typ := r.Headers["Ce-Type"]; typ != "test.event" {
+				t.Errorf("Unexpected Ce-Type header %q", typ)
+			}
+			if r.Body != `{"msg":"hi"}` {
+				t.Errorf("Unexpected body %q", r.Body)
+			}
+		}
+	})
+
+	t.Run("with binary bodies", func(t *testing.T) {
+		var out strings.Builder
+
+		tw := &k6TargetWriter{w: &out}
+
+		binData := []byte{0xff, 0x00, 0xfe}
+
+		writeTargets(t, tw, NewWeightedCloudEventTargetsGenerator("http://localhost", []*EventProfile{{
+			Type:            "test.event",
+			Source:          "cegen/go/test",
+			DataContentType: contentTypeOctetStream,
+			Data:            binData,
+		}}))
+
+		var reqs []k6Request
+		if err := json.Unmarshal([]byte(out.String()), &reqs); err != nil {
+			t.Fatalf("Output isn't a valid JSON array: %s\n%s", err, out.String())
+		}
+
+		for _, r := range reqs {
+			if r.Body != "" || !bytes.Equal(r.BodyBase64, binData) {
+				t.Errorf("Expected base64-encoded body, got %q, %q", r.Body, r.BodyBase64)
+			}
+		}
+	})
+
+	t.Run("without target", func(t *testing.T) {
+		var out strings.Builder
+
+		tw := &k6TargetWriter{w: &out}
+		if err := tw.close(); err != nil {
+			t.Fatal("Error closing target writer:", err)
+		}
+
+		var reqs []k6Request
+		if err := json.Unmarshal([]byte(out.String()), &reqs); err != nil {
+			t.Fatalf("Output isn't a valid JSON array: %s\n%s", err, out.String())
+		}
+		if len(reqs) != 0 {
+			t.Errorf("Expected no request, got %d", len(reqs))
+		}
+	})
+}
+
+// writeTargets writes targets yielded by the given generator using tw.
+func writeTargets(t *testing.T, tw targetWriter, g *CloudEventTargetsGenerator) {
+	t.Helper()
+
+	next := g.Targeter()
+
+	for i := 0; i < numFormatTargets; i++ {
+		var trg vegeta.Target
+		if err := next(&trg); err != nil {
+			t.Fatal("Error generating target:", err)
+		}
+		if err := tw.write(&trg); err != nil {
+			t.Fatal("Error writing target:", err)
+		}
+	}
+
+	if err := tw.close(); err != nil {
+		t.Fatal("Error closing target writer:", err)
+	}
+}
@@ -0,0 +1,865 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/mailru/easyjson/buffer"
+	jwriter "github.com/mailru/easyjson/jwriter"
+	uuid "github.com/rogpeppe/fastuuid"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Extension attributes set on stamped events.
+const (
+	// Sequence number of the event, starting at 1.
+	// https://github.com/cloudevents/spec/blob/v1.0.1/extensions/sequence.md
+	extStampSeq = "sequence"
+	// Time at which the event was generated, in RFC 3339 format.
+	extStampTime = "sendtime"
+
+	headerStampSeq  = "Ce-Sequence"
+	headerStampTime = "Ce-Sendtime"
+)
+
+// Headers of optional context attributes in binary content mode.
+const (
+	headerSubject    = "Ce-Subject"
+	headerDataSchema = "Ce-Dataschema"
+	headerTime       = "Ce-Time"
+)
+
+// timeNow is the value of the time attribute which is replaced with the time
+// at which each event is generated.
+const timeNow = "now"
+
+// CloudEventTargetsGenerator generates CloudEvent vegeta targets.
+type CloudEventTargetsGenerator struct {
+	// URLs of the receivers to send targets to, strategy used to
+	// distribute targets across those URLs, and position of the next URL
+	// in round-robin distribution.
+	targetURLs   []string
+	distribution string
+	nextURLPos   int
+
+	// Number of distinct partition keys drawn for targets, and partition
+	// key of the current target. Partition keys are disabled when 0.
+	numPartitionKeys uint64
+	partitionKey     string
+
+	uuidGen idGenerator
+
+	// Profiles of generated events, and schedule in which those profiles
+	// are interleaved. Each entry of the schedule is an index in profiles.
+	profiles []eventProfile
+	schedule []int
+	// Position of the next event in the schedule.
+	schedPos int
+
+	// Buffer in which templated and synthetic data is rendered.
+	dataBuf []byte
+	// Whether synthetic data is rendered with its maximum size, to
+	// determine the size of buffers.
+	maxDataSize bool
+	// Sequence number of the last generated event.
+	seq uint64
+	// State used for rendering templated data.
+	tmplCtx templateContext
+
+	// Content mode of generated CloudEvents, and number of events per
+	// request in batch mode.
+	mode      string
+	batchSize int
+	// Whether events are encoded in the protobuf event format instead of
+	// the JSON event format, in structured and batch modes.
+	protobuf bool
+	// Buffer in which each event of a batch is encoded in the protobuf
+	// event format.
+	protoEventBuf []byte
+
+	// Whether events are stamped with their sequence number and
+	// generation time.
+	stamp bool
+	// Buffer in which stamp attributes are formatted.
+	stampBuf []byte
+
+	// Value of the time context attribute of generated events, either
+	// a RFC 3339 timestamp or timeNow. The attribute is omitted when empty.
+	timeAttr string
+	// Parsed value of timeAttr, when it is a timestamp.
+	timeVal time.Time
+	// Additional HTTP headers set on each target.
+	headers http.Header
+
+	// Probability of a target being faulty, kinds of faults drawn for
+	// faulty targets, and size and payload of the data of oversized events.
+	faultRate         float64
+	faultKinds        []string
+	oversizedDataSize int
+	oversizedData     []byte
+	// Kind of fault of the current target. Empty for valid targets.
+	fault string
+	// Whether the current target still has to carry an oversized event.
+	// Only one event per target is oversized, including in batch mode.
+	oversizedPending bool
+	// ID of the last valid event.
+	lastID string
+
+	// Once used to initialize the buffer pools on the first call to Generate.
+	bufOnce sync.Once
+	// Buffer pool for jwriter.Writer's underlying Buffer and output.
+	writerBufPool *sync.Pool
+	// Buffer pool for request bodies in structured and batch modes.
+	bodyBufPool *sync.Pool
+
+	// First error which occurred while converting event data.
+	err error
+}
+
+// eventProfile is the internal representation of an EventProfile.
+type eventProfile struct {
+	typeAttr   string
+	sourceAttr string
+	// Extension attributes, sorted by name.
+	extensions []extensionAttr
+
+	// Optional context attributes, omitted when empty.
+	subject    string
+	dataSchema string
+	// Content type of the data, which is always set.
+	dataContentType string
+
+	data []byte
+
+	// Template rendered into the data of each event. When nil, all events
+	// carry the same static data.
+	tmpl *dataTemplate
+	// Generator of synthetic data with variable sizes. Takes precedence
+	// over data and tmpl.
+	synth *syntheticData
+	// Whether data is a valid JSON value which can be embedded as is in
+	// structured events, instead of being base64-encoded.
+	dataIsJSON bool
+
+	// Codec used to convert rendered templates to Avro binary data. Static
+	// data is converted once, when the profile is created.
+	avro *goavro.Codec
+	// Buffer in which converted Avro data is written.
+	avroBuf []byte
+
+	// Vendor of the webhook requests generated instead of CloudEvents.
+	webhook       string
+	webhookSecret string
+	// Signer of GitHub payloads, nil when requests aren't signed.
+	webhookSigner *webhookSigner
+}
+
+// extensionAttr is a CloudEvent extension attribute.
+type extensionAttr struct {
+	name  string
+	value string
+	// name of the attribute as a HTTP header, in binary content mode
+	header string
+}
+
+// GeneratorOption is a functional option for a CloudEventTargetsGenerator.
+type GeneratorOption func(*CloudEventTargetsGenerator)
+
+// WithStructuredMode sets the generator to yield events in structured content
+// mode.
+func WithStructuredMode() GeneratorOption {
+	return func(g *CloudEventTargetsGenerator) {
+		g.mode = modeStructured
+	}
+}
+
+// WithBatchMode sets the generator to yield batches of the given number of
+// events in batched content mode.
+func WithBatchMode(size int) GeneratorOption {
+	return func(g *CloudEventTargetsGenerator) {
+		g.mode = modeBatch
+		g.batchSize = size
+	}
+}
+
+// WithProtobufFormat sets the generator to encode events in the CloudEvents
+// protobuf format instead of the JSON format. It only affects events in
+// structured and batch content modes.
+func WithProtobufFormat() GeneratorOption {
+	return func(g *CloudEventTargetsGenerator) {
+		g.protobuf = true
+	}
+}
+
+// WithStamping sets the generator to stamp each event with extension
+// attributes carrying its sequence number and the time at which it was
+// generated.
+func WithStamping() GeneratorOption {
+	return func(g *CloudEventTargetsGenerator) {
+		g.stamp = true
+	}
+}
+
+// WithTimeAttribute sets the generator to set the time context attribute of
+// each event to the given RFC 3339 timestamp, or to the time at which the
+// event is generated if the value is "now".
+func WithTimeAttribute(value string) GeneratorOption {
+	return func(g *CloudEventTargetsGenerator) {
+		g.timeAttr = value
+		if value != timeNow {
+			g.timeVal, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+}
+
+// WithHeaders sets the generator to add the given HTTP headers to each
+// target. Those headers take precedence over the ones set by the generator.
+func WithHeaders(h http.Header) GeneratorOption {
+	return func(g *CloudEventTargetsGenerator) {
+		g.headers = h
+	}
+}
+
+// WithSeed sets the generator to derive event IDs and random template values
+// from the given seed, so that the same sequence of targets is generated on
+// every run. Values based on the current time are not affected.
+func WithSeed(seed int64) GeneratorOption {
+	return func(g *CloudEventTargetsGenerator) {
+		rnd := rand.New(rand.NewSource(seed))
+
+		g.uuidGen = &seededIDGenerator{rand: rnd}
+		g.tmplCtx.rand = rnd
+		g.tmplCtx.uuidGen = g.uuidGen
+	}
+}
+
+// NewCloudEventTargetsGenerator returns a generator that yields vegeta JSON
+// targets containing CloudEvents with static data and IDs that are guaranteed
+// to be unique.
+// Events are generated in binary content mode, unless specified otherwise via
+// a GeneratorOption.
+func NewCloudEventTargetsGenerator(url, typeAttr, sourceAttr string, data []byte,
+	opts ...GeneratorOption) *CloudEventTargetsGenerator {
+
+	return NewWeightedCloudEventTargetsGenerator(url, []*EventProfile{{
+		Type:   typeAttr,
+		Source: sourceAttr,
+		Data:   data,
+	}}, opts...)
+}
+
+// NewWeightedCloudEventTargetsGenerator returns a generator that yields vegeta
+// JSON targets containing CloudEvents which match the given profiles, in
+// proportions that respect the weight of each profile.
+// Events are generated in binary content mode, unless specified otherwise via
+// a GeneratorOption.
+func NewWeightedCloudEventTargetsGenerator(url string, profiles []*EventProfile,
+	opts ...GeneratorOption) *CloudEventTargetsGenerator {
+
+	g := &CloudEventTargetsGenerator{
+		targetURLs: []string{url},
+		uuidGen:    uuid.MustNewGenerator(),
+		mode:       modeBinary,
+		batchSize:  1,
+	}
+
+	g.tmplCtx = templateContext{
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		uuidGen: g.uuidGen,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	g.initFaults()
+
+	weights := make([]uint, len(profiles))
+
+	g.profiles = make([]eventProfile, len(profiles))
+	for i, p := range profiles {
+		g.profiles[i] = g.newEventProfile(p)
+		weights[i] = p.Weight
+	}
+
+	g.schedule = weightedSchedule(weights)
+
+	return g
+}
+
+// newEventProfile returns the internal representation of the given
+// EventProfile.
+func (g *CloudEventTargetsGenerator) newEventProfile(p *EventProfile) eventProfile {
+	ep := eventProfile{
+		typeAttr:        p.Type,
+		sourceAttr:      p.Source,
+		subject:         p.Subject,
+		dataSchema:      p.DataSchema,
+		dataContentType: p.DataContentType,
+		data:            p.Data,
+		tmpl:            p.Template,
+		webhook:         p.Webhook,
+		webhookSecret:   p.WebhookSecret,
+	}
+
+	if ep.webhook == webhookGitHub && ep.webhookSecret != "" {
+		ep.webhookSigner = newWebhookSigner(ep.webhookSecret)
+	}
+	if ep.webhook != "" && len(ep.data) == 0 && ep.tmpl == nil && p.Sizes == nil {
+		ep.tmpl = defaultWebhookPayload(ep.webhook)
+	}
+
+	if ep.dataContentType == "" {
+		ep.dataContentType = contentTypeJSON
+	}
+	isJSONContentType := isJSONMediaType(ep.dataContentType)
+
+	for name, val := range p.Extensions {
+		ep.extensions = append(ep.extensions, extensionAttr{
+			name:   name,
+			value:  val,
+			header: http.CanonicalHeaderKey("Ce-" + name),
+		})
+	}
+	sort.Slice(ep.extensions, func(i, j int) bool {
+		return ep.extensions[i].name < ep.extensions[j].name
+	})
+
+	if p.Sizes != nil {
+		ep.synth = newSyntheticData(p.Sizes, g.tmplCtx.rand)
+		ep.dataIsJSON = isJSONContentType
+		return ep
+	}
+
+	if p.AvroCodec != nil {
+		if p.DataContentType == "" {
+			ep.dataContentType = contentTypeAvro
+		}
+
+		if ep.tmpl != nil {
+			ep.avro = p.AvroCodec
+			return ep
+		}
+
+		var err error
+		if ep.data, err = avroFromText(nil, p.AvroCodec, ep.data); err != nil && g.err == nil {
+			g.err = err
+		}
+		return ep
+	}
+
+	sampleData := ep.data
+	if ep.tmpl != nil {
+		sampleData = ep.tmpl.render(nil, &g.tmplCtx)
+	}
+	ep.dataIsJSON = isJSONContentType && json.Valid(sampleData)
+
+	return ep
+}
+
+// Generate returns a target serialized as JSON.
+func (g *CloudEventTargetsGenerator) Generate() ([]byte, error) {
+	// encode a sample target to determine the size of buffers in sync pools
+	g.bufOnce.Do(g.initBufPools)
+
+	if g.drawFault() {
+		// faulty targets don't consume sequence numbers, nor
+		// positions in the round-robin distribution
+		defer func(seq uint64, schedPos, nextURLPos int) {
+			g.seq, g.schedPos, g.nextURLPos = seq, schedPos, nextURLPos
+		}(g.seq, g.schedPos, g.nextURLPos)
+	}
+
+	var t jsonTarget
+
+	t.Method = http.MethodPost
+	t.URL = g.nextTargetURL()
+
+	var body []byte
+
+	if g.mode != modeBinary {
+		bodyWriterBuf := g.bodyBufPool.Get().([]byte)
+		bodyBuf := g.bodyBufPool.Get().([]byte)
+		defer g.bodyBufPool.Put(bodyBuf[:0])
+		defer g.bodyBufPool.Put(bodyWriterBuf[:0])
+
+		if g.protobuf {
+			body = g.appendProtoBody(bodyBuf)
+		} else {
+			jw := &jwriter.Writer{
+				Buffer: buffer.Buffer{
+					Buf: bodyWriterBuf,
+				},
+			}
+
+			g.encodeBody(jw)
+
+			var err error
+			if body, err = jw.BuildBytes(bodyBuf); err != nil {
+				return nil, fmt.Errorf("encoding request body: %w", err)
+			}
+		}
+	}
+
+	g.setHeaderAndBody(&t, body)
+
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	writerBuf := g.writerBufPool.Get().([]byte)
+	buildBuf := g.writerBufPool.Get().([]byte)
+	defer g.writerBufPool.Put(buildBuf[:0])
+	defer g.writerBufPool.Put(writerBuf[:0])
+
+	jw := &jwriter.Writer{
+		Buffer: buffer.Buffer{
+			Buf: writerBuf,
+		},
+	}
+
+	t.encode(jw)
+
+	return jw.BuildBytes(buildBuf)
+}
+
+// seek positions the generator so that the next target it yields is the one
+// at the given 0-based position in the sequence of targets, as far as
+// sequence numbers, profiles and round-robin distribution are concerned.
+func (g *CloudEventTargetsGenerator) seek(pos uint64) {
+	g.seq = pos * uint64(g.batchSize)
+	g.schedPos = int(g.seq % uint64(len(g.schedule)))
+	g.nextURLPos = int(pos % uint64(len(g.targetURLs)))
+}
+
+// initBufPools initializes the generator's buffer pools with buffers sized
+// after the encoding of a sample target.
+func (g *CloudEventTargetsGenerator) initBufPools() {
+	// encoding a sample target must not alter the sequence of events, nor
+	// the ID duplicated by faulty targets
+	defer func(seq uint64, schedPos, nextURLPos int, lastID string) {
+		g.seq, g.schedPos, g.nextURLPos, g.lastID = seq, schedPos, nextURLPos, lastID
+	}(g.seq, g.schedPos, g.nextURLPos, g.lastID)
+
+	// size buffers after the largest possible payloads
+	g.maxDataSize = true
+	defer func() { g.maxDataSize = false }()
+
+	var t jsonTarget
+
+	t.Method = http.MethodPost
+	t.URL = g.nextTargetURL()
+
+	var bodyBytes []byte
+	switch {
+	case g.mode == modeBinary:
+	case g.protobuf:
+		bodyBytes = g.appendProtoBody(nil)
+	default:
+		var jw jwriter.Writer
+		g.encodeBody(&jw)
+		bodyBytes, _ = jw.BuildBytes()
+	}
+	bodySize := len(bodyBytes)
+
+	g.bodyBufPool = &sync.Pool{
+		New: func() interface{} {
+			return make([]byte, 0, bodySize)
+		},
+	}
+
+	g.setHeaderAndBody(&t, bodyBytes)
+
+	var jw jwriter.Writer
+	t.encode(&jw)
+	dataBytes, _ := jw.BuildBytes()
+	dataSize := len(dataBytes)
+
+	g.writerBufPool = &sync.Pool{
+		New: func() interface{} {
+			return make([]byte, 0, dataSize)
+		},
+	}
+}
+
+// setHeaderAndBody sets the HTTP headers and body of the given target
+// according to the generator's content mode. In binary mode, the body is
+// always the event's data, and the given body is ignored.
+func (g *CloudEventTargetsGenerator) setHeaderAndBody(t *jsonTarget, body []byte) {
+	// we avoid using http.Header.Set() because it attempts to
+	// sanitize every input, making it more expensive than
+	// accessing the Header map directly.
+
+	switch g.mode {
+	case modeStructured:
+		ct := contentTypeCEJSON
+		if g.protobuf {
+			ct = contentTypeCEProtobuf
+		}
+
+		t.Header = http.Header{
+			"Content-Type": []string{ct},
+		}
+		t.Body = body
+
+	case modeBatch:
+		ct := contentTypeCEBatchJSON
+		if g.protobuf {
+			ct = contentTypeCEBatchProtobuf
+		}
+
+		t.Header = http.Header{
+			"Content-Type": []string{ct},
+		}
+		t.Body = body
+
+	default:
+		p := g.nextEvent()
+
+		if p.webhook != "" {
+			g.setWebhookHeaderAndBody(t, p)
+			break
+		}
+
+		t.Header = http.Header{
+			"Ce-Id":          []string{g.eventID()},
+			"Ce-Type":        []string{p.typeAttr},
+			"Ce-Source":      []string{p.sourceAttr},
+			"Ce-Specversion": []string{"1.0"},
+			"Content-Type":   []string{p.dataContentType},
+		}
+		if p.subject != "" {
+			t.Header[headerSubject] = []string{p.subject}
+		}
+		if p.dataSchema != "" {
+			t.Header[headerDataSchema] = []string{p.dataSchema}
+		}
+		if g.timeAttr != "" {
+			t.Header[headerTime] = []string{string(g.appendTime(nil))}
+		}
+		for _, ext := range p.extensions {
+			t.Header[ext.header] = []string{ext.value}
+		}
+		if g.numPartitionKeys > 0 {
+			t.Header[headerPartitionKey] = []string{g.partitionKey}
+		}
+		if g.stamp && g.fault == "" {
+			g.stampBuf = g.appendStampSeq(g.stampBuf[:0])
+			t.Header[headerStampSeq] = []string{string(g.stampBuf)}
+			g.stampBuf = g.appendStampTime(g.stampBuf[:0])
+			t.Header[headerStampTime] = []string{string(g.stampBuf)}
+		}
+
+		t.Body = g.eventData(p)
+	}
+
+	if g.fault != "" {
+		g.applyFault(t)
+	}
+
+	for k, v := range g.headers {
+		t.Header[k] = v
+	}
+}
+
+// encodeBody writes the body of a structured or batched request to the given
+// jwriter.Writer.
+func (g *CloudEventTargetsGenerator) encodeBody(out *jwriter.Writer) {
+	if g.mode != modeBatch {
+		g.encodeStructuredEvent(out)
+		return
+	}
+
+	out.RawByte('[')
+	for i := 0; i < g.batchSize; i++ {
+		if i > 0 {
+			out.RawByte(',')
+		}
+		g.encodeStructuredEvent(out)
+	}
+	out.RawByte(']')
+}
+
+// encodeStructuredEvent writes a CloudEvent in the JSON event format to the
+// given jwriter.Writer.
+// https://github.com/cloudevents/spec/blob/v1.0.1/json-format.md
+func (g *CloudEventTargetsGenerator) encodeStructuredEvent(out *jwriter.Writer) {
+	p := g.nextEvent()
+
+	if g.fault == faultSpecVersion {
+		out.RawString(`{"specversion":"` + faultySpecVersion + `"`)
+	} else {
+		out.RawString(`{"specversion":"1.0"`)
+	}
+	if g.fault != faultMissingID {
+		out.RawString(`,"id":`)
+		out.String(g.eventID())
+	}
+	out.RawString(`,"type":`)
+	out.String(p.typeAttr)
+	if g.fault != faultMissingSource {
+		out.RawString(`,"source":`)
+		out.String(p.sourceAttr)
+	}
+
+	if p.subject != "" {
+		out.RawString(`,"subject":`)
+		out.String(p.subject)
+	}
+	if p.dataSchema != "" {
+		out.RawString(`,"dataschema":`)
+		out.String(p.dataSchema)
+	}
+	if g.timeAttr != "" {
+		out.RawString(`,"time":"`)
+		g.stampBuf = g.appendTime(g.stampBuf[:0])
+		out.Raw(g.stampBuf, nil)
+		out.RawByte('"')
+	}
+
+	out.RawString(`,"datacontenttype":`)
+	out.String(p.dataContentType)
+
+	for _, ext := range p.extensions {
+		out.RawByte(',')
+		out.String(ext.name)
+		out.RawByte(':')
+		out.String(ext.value)
+	}
+
+	if g.numPartitionKeys > 0 {
+		out.RawString(`,"` + extPartitionKey + `":`)
+		out.String(g.partitionKey)
+	}
+
+	if g.stamp && g.fault == "" {
+		out.RawString(`,"` + extStampSeq + `":"`)
+		g.stampBuf = g.appendStampSeq(g.stampBuf[:0])
+		out.Raw(g.stampBuf, nil)
+		out.RawString(`","` + extStampTime + `":"`)
+		g.stampBuf = g.appendStampTime(g.stampBuf[:0])
+		out.Raw(g.stampBuf, nil)
+		out.RawByte('"')
+	}
+
+	data := g.eventData(p)
+
+	if p.dataIsJSON {
+		out.RawString(`,"data":`)
+		out.Raw(data, nil)
+	} else {
+		out.RawString(`,"data_base64":`)
+		out.Base64Bytes(data)
+	}
+
+	out.RawByte('}')
+}
+
+// nextEvent increments the event sequence number and returns the profile of
+// the corresponding event.
+func (g *CloudEventTargetsGenerator) nextEvent() *eventProfile {
+	g.seq++
+
+	p := &g.profiles[g.schedule[g.schedPos]]
+
+	if g.schedPos++; g.schedPos == len(g.schedule) {
+		g.schedPos = 0
+	}
+
+	return p
+}
+
+// eventData returns the data of the current event, which matches the given
+// profile.
+// The returned slice is only valid until the next call to eventData.
+func (g *CloudEventTargetsGenerator) eventData(p *eventProfile) []byte {
+	if g.oversizedPending {
+		g.oversizedPending = false
+		return g.oversizedData
+	}
+
+	if p.synth != nil {
+		var size int
+		if g.maxDataSize {
+			size = p.synth.sizes.max()
+		} else {
+			size = p.synth.sizes.next(g.tmplCtx.rand)
+		}
+
+		g.dataBuf = p.synth.render(g.dataBuf[:0], size)
+		return g.dataBuf
+	}
+
+	if p.tmpl == nil {
+		return p.data
+	}
+
+	g.tmplCtx.seq = g.seq
+	g.dataBuf = p.tmpl.render(g.dataBuf[:0], &g.tmplCtx)
+
+	if p.avro == nil {
+		return g.dataBuf
+	}
+
+	var err error
+	if p.avroBuf, err = avroFromText(p.avroBuf[:0], p.avro, g.dataBuf); err != nil && g.err == nil {
+		g.err = err
+	}
+	return p.avroBuf
+}
+
+// appendStampSeq appends the value of the sequence extension attribute of the
+// current event to dst and returns the extended buffer.
+func (g *CloudEventTargetsGenerator) appendStampSeq(dst []byte) []byte {
+	return strconv.AppendUint(dst, g.seq, 10)
+}
+
+// appendStampTime appends the value of the send time extension attribute of
+// the current event to dst and returns the extended buffer.
+func (g *CloudEventTargetsGenerator) appendStampTime(dst []byte) []byte {
+	return time.Now().AppendFormat(dst, time.RFC3339Nano)
+}
+
+// appendTime appends the value of the time context attribute of the current
+// event to dst and returns the extended buffer.
+func (g *CloudEventTargetsGenerator) appendTime(dst []byte) []byte {
+	if g.timeAttr == timeNow {
+		return time.Now().AppendFormat(dst, time.RFC3339Nano)
+	}
+	return append(dst, g.timeAttr...)
+}
+
+// isJSONMediaType returns whether the given media type denotes JSON data.
+func isJSONMediaType(mediaType string) bool {
+	if i := strings.IndexByte(mediaType, ';'); i != -1 {
+		mediaType = mediaType[:i]
+	}
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	return mediaType == contentTypeJSON || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Targeter returns a vegeta.Targeter which yields targets from the generator.
+// The returned Targeter is safe for concurrent use, and the targets it yields
+// can be retained by the caller.
+func (g *CloudEventTargetsGenerator) Targeter() vegeta.Targeter {
+	var mu sync.Mutex
+
+	return func(tgt *vegeta.Target) error {
+		if tgt == nil {
+			return vegeta.ErrNilTarget
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if g.drawFault() {
+			// faulty targets don't consume sequence numbers, nor
+			// positions in the round-robin distribution
+			defer func(seq uint64, schedPos, nextURLPos int) {
+				g.seq, g.schedPos, g.nextURLPos = seq, schedPos, nextURLPos
+			}(g.seq, g.schedPos, g.nextURLPos)
+		}
+
+		t := (*jsonTarget)(tgt)
+
+		t.Method = http.MethodPost
+		t.URL = g.nextTargetURL()
+
+		var body []byte
+
+		switch {
+		case g.mode == modeBinary:
+		case g.protobuf:
+			body = g.appendProtoBody(nil)
+		default:
+			var jw jwriter.Writer
+			g.encodeBody(&jw)
+
+			var err error
+			if body, err = jw.BuildBytes(); err != nil {
+				return fmt.Errorf("encoding request body: %w", err)
+			}
+		}
+
+		g.setHeaderAndBody(t, body)
+
+		if g.err != nil {
+			return g.err
+		}
+
+		// in binary mode, the body may be backed by a buffer that is
+		// reused by the generator
+		if g.mode == modeBinary {
+			t.Body = append([]byte(nil), t.Body...)
+		}
+
+		return nil
+	}
+}
+
+// idGenerator generates unique IDs.
+type idGenerator interface {
+	// Hex128 returns a RFC 4122 V4 UUID.
+	Hex128() string
+}
+
+// seededIDGenerator is an idGenerator which yields UUIDs from a deterministic
+// source of randomness.
+type seededIDGenerator struct {
+	rand *rand.Rand
+}
+
+var (
+	_ idGenerator = (*seededIDGenerator)(nil)
+	_ idGenerator = (*uuid.Generator)(nil)
+)
+
+// Hex128 implements idGenerator.
+func (g *seededIDGenerator) Hex128() string {
+	var u [16]byte
+	_, _ = g.rand.Read(u[:])
+
+	// Version 4.
+	u[6] = u[6]&0x0f | 0x40
+	// RFC 4122 variant.
+	u[8] = u[8]&0x3f | 0x80
+
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:16])
+
+	return string(b[:])
+}
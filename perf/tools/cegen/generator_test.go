@@ -0,0 +1,440 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mailru/easyjson/jlexer"
+)
+
+func TestContentModes(t *testing.T) {
+	const (
+		url = "http://localhost"
+		typ = "test.event"
+		src = "cegen/go/test"
+	)
+
+	testCases := []struct {
+		name        string
+		data        []byte
+		opts        []GeneratorOption
+		expectCType string
+		expectBody  func(t *testing.T, body []byte)
+	}{
+		{
+			name:        "binary",
+			data:        []byte(`{"msg":"hello"}`),
+			expectCType: contentTypeJSON,
+			expectBody: func(t *testing.T, body []byte) {
+				if string(body) != `{"msg":"hello"}` {
+					t.Errorf("Expected body to contain the event data, got %s", body)
+				}
+			},
+		},
+		{
+			name:        "structured",
+			data:        []byte(`{"msg":"hello"}`),
+			opts:        []GeneratorOption{WithStructuredMode()},
+			expectCType: contentTypeCEJSON,
+			expectBody: func(t *testing.T, body []byte) {
+				var e testStructuredEvent
+				if err := json.Unmarshal(body, &e); err != nil {
+					t.Fatalf("Body isn't a valid structured event: %s\n%s", err, body)
+				}
+				assertStructuredEvent(t, e, typ, src)
+				if string(e.Data) != `{"msg":"hello"}` {
+					t.Errorf("Unexpected event data: %s", e.Data)
+				}
+			},
+		},
+		{
+			name:        "structured with non-JSON data",
+			data:        []byte("hello"),
+			opts:        []GeneratorOption{WithStructuredMode()},
+			expectCType: contentTypeCEJSON,
+			expectBody: func(t *testing.T, body []byte) {
+				var e testStructuredEvent
+				if err := json.Unmarshal(body, &e); err != nil {
+					t.Fatalf("Body isn't a valid structured event: %s\n%s", err, body)
+				}
+				assertStructuredEvent(t, e, typ, src)
+				if string(e.DataBase64) != "hello" {
+					t.Errorf("Unexpected event data: %s", e.DataBase64)
+				}
+			},
+		},
+		{
+			name:        "batch",
+			data:        []byte(`{"msg":"hello"}`),
+			opts:        []GeneratorOption{WithBatchMode(3)},
+			expectCType: contentTypeCEBatchJSON,
+			expectBody: func(t *testing.T, body []byte) {
+				var es []testStructuredEvent
+				if err := json.Unmarshal(body, &es); err != nil {
+					t.Fatalf("Body isn't a valid batch of events: %s\n%s", err, body)
+				}
+				if l := len(es); l != 3 {
+					t.Fatalf("Expected 3 events in batch, got %d", l)
+				}
+				ids := make(map[string]struct{}, len(es))
+				for _, e := range es {
+					assertStructuredEvent(t, e, typ, src)
+					ids[e.ID] = struct{}{}
+				}
+				if len(ids) != len(es) {
+					t.Error("Expected unique event IDs in batch")
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewCloudEventTargetsGenerator(url, typ, src, tc.data, tc.opts...)
+
+			trg, err := g.Generate()
+			if err != nil {
+				t.Fatal("Generate returned an error:", err)
+			}
+
+			var decTrg jsonTarget
+			decTrg.decode(&jlexer.Lexer{Data: trg})
+
+			if ct := decTrg.Header.Get("Content-Type"); ct != tc.expectCType {
+				t.Errorf("Expected Content-Type %q, got %q", tc.expectCType, ct)
+			}
+
+			tc.expectBody(t, decTrg.Body)
+		})
+	}
+}
+
+// testStructuredEvent is a CloudEvent in the JSON event format.
+type testStructuredEvent struct {
+	SpecVersion string          `json:"specversion"`
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Source      string          `json:"source"`
+	Data        json.RawMessage `json:"data"`
+	DataBase64  []byte          `json:"data_base64"`
+}
+
+// assertStructuredEvent asserts that the given event has the expected
+// context attributes.
+func assertStructuredEvent(t *testing.T, e testStructuredEvent, typ, src string) {
+	t.Helper()
+
+	if e.SpecVersion != "1.0" {
+		t.Errorf("Unexpected specversion %q", e.SpecVersion)
+	}
+	if e.ID == "" {
+		t.Error("Event ID isn't set")
+	}
+	if e.Type != typ {
+		t.Errorf("Expected type %q, got %q", typ, e.Type)
+	}
+	if e.Source != src {
+		t.Errorf("Expected source %q, got %q", src, e.Source)
+	}
+}
+
+func BenchmarkGenerate(b *testing.B) {
+	const (
+		url = "http://localhost"
+		typ = "test.event"
+		src = "cegen/go/benchmark"
+	)
+
+	data := bytes.Repeat([]byte{'0'}, 2048)
+
+	g := NewCloudEventTargetsGenerator(url, typ, src, data)
+
+	for i := 0; i < b.N; i++ {
+		if _, err := g.Generate(); err != nil {
+			b.Error("Generate returned an error:", err)
+		}
+	}
+}
+
+func TestWeightedProfiles(t *testing.T) {
+	profiles := []*EventProfile{{
+		Type:       "type.a",
+		Source:     "src",
+		Extensions: map[string]string{"category": "a"},
+		Data:       []byte(`{}`),
+		Weight:     2,
+	}, {
+		Type:   "type.b",
+		Source: "src",
+		Data:   []byte(`{}`),
+		Weight: 1,
+	}}
+
+	t.Run("binary", func(t *testing.T) {
+		g := NewWeightedCloudEventTargetsGenerator("http://localhost", profiles)
+
+		var gotTypes []string
+
+		for i := 0; i < 6; i++ {
+			trg, err := g.Generate()
+			if err != nil {
+				t.Fatal("Generate returned an error:", err)
+			}
+
+			var decTrg jsonTarget
+			decTrg.decode(&jlexer.Lexer{Data: trg})
+
+			typ := decTrg.Header.Get("Ce-Type")
+			gotTypes = append(gotTypes, typ)
+
+			if ext := decTrg.Header.Get("Ce-Category"); typ == "type.a" && ext != "a" {
+				t.Errorf("Expected extension attribute to be set on event of type %q, got %q", typ, ext)
+			}
+		}
+
+		expectTypes := []string{"type.a", "type.b", "type.a", "type.a", "type.b", "type.a"}
+		if !reflect.DeepEqual(gotTypes, expectTypes) {
+			t.Errorf("Expected event types %q, got %q", expectTypes, gotTypes)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		g := NewWeightedCloudEventTargetsGenerator("http://localhost", profiles, WithBatchMode(3))
+
+		trg, err := g.Generate()
+		if err != nil {
+			t.Fatal("Generate returned an error:", err)
+		}
+
+		var decTrg jsonTarget
+		decTrg.decode(&jlexer.Lexer{Data: trg})
+
+		var es []map[string]interface{}
+		if err := json.Unmarshal(decTrg.Body, &es); err != nil {
+			t.Fatalf("Body isn't a valid batch of events: %s\n%s", err, decTrg.Body)
+		}
+
+		var gotTypes []string
+		for _, e := range es {
+			gotTypes = append(gotTypes, e["type"].(string))
+		}
+
+		expectTypes := []string{"type.a", "type.b", "type.a"}
+		if !reflect.DeepEqual(gotTypes, expectTypes) {
+			t.Errorf("Expected event types %q, got %q", expectTypes, gotTypes)
+		}
+
+		if ext := es[0]["category"]; ext != "a" {
+			t.Errorf("Expected extension attribute to be set, got %v", ext)
+		}
+	})
+}
+
+func TestStamping(t *testing.T) {
+	const numTargets = 3
+
+	t.Run("binary", func(t *testing.T) {
+		g := NewCloudEventTargetsGenerator("http://localhost", "test.event", "cegen/go/test", []byte(`{}`),
+			WithStamping())
+
+		for i := 1; i <= numTargets; i++ {
+			trg, err := g.Generate()
+			if err != nil {
+				t.Fatal("Generate returned an error:", err)
+			}
+
+			var decTrg jsonTarget
+			decTrg.decode(&jlexer.Lexer{Data: trg})
+
+			assertStamp(t, decTrg.Header.Get("Ce-Sequence"), decTrg.Header.Get("Ce-Sendtime"), i)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		g := NewCloudEventTargetsGenerator("http://localhost", "test.event", "cegen/go/test", []byte(`{}`),
+			WithStamping(), WithBatchMode(numTargets))
+
+		trg, err := g.Generate()
+		if err != nil {
+			t.Fatal("Generate returned an error:", err)
+		}
+
+		var decTrg jsonTarget
+		decTrg.decode(&jlexer.Lexer{Data: trg})
+
+		var es []map[string]interface{}
+		if err := json.Unmarshal(decTrg.Body, &es); err != nil {
+			t.Fatalf("Body isn't a valid batch of events: %s\n%s", err, decTrg.Body)
+		}
+
+		for i, e := range es {
+			seq, _ := e[extStampSeq].(string)
+			sendTime, _ := e[extStampTime].(string)
+			assertStamp(t, seq, sendTime, i+1)
+		}
+	})
+}
+
+// assertStamp asserts that the given stamp attributes are valid.
+func assertStamp(t *testing.T, seq, sendTime string, expectSeq int) {
+	t.Helper()
+
+	if seq != strconv.Itoa(expectSeq) {
+		t.Errorf("Expected sequence number %d, got %q", expectSeq, seq)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, sendTime); err != nil {
+		t.Errorf("Send time %q isn't in RFC 3339 format: %s", sendTime, err)
+	}
+}
+
+func TestSyntheticProfile(t *testing.T) {
+	const size = 512
+
+	profiles := []*EventProfile{{
+		Type:   "test.event",
+		Source: "cegen/go/test",
+		Sizes:  fixedSizeDistribution(size),
+	}}
+
+	t.Run("binary", func(t *testing.T) {
+		g := NewWeightedCloudEventTargetsGenerator("http://localhost", profiles)
+
+		trg, err := g.Generate()
+		if err != nil {
+			t.Fatal("Generate returned an error:", err)
+		}
+
+		var decTrg jsonTarget
+		decTrg.decode(&jlexer.Lexer{Data: trg})
+
+		if l := len(decTrg.Body); l != size {
+			t.Errorf("Expected body of size %d, got %d", size, l)
+		}
+		if ct := decTrg.Header.Get("Content-Type"); ct != contentTypeJSON {
+			t.Errorf("Expected content type %q, got %q", contentTypeJSON, ct)
+		}
+	})
+
+	t.Run("structured", func(t *testing.T) {
+		g := NewWeightedCloudEventTargetsGenerator("http://localhost", profiles, WithStructuredMode())
+
+		trg, err := g.Generate()
+		if err != nil {
+			t.Fatal("Generate returned an error:", err)
+		}
+
+		var decTrg jsonTarget
+		decTrg.decode(&jlexer.Lexer{Data: trg})
+
+		var e map[string]json.RawMessage
+		if err := json.Unmarshal(decTrg.Body, &e); err != nil {
+			t.Fatalf("Body isn't a valid event: %s\n%s", err, decTrg.Body)
+		}
+		if l := len(e["data"]); l != size {
+			t.Errorf("Expected data of size %d, got %d", size, l)
+		}
+	})
+}
+
+func TestOptionalAttributes(t *testing.T) {
+	profiles := []*EventProfile{{
+		Type:            "test.event",
+		Source:          "cegen/go/test",
+		Extensions:      map[string]string{"region": "eu"},
+		Subject:         "test-subject",
+		DataSchema:      "http://schema",
+		DataContentType: "text/plain",
+		Data:            []byte("hello"),
+	}}
+
+	const timeAttr = "2020-01-01T00:00:00Z"
+
+	opts := []GeneratorOption{
+		WithTimeAttribute(timeAttr),
+		WithHeaders(http.Header{"Authorization": []string{"Bearer abc"}}),
+	}
+
+	t.Run("binary", func(t *testing.T) {
+		g := NewWeightedCloudEventTargetsGenerator("http://localhost", profiles, opts...)
+
+		trg, err := g.Generate()
+		if err != nil {
+			t.Fatal("Generate returned an error:", err)
+		}
+
+		var decTrg jsonTarget
+		decTrg.decode(&jlexer.Lexer{Data: trg})
+
+		expectHeaders := map[string]string{
+			"Ce-Region":     "eu",
+			"Ce-Subject":    "test-subject",
+			"Ce-Dataschema": "http://schema",
+			"Ce-Time":       timeAttr,
+			"Content-Type":  "text/plain",
+			"Authorization": "Bearer abc",
+		}
+		for k, v := range expectHeaders {
+			if hv := decTrg.Header.Get(k); hv != v {
+				t.Errorf("Expected header %s to be %q, got %q", k, v, hv)
+			}
+		}
+	})
+
+	t.Run("structured", func(t *testing.T) {
+		g := NewWeightedCloudEventTargetsGenerator("http://localhost", profiles,
+			append(opts, WithStructuredMode())...)
+
+		trg, err := g.Generate()
+		if err != nil {
+			t.Fatal("Generate returned an error:", err)
+		}
+
+		var decTrg jsonTarget
+		decTrg.decode(&jlexer.Lexer{Data: trg})
+
+		if auth := decTrg.Header.Get("Authorization"); auth != "Bearer abc" {
+			t.Errorf("Unexpected Authorization header %q", auth)
+		}
+
+		var e map[string]interface{}
+		if err := json.Unmarshal(decTrg.Body, &e); err != nil {
+			t.Fatalf("Body isn't a valid event: %s\n%s", err, decTrg.Body)
+		}
+
+		expectAttrs := map[string]interface{}{
+			"region":          "eu",
+			"subject":         "test-subject",
+			"dataschema":      "http://schema",
+			"time":            timeAttr,
+			"datacontenttype": "text/plain",
+			// non-JSON data is base64-encoded
+			"data_base64": "aGVsbG8=",
+		}
+		for k, v := range expectAttrs {
+			if e[k] != v {
+				t.Errorf("Expected attribute %s to be %q, got %q", k, v, e[k])
+			}
+		}
+	})
+}
@@ -3,6 +3,7 @@ module cegen
 go 1.15
 
 require (
+	github.com/linkedin/goavro/v2 v2.10.0
 	github.com/mailru/easyjson v0.7.6
 	github.com/rogpeppe/fastuuid v1.2.0
 	github.com/sethvargo/go-signalcontext v0.1.0
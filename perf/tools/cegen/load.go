@@ -0,0 +1,286 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Shapes of load profiles.
+const (
+	loadConstant = "constant" // constant:rate=<r>
+	loadRamp     = "ramp"     // ramp:from=<r>,to=<r>
+	loadSteps    = "steps"    // steps:from=<r>,by=<r>,every=<d>
+	loadSpike    = "spike"    // spike:base=<r>,peak=<r>,at=<d>,for=<d>
+	loadSine     = "sine"     // sine:mean=<r>,amp=<r>,period=<d>
+)
+
+// parseLoadProfile returns a vegeta.Pacer which describes the rate of
+// requests (per second) expressed by the given load profile.
+// The duration of the attack is required by profiles which span its entire
+// duration, such as ramps.
+func parseLoadProfile(profile string, duration time.Duration) (vegeta.Pacer, error) {
+	shape, params, err := parseSpec(profile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid load profile: %w", err)
+	}
+
+	var p vegeta.Pacer
+
+	switch shape {
+	case loadConstant:
+		p, err = constantPacer(params)
+	case loadRamp:
+		p, err = rampPacer(params, duration)
+	case loadSteps:
+		p, err = stepsPacer(params, duration)
+	case loadSpike:
+		p, err = spikePacer(params)
+	case loadSine:
+		p, err = sinePacer(params)
+	default:
+		return nil, fmt.Errorf("unknown load profile %q", shape)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s load profile: %w", shape, err)
+	}
+	if unused := params.unused(); len(unused) > 0 {
+		return nil, fmt.Errorf("invalid %s load profile: unknown parameters %q", shape, unused)
+	}
+
+	return p, nil
+}
+
+func constantPacer(params specParams) (vegeta.Pacer, error) {
+	rate, err := params.positiveInt("rate")
+	if err != nil {
+		return nil, err
+	}
+
+	return perSecond(rate), nil
+}
+
+func rampPacer(params specParams, duration time.Duration) (vegeta.Pacer, error) {
+	from, err := params.positiveInt("from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := params.positiveInt("to")
+	if err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("the duration of the attack must be set")
+	}
+
+	return vegeta.LinearPacer{
+		StartAt: perSecond(from),
+		Slope:   (float64(to) - float64(from)) / duration.Seconds(),
+	}, nil
+}
+
+func stepsPacer(params specParams, duration time.Duration) (vegeta.Pacer, error) {
+	from, err := params.positiveInt("from")
+	if err != nil {
+		return nil, err
+	}
+	by, err := params.positiveInt("by")
+	if err != nil {
+		return nil, err
+	}
+	every, err := params.duration("every")
+	if err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("the duration of the attack must be set")
+	}
+
+	var p piecewisePacer
+	for start, rate := time.Duration(0), from; start < duration; start, rate = start+every, rate+by {
+		p = append(p, rateSegment{start: start, rate: float64(rate)})
+	}
+
+	return p, nil
+}
+
+func spikePacer(params specParams) (vegeta.Pacer, error) {
+	base, err := params.positiveInt("base")
+	if err != nil {
+		return nil, err
+	}
+	peak, err := params.positiveInt("peak")
+	if err != nil {
+		return nil, err
+	}
+	at, err := params.duration("at")
+	if err != nil {
+		return nil, err
+	}
+	length, err := params.duration("for")
+	if err != nil {
+		return nil, err
+	}
+
+	return piecewisePacer{
+		{start: 0, rate: float64(base)},
+		{start: at, rate: float64(peak)},
+		{start: at + length, rate: float64(base)},
+	}, nil
+}
+
+func sinePacer(params specParams) (vegeta.Pacer, error) {
+	mean, err := params.positiveInt("mean")
+	if err != nil {
+		return nil, err
+	}
+	amp, err := params.positiveInt("amp")
+	if err != nil {
+		return nil, err
+	}
+	if amp >= mean {
+		return nil, fmt.Errorf("the amplitude must be lower than the mean rate")
+	}
+	period, err := params.duration("period")
+	if err != nil {
+		return nil, err
+	}
+
+	return vegeta.SinePacer{
+		Period:  period,
+		Mean:    perSecond(mean),
+		Amp:     perSecond(amp),
+		StartAt: vegeta.MeanUp,
+	}, nil
+}
+
+// perSecond returns a vegeta.Rate of the given number of hits per second.
+func perSecond(hits uint) vegeta.Rate {
+	return vegeta.Rate{Freq: int(hits), Per: time.Second}
+}
+
+// limitHits returns a vegeta.Pacer which stops an attack paced by p after the
+// given number of hits. A limit of 0 means no limit.
+func limitHits(p vegeta.Pacer, maxHits uint64) vegeta.Pacer {
+	if maxHits == 0 {
+		return p
+	}
+	return &limitedPacer{Pacer: p, maxHits: maxHits}
+}
+
+// limitedPacer is a vegeta.Pacer which stops an attack after a given number
+// of hits.
+type limitedPacer struct {
+	vegeta.Pacer
+	maxHits uint64
+}
+
+// Pace implements vegeta.Pacer.
+func (p *limitedPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if hits >= p.maxHits {
+		return 0, true
+	}
+	return p.Pacer.Pace(elapsed, hits)
+}
+
+// piecewisePacer is a vegeta.Pacer with a rate that changes in discrete steps.
+// Segments are sorted chronologically, the first one starts at 0 and the last
+// one lasts indefinitely.
+type piecewisePacer []rateSegment
+
+// rateSegment is a period of time during which the rate of a piecewisePacer
+// is constant.
+type rateSegment struct {
+	start time.Duration
+	// hits per second
+	rate float64
+}
+
+// piecewisePacer implements vegeta.Pacer.
+var _ vegeta.Pacer = (piecewisePacer)(nil)
+
+// Pace implements vegeta.Pacer.
+func (p piecewisePacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if p.hits(elapsed) >= float64(hits) {
+		// Running behind, send next hit immediately.
+		return 0, false
+	}
+
+	// Find the time at which the expected number of hits equals the
+	// number of hits already sent.
+	var accHits float64
+
+	for i, s := range p {
+		last := i == len(p)-1
+
+		var segHits float64
+		if !last {
+			segHits = s.rate * (p[i+1].start - s.start).Seconds()
+		}
+
+		if last || accHits+segHits >= float64(hits) {
+			if s.rate == 0 {
+				// no more hit is expected
+				return 0, true
+			}
+
+			at := s.start + time.Duration((float64(hits)-accHits)/s.rate*float64(time.Second))
+			return at - elapsed, false
+		}
+
+		accHits += segHits
+	}
+
+	return 0, true
+}
+
+// Rate implements vegeta.Pacer.
+func (p piecewisePacer) Rate(elapsed time.Duration) float64 {
+	var rate float64
+	for _, s := range p {
+		if s.start > elapsed {
+			break
+		}
+		rate = s.rate
+	}
+	return rate
+}
+
+// hits returns the number of hits that are expected to have been sent after
+// the given elapsed duration.
+func (p piecewisePacer) hits(elapsed time.Duration) float64 {
+	var hits float64
+
+	for i, s := range p {
+		if s.start >= elapsed {
+			break
+		}
+
+		end := elapsed
+		if i < len(p)-1 && p[i+1].start < elapsed {
+			end = p[i+1].start
+		}
+
+		hits += s.rate * (end - s.start).Seconds()
+	}
+
+	return hits
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func TestParseLoadProfile(t *testing.T) {
+	const duration = time.Minute
+
+	testCases := []struct {
+		profile   string
+		expect    vegeta.Pacer
+		expectErr string
+	}{
+		{
+			profile: "constant:rate=100",
+			expect:  vegeta.Rate{Freq: 100, Per: time.Second},
+		},
+		{
+			profile: "ramp:from=100,to=700",
+			expect:  vegeta.LinearPacer{StartAt: vegeta.Rate{Freq: 100, Per: time.Second}, Slope: 10},
+		},
+		{
+			profile: "ramp:from=1000,to=100",
+			expect:  vegeta.LinearPacer{StartAt: vegeta.Rate{Freq: 1000, Per: time.Second}, Slope: -15},
+		},
+		{
+			profile: "steps:from=100,by=50,every=20s",
+			expect: piecewisePacer{
+				{start: 0, rate: 100},
+				{start: 20 * time.Second, rate: 150},
+				{start: 40 * time.Second, rate: 200},
+			},
+		},
+		{
+			profile: "spike:base=100,peak=1000,at=10s,for=5s",
+			expect: piecewisePacer{
+				{start: 0, rate: 100},
+				{start: 10 * time.Second, rate: 1000},
+				{start: 15 * time.Second, rate: 100},
+			},
+		},
+		{
+			profile: "sine:mean=100,amp=50,period=1m",
+			expect: vegeta.SinePacer{
+				Period:  time.Minute,
+				Mean:    vegeta.Rate{Freq: 100, Per: time.Second},
+				Amp:     vegeta.Rate{Freq: 50, Per: time.Second},
+				StartAt: vegeta.MeanUp,
+			},
+		},
+		{
+			profile:   "unknown:rate=100",
+			expectErr: `unknown load profile "unknown"`,
+		},
+		{
+			profile:   "constant",
+			expectErr: `missing parameter "rate"`,
+		},
+		{
+			profile:   "constant:100",
+			expectErr: `expected key=value`,
+		},
+		{
+			profile:   "constant:rate=0",
+			expectErr: `parameter "rate" must be a positive integer`,
+		},
+		{
+			profile:   "constant:rate=100,burst=10",
+			expectErr: `unknown parameters ["burst"]`,
+		},
+		{
+			profile:   "spike:base=100,peak=1000,at=10s,for=forever",
+			expectErr: `parameter "for" must be a positive duration`,
+		},
+		{
+			profile:   "sine:mean=100,amp=100,period=1m",
+			expectErr: "amplitude must be lower than the mean rate",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.profile, func(t *testing.T) {
+			p, err := parseLoadProfile(tc.profile, duration)
+
+			if tc.expectErr != "" {
+				if err == nil {
+					t.Fatal("Expected parsing to fail")
+				}
+				if errStr := err.Error(); !strings.Contains(errStr, tc.expectErr) {
+					t.Fatalf("Unexpected error message: %q", errStr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+
+			if pStr, expectStr := fmt.Sprintf("%#v", p), fmt.Sprintf("%#v", tc.expect); pStr != expectStr {
+				t.Errorf("Expected pacer\n%s\ngot\n%s", expectStr, pStr)
+			}
+		})
+	}
+
+	t.Run("ramp-down rate", func(t *testing.T) {
+		p, err := parseLoadProfile("ramp:from=1000,to=100", duration)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		lp := p.(vegeta.LinearPacer)
+
+		if r := lp.Rate(0); math.Abs(r-1000) > 1e-6 {
+			t.Errorf("Expected a rate of 1000/s at the start, got %f", r)
+		}
+		if r := lp.Rate(duration); math.Abs(r-100) > 1e-6 {
+			t.Errorf("Expected a rate of 100/s at the end, got %f", r)
+		}
+	})
+
+	t.Run("ramp without duration", func(t *testing.T) {
+		_, err := parseLoadProfile("ramp:from=1,to=10", 0)
+		if err == nil {
+			t.Fatal("Expected parsing to fail")
+		}
+	})
+}
+
+func TestPiecewisePacer(t *testing.T) {
+	// 10 hits/s during 1s, then 100 hits/s during 1s, then 1 hit/s
+	p := piecewisePacer{
+		{start: 0, rate: 10},
+		{start: time.Second, rate: 100},
+		{start: 2 * time.Second, rate: 1},
+	}
+
+	testCases := []struct {
+		name       string
+		elapsed    time.Duration
+		hits       uint64
+		expectWait time.Duration
+		expectRate float64
+	}{
+		{
+			name:       "first hit",
+			elapsed:    0,
+			hits:       0,
+			expectWait: 0,
+			expectRate: 10,
+		},
+		{
+			name:       "ahead in first segment",
+			elapsed:    0,
+			hits:       1,
+			expectWait: 100 * time.Millisecond,
+			expectRate: 10,
+		},
+		{
+			name:       "running behind",
+			elapsed:    time.Second,
+			hits:       5,
+			expectWait: 0,
+			expectRate: 100,
+		},
+		{
+			name:       "ahead across segments",
+			elapsed:    900 * time.Millisecond,
+			hits:       20,
+			expectWait: 200 * time.Millisecond,
+			expectRate: 10,
+		},
+		{
+			name:       "last segment",
+			elapsed:    2 * time.Second,
+			hits:       111,
+			expectWait: time.Second,
+			expectRate: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wait, stop := p.Pace(tc.elapsed, tc.hits)
+			if stop {
+				t.Fatal("Unexpected stop")
+			}
+
+			if diff := wait - tc.expectWait; diff < -time.Microsecond || diff > time.Microsecond {
+				t.Errorf("Expected wait %s, got %s", tc.expectWait, wait)
+			}
+
+			if r := p.Rate(tc.elapsed); r != tc.expectRate {
+				t.Errorf("Expected rate %v, got %v", tc.expectRate, r)
+			}
+		})
+	}
+}
+
+func TestLimitHits(t *testing.T) {
+	p := limitHits(vegeta.Rate{Freq: 1, Per: time.Second}, 2)
+
+	if _, stop := p.Pace(0, 1); stop {
+		t.Error("Expected attack to continue before reaching the limit")
+	}
+	if _, stop := p.Pace(0, 2); !stop {
+		t.Error("Expected attack to stop after reaching the limit")
+	}
+
+	if p := limitHits(vegeta.Rate{}, 0); p != (vegeta.Rate{}) {
+		t.Errorf("Expected pacer to be returned as is without limit, got %#v", p)
+	}
+}
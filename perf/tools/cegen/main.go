@@ -22,18 +22,17 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 	"syscall"
+	"time"
 
-	"github.com/mailru/easyjson/buffer"
-	jwriter "github.com/mailru/easyjson/jwriter"
-	uuid "github.com/rogpeppe/fastuuid"
 	"github.com/sethvargo/go-signalcontext"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
 )
 
 const (
@@ -41,6 +40,32 @@ const (
 	ceSource = "cegen"
 )
 
+// CloudEvents content modes.
+// https://github.com/cloudevents/spec/blob/v1.0.1/http-protocol-binding.md#3-http-message-mapping
+const (
+	modeBinary     = "binary"
+	modeStructured = "structured"
+	modeBatch      = "batch"
+)
+
+const defaultBatchSize = 10
+
+// CloudEvents event formats, used in structured and batch content modes.
+const (
+	// https://github.com/cloudevents/spec/blob/v1.0.1/json-format.md
+	eventFormatJSON = "json"
+	// https://github.com/cloudevents/spec/blob/v1.0.1/protobuf-format.md
+	eventFormatProtobuf = "protobuf"
+)
+
+// Media types of HTTP request bodies.
+const (
+	contentTypeJSON        = "application/json"
+	contentTypeOctetStream = "application/octet-stream"
+	contentTypeCEJSON      = "application/cloudevents+json"
+	contentTypeCEBatchJSON = "application/cloudevents-batch+json"
+)
+
 func main() {
 	ctx, cancel := signalcontext.On(syscall.SIGINT, syscall.SIGTERM, syscall.SIGPIPE)
 	defer cancel()
@@ -62,150 +87,752 @@ func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
 		return fmt.Errorf("reading options: %w", err)
 	}
 
-	data := []byte(*opts.ceData)
+	if *opts.replay != "" {
+		return runReplay(ctx, opts, stdout)
+	}
 
-	if strings.HasPrefix(*opts.ceData, "@") {
-		absPath, err := filepath.Abs(strings.TrimPrefix(*opts.ceData, "@"))
+	var profiles []*EventProfile
+
+	if *opts.profilesFile != "" {
+		if profiles, err = readProfilesConfig(*opts.profilesFile, *opts.template); err != nil {
+			return fmt.Errorf("reading event profiles: %w", err)
+		}
+		if err := opts.validateProfiles(profiles); err != nil {
+			return fmt.Errorf("reading event profiles: %w", err)
+		}
+	} else {
+		p, err := profileFromOpts(opts)
 		if err != nil {
-			return fmt.Errorf("converting %q to an absolute path: %w", *opts.ceData, err)
+			return err
 		}
+		profiles = append(profiles, p)
+	}
 
-		data, err = ioutil.ReadFile(absPath)
-		if err != nil {
-			return fmt.Errorf("reading data from file: %w", err)
+	var genOpts []GeneratorOption
+
+	if *opts.stamp {
+		genOpts = append(genOpts, WithStamping())
+	}
+	if len(opts.targetURLs) > 1 {
+		genOpts = append(genOpts, WithTargetURLs(opts.targetURLs, *opts.distribution))
+	}
+	if *opts.partitionKeys > 0 {
+		genOpts = append(genOpts, WithPartitionKeys(*opts.partitionKeys))
+	}
+	if *opts.timeAttr != "" {
+		genOpts = append(genOpts, WithTimeAttribute(*opts.timeAttr))
+	}
+	if len(opts.headers) > 0 {
+		genOpts = append(genOpts, WithHeaders(http.Header(opts.headers)))
+	}
+
+	switch *opts.mode {
+	case modeStructured:
+		genOpts = append(genOpts, WithStructuredMode())
+	case modeBinary:
+		// NDJSON output consists of events in the JSON event format
+		if *opts.format == formatNDJSON {
+			genOpts = append(genOpts, WithStructuredMode())
+		}
+	case modeBatch:
+		genOpts = append(genOpts, WithBatchMode(int(*opts.batchSize)))
+	}
+	if *opts.eventFormat == eventFormatProtobuf {
+		genOpts = append(genOpts, WithProtobufFormat())
+	}
+	if *opts.faultRate > 0 {
+		genOpts = append(genOpts, WithFaults(*opts.faultRate, opts.faultKinds, int(*opts.faultDataSize)))
+	}
+
+	if *opts.parallel > 1 {
+		gens := make([]*CloudEventTargetsGenerator, *opts.parallel)
+		for i := range gens {
+			workerOpts := genOpts
+			if *opts.seed != 0 {
+				// each worker draws from a distinct source of
+				// randomness, derived from the seed
+				workerOpts = append(genOpts[:len(genOpts):len(genOpts)], WithSeed(*opts.seed+int64(i)))
+			}
+			gens[i] = NewWeightedCloudEventTargetsGenerator(opts.targetURLs[0], profiles, workerOpts...)
+		}
+
+		if d := *opts.duration; d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+
+		return newPipeline(gens, *opts.numTargets).run(ctx, stdout)
+	}
+
+	if *opts.seed != 0 {
+		genOpts = append(genOpts, WithSeed(*opts.seed))
+	}
+
+	gen := NewWeightedCloudEventTargetsGenerator(opts.targetURLs[0], profiles, genOpts...)
+
+	if *opts.attack {
+		return runAttack(ctx, gen.Targeter(), opts.attackOpts(), stdout)
+	}
+
+	// vegeta JSON targets are written directly from the generator's
+	// output, other formats are written from decoded targets
+	var tw targetWriter
+
+	if *opts.format != formatJSON {
+		if tw, err = newTargetWriter(opts, stdout); err != nil {
+			return err
+		}
+	}
+
+	if err := generate(ctx, gen, tw, opts, stdout); err != nil {
+		return err
+	}
+
+	if tw != nil {
+		if err := tw.close(); err != nil {
+			return fmt.Errorf("writing %s output: %w", *opts.format, err)
+		}
+	}
+
+	return nil
+}
+
+// runReplay replays captured events, either by sending them to the target
+// URL in attack mode, or by writing them as targets to stdout at the pace of
+// the original events.
+func runReplay(ctx context.Context, opts *cmdOpts, stdout io.Writer) error {
+	events, err := readCaptureFile(*opts.replay)
+	if err != nil {
+		return fmt.Errorf("reading captured events: %w", err)
+	}
+
+	var replayOpts []ReplayerOption
+
+	if *opts.rewriteIDs {
+		replayOpts = append(replayOpts, WithIDRewriting(*opts.seed))
+	}
+	if len(opts.headers) > 0 {
+		replayOpts = append(replayOpts, WithReplayHeaders(http.Header(opts.headers)))
+	}
+	if *opts.mode == modeStructured || *opts.format == formatNDJSON {
+		replayOpts = append(replayOpts, WithReplayStructuredMode())
+	}
+
+	rp := newReplayer(opts.targetURLs[0], events, *opts.speed, replayOpts...)
+
+	if *opts.attack {
+		opts.pacer = rp.Pacer()
+		return runAttack(ctx, rp.Targeter(), opts.attackOpts(), stdout)
+	}
+
+	tw, err := newTargetWriter(opts, stdout)
+	if err != nil {
+		return err
+	}
+
+	if err := replay(ctx, rp, tw, opts); err != nil {
+		return err
+	}
+
+	if err := tw.close(); err != nil {
+		return fmt.Errorf("writing %s output: %w", *opts.format, err)
+	}
+
+	return nil
+}
+
+// replay writes the targets yielded by the given replayer, each at the time
+// it should be sent, until either the context is cancelled, all events have
+// been replayed, or the bounds set in the command's options are reached.
+func replay(ctx context.Context, rp *replayer, tw targetWriter, opts *cmdOpts) error {
+	var deadline <-chan time.Time
+	if d := *opts.duration; d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		deadline = t.C
+	}
+
+	nextTarget := rp.Targeter()
+
+	// fired timer, reset before each wait
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	start := time.Now()
+
+	for i, offset := range rp.offsets {
+		if *opts.numTargets != 0 && uint64(i) == *opts.numTargets {
+			return nil
+		}
+
+		if wait := time.Until(start.Add(offset)); wait > 0 {
+			timer.Reset(wait)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-deadline:
+				return nil
+			case <-timer.C:
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-deadline:
+				return nil
+			default:
+			}
+		}
+
+		var trg vegeta.Target
+		if err := nextTarget(&trg); err != nil {
+			return fmt.Errorf("generating target: %w", err)
 		}
+		if err := tw.write(&trg); err != nil {
+			return fmt.Errorf("writing %s target: %w", *opts.format, err)
+		}
+	}
+
+	return nil
+}
+
+// newTargetWriter returns a targetWriter for the output format set in the
+// command's options.
+func newTargetWriter(opts *cmdOpts, stdout io.Writer) (targetWriter, error) {
+	switch *opts.format {
+	case formatHTTP:
+		return newHTTPTargetWriter(stdout, *opts.bodyDir)
+	case formatNDJSON:
+		return &ndjsonTargetWriter{w: stdout}, nil
+	case formatK6:
+		if *opts.k6Script != "" {
+			if err := writeK6Script(*opts.k6Script); err != nil {
+				return nil, err
+			}
+		}
+		return &k6TargetWriter{w: stdout}, nil
+	default:
+		return &jsonTargetWriter{w: stdout}, nil
+	}
+}
+
+// generate writes targets yielded by the given generator until either the
+// context is cancelled or the bounds set in the command's options are
+// reached. Targets are written in vegeta JSON format when tw is nil.
+func generate(ctx context.Context, gen *CloudEventTargetsGenerator, tw targetWriter,
+	opts *cmdOpts, stdout io.Writer) error {
+
+	var deadline <-chan time.Time
+	if d := *opts.duration; d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		deadline = t.C
 	}
 
-	gen := NewCloudEventTargetsGenerator(*opts.targetURL, *opts.ceType, *opts.ceSource, data)
+	var nextTarget vegeta.Targeter
+	if tw != nil {
+		nextTarget = gen.Targeter()
+	}
 
-	for {
+	// faulty targets are not counted
+	for n := uint64(0); *opts.numTargets == 0 || n < *opts.numTargets; {
 		select {
 		case <-ctx.Done():
 			return nil
 
+		case <-deadline:
+			return nil
+
 		default:
-			trg, err := gen.Generate()
-			if err != nil {
-				return fmt.Errorf("generating vegeta JSON target: %w", err)
+			if tw == nil {
+				trg, err := gen.Generate()
+				if err != nil {
+					return fmt.Errorf("generating vegeta JSON target: %w", err)
+				}
+
+				fprintln(stdout, string(trg))
+			} else {
+				var trg vegeta.Target
+				if err := nextTarget(&trg); err != nil {
+					return fmt.Errorf("generating target: %w", err)
+				}
+				if err := tw.write(&trg); err != nil {
+					return fmt.Errorf("writing %s target: %w", *opts.format, err)
+				}
 			}
 
-			fprintln(stdout, string(trg))
+			if gen.fault == "" {
+				n++
+			}
 		}
 	}
+
+	return nil
 }
 
 var fprintln = fmt.Fprintln
 
+// profileFromOpts returns the EventProfile described by the command's options.
+func profileFromOpts(opts *cmdOpts) (*EventProfile, error) {
+	p := &EventProfile{
+		Type:            *opts.ceType,
+		Source:          *opts.ceSource,
+		Subject:         *opts.subject,
+		DataSchema:      *opts.dataSchema,
+		DataContentType: *opts.dataContentType,
+		Data:            []byte(*opts.ceData),
+
+		Webhook:       *opts.webhook,
+		WebhookSecret: *opts.webhookSecret,
+	}
+
+	if len(opts.extensions) > 0 {
+		p.Extensions = opts.extensions
+	}
+
+	if *opts.avroSchema != "" {
+		var err error
+		if p.AvroCodec, err = readAvroSchema(*opts.avroSchema); err != nil {
+			return nil, err
+		}
+	}
+
+	if *opts.sizes != "" {
+		var err error
+		if p.Sizes, err = parseSizeDistribution(*opts.sizes); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	if strings.HasPrefix(*opts.ceData, "@") {
+		absPath, err := filepath.Abs(strings.TrimPrefix(*opts.ceData, "@"))
+		if err != nil {
+			return nil, fmt.Errorf("converting %q to an absolute path: %w", *opts.ceData, err)
+		}
+
+		p.Data, err = ioutil.ReadFile(absPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading data from file: %w", err)
+		}
+	}
+
+	if *opts.template {
+		tmpl, err := parseDataTemplate(p.Data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing data template: %w", err)
+		}
+		if !tmpl.isStatic() {
+			p.Template = tmpl
+		}
+	}
+
+	return p, nil
+}
+
 // cmdOpts are the options that can be passed to the command.
 type cmdOpts struct {
-	targetURL *string
-	ceType    *string
-	ceSource  *string
-	ceData    *string
+	targetURLs    stringsFlag
+	distribution  *string
+	partitionKeys *uint64
+
+	ceType   *string
+	ceSource *string
+	ceData   *string
+
+	extensions      keyValueFlag
+	subject         *string
+	dataSchema      *string
+	timeAttr        *string
+	dataContentType *string
+	headers         headerFlag
+
+	sizes       *string
+	avroSchema  *string
+	mode        *string
+	batchSize   *uint
+	eventFormat *string
+	template    *bool
+	stamp       *bool
+
+	profilesFile *string
+
+	webhook       *string
+	webhookSecret *string
+
+	format   *string
+	bodyDir  *string
+	k6Script *string
+
+	numTargets *uint64
+	seed       *int64
+	parallel   *uint
+
+	faultRate     *float64
+	faultKindList *string
+	faultKinds    []string
+	faultDataSize *uint
+
+	replay     *string
+	speed      *float64
+	rewriteIDs *bool
+
+	attack      *bool
+	load        *string
+	pacer       vegeta.Pacer
+	duration    *time.Duration
+	workers     *uint64
+	maxWorkers  *uint64
+	connections *int
+	timeout     *time.Duration
+	http2       *bool
+	resultsFile *string
+}
+
+// attackOpts returns the attack options contained in the command's options.
+func (o *cmdOpts) attackOpts() *attackOpts {
+	return &attackOpts{
+		pacer:       limitHits(o.pacer, *o.numTargets),
+		duration:    *o.duration,
+		workers:     *o.workers,
+		maxWorkers:  *o.maxWorkers,
+		connections: *o.connections,
+		timeout:     *o.timeout,
+		http2:       *o.http2,
+		resultsFile: *o.resultsFile,
+	}
 }
 
 // readOpts parses and validates options from commmand-line flags.
 func readOpts(f *flag.FlagSet, args []string) (*cmdOpts, error) {
 	opts := &cmdOpts{}
 
-	opts.targetURL = f.String("u", "", "URL of the CloudEvents receiver to use in generated vegeta targets")
+	f.Var(&opts.targetURLs, "u", "URL of the CloudEvents receiver to use in generated vegeta targets. "+
+		"Can be repeated to distribute targets across multiple receivers")
+	opts.distribution = f.String("distribution", distRoundRobin, "Strategy used to distribute targets across "+
+		"multiple receivers. One of ["+distRoundRobin+", "+distRandom+", "+distHash+"]. "+
+		distHash+" requires -partition-keys")
+	opts.partitionKeys = f.Uint64("partition-keys", 0, "Number of distinct values of the '"+extPartitionKey+"' "+
+		"extension attribute to draw randomly for each target. 0 = no partition key")
 	opts.ceType = f.String("t", ceType, "Value to set as the CloudEvent type context attribute")
 	opts.ceSource = f.String("s", ceSource, "Value to set as the CloudEvent source context attribute")
 	opts.ceData = f.String("d", "", "Data to set in generated CloudEvents. Prefix with '@' to read from a file")
 
+	opts.extensions = make(keyValueFlag)
+	f.Var(opts.extensions, "e", "Extension attribute to set in generated CloudEvents, in the format "+
+		"'name=value'. Can be repeated")
+	opts.subject = f.String("subject", "", "Value to set as the CloudEvent subject context attribute")
+	opts.dataSchema = f.String("dataschema", "", "Value to set as the CloudEvent dataschema context attribute")
+	opts.timeAttr = f.String("time", "", "Value to set as the CloudEvent time context attribute, in RFC 3339 "+
+		"format. The value '"+timeNow+"' sets the time at which each event is generated")
+	opts.dataContentType = f.String("datacontenttype", contentTypeJSON, "Value to set as the CloudEvent "+
+		"datacontenttype context attribute")
+	opts.headers = make(headerFlag)
+	f.Var(opts.headers, "H", "HTTP header to set on generated targets, in the format 'Key: value'. Can be repeated")
+
+	opts.sizes = f.String("size", "", "Generate synthetic JSON data with sizes in bytes that follow the given "+
+		"distribution instead of using -d, e.g. '"+sizeUniform+":min=512,max=4096'. "+
+		"One of ["+sizeFixed+", "+sizeUniform+", "+sizeNormal+", "+sizeHist+"]")
+	opts.avroSchema = f.String("avro-schema", "", "Path to a file containing an Avro schema. The data, "+
+		"in Avro's JSON encoding, is converted to the Avro binary encoding. "+
+		"Sets -datacontenttype to "+contentTypeAvro+" unless specified")
+	opts.profilesFile = f.String("profiles", "", "Path to a JSON file containing weighted event profiles. "+
+		"Takes precedence over -t, -s, -d, -e, -subject, -dataschema, -datacontenttype and -avro-schema")
+	opts.template = f.Bool("template", false, "Interpret template directives such as {{seq}} or {{rand 16}} "+
+		"inside the data and render them for each event")
+	opts.stamp = f.Bool("stamp", false, "Stamp each event with the extension attributes "+
+		"'"+extStampSeq+"' (sequence number) and '"+extStampTime+"' (generation time)")
+	opts.mode = f.String("mode", modeBinary, "Content mode of generated CloudEvents. "+
+		"One of ["+modeBinary+", "+modeStructured+", "+modeBatch+"]")
+	opts.batchSize = f.Uint("batch-size", defaultBatchSize, "Number of CloudEvents per request in "+modeBatch+" mode")
+	opts.eventFormat = f.String("event-format", eventFormatJSON, "Format of CloudEvents in "+modeStructured+
+		" and "+modeBatch+" modes. One of ["+eventFormatJSON+", "+eventFormatProtobuf+"]")
+
+	opts.webhook = f.String("webhook", "", "Generate webhook requests of the given vendor instead of CloudEvents. "+
+		"The data defaults to the payload of a push event. One of ["+webhookGitHub+", "+webhookGitLab+"]")
+	opts.webhookSecret = f.String("webhook-secret", "", "Secret used to sign "+webhookGitHub+" payloads, "+
+		"or sent as the token of "+webhookGitLab+" requests")
+
+	opts.format = f.String("format", formatJSON, "Output format of generated targets. "+
+		"One of ["+formatJSON+", "+formatHTTP+", "+formatNDJSON+", "+formatK6+"]")
+	opts.bodyDir = f.String("body-dir", "", "Directory in which request bodies are written in "+formatHTTP+" format")
+	opts.k6Script = f.String("k6-script", "", "Path of a file to write a k6 script to, which sends the "+
+		"requests written to stdout in "+formatK6+" format")
+
+	opts.attack = f.Bool("attack", false, "Send generated CloudEvents to the target URL instead of writing "+
+		"vegeta targets to stdout, and write a report of the attack to stdout")
+	opts.load = f.String("load", loadConstant+":rate=50", "Load profile of the attack, e.g. '"+
+		loadRamp+":from=100,to=1000'. One of ["+loadConstant+", "+loadRamp+", "+loadSteps+", "+
+		loadSpike+", "+loadSine+"]")
+	opts.numTargets = f.Uint64("n", 0, "Number of targets to generate, or requests to send in attack mode. "+
+		"0 = unlimited")
+	opts.duration = f.Duration("duration", 0, "Duration of the generation, or of the attack in attack mode. "+
+		"0 = unlimited")
+	opts.seed = f.Int64("seed", 0, "Seed used to generate event IDs and random template values, "+
+		"which makes generated targets reproducible. 0 = random seed")
+	opts.parallel = f.Uint("parallel", 1, "Number of targets generated in parallel. Values greater than 1 "+
+		"generate batches of targets on multiple goroutines, written in order to a buffered output. "+
+		"Only supported with the "+formatJSON+" output format")
+	opts.faultRate = f.Float64("fault-rate", 0, "Fraction of generated requests, between 0 and 1, which are "+
+		"deliberately malformed. Faulty requests are not counted by -n, except in attack mode")
+	opts.faultKindList = f.String("faults", strings.Join(faultKinds, ","), "Comma-separated list of kinds of "+
+		"faults injected in malformed requests")
+	opts.faultDataSize = f.Uint("fault-data-size", defaultOversizedDataSize, "Size in bytes of the data of "+
+		"events in requests with the '"+faultOversized+"' fault")
+	opts.replay = f.String("replay", "", "Path of a file containing captured CloudEvents to replay instead of "+
+		"generating events, either in the JSON event format (one per line) or in the event-display log format. "+
+		"'-' reads from stdin")
+	opts.speed = f.Float64("speed", 1, "Factor by which the gaps between replayed events are shortened, "+
+		"e.g. 2 replays events twice as fast")
+	opts.rewriteIDs = f.Bool("rewrite-ids", false, "Replace the ID of each replayed event with a unique ID")
+	opts.workers = f.Uint64("workers", vegeta.DefaultWorkers, "Initial number of workers used in the attack")
+	opts.maxWorkers = f.Uint64("max-workers", vegeta.DefaultMaxWorkers, "Maximum number of workers used in the attack")
+	opts.connections = f.Int("connections", vegeta.DefaultConnections, "Maximum number of idle open connections "+
+		"per target host")
+	opts.timeout = f.Duration("timeout", vegeta.DefaultTimeout, "Timeout of requests sent during the attack")
+	opts.http2 = f.Bool("http2", true, "Send requests over HTTP/2 when supported by the target")
+	opts.resultsFile = f.String("o", "", "Path of a file to write the results of the attack to, "+
+		"in a format compatible with 'vegeta report' and 'vegeta encode'")
+
 	if err := f.Parse(args[1:]); err != nil {
 		return nil, err
 	}
 
-	if *opts.targetURL == "" {
+	if len(opts.targetURLs) == 0 {
 		return nil, fmt.Errorf("target URL isn't set")
 	}
-	if _, err := url.Parse(*opts.targetURL); err != nil {
-		return nil, fmt.Errorf("invalid target URL: %w", err)
+	for _, u := range opts.targetURLs {
+		if _, err := url.Parse(u); err != nil {
+			return nil, fmt.Errorf("invalid target URL: %w", err)
+		}
+	}
+
+	if *opts.partitionKeys > math.MaxInt64 {
+		return nil, fmt.Errorf("number of partition keys must not exceed %d", uint64(math.MaxInt64))
+	}
+
+	switch *opts.distribution {
+	case distRoundRobin, distRandom:
+	case distHash:
+		if *opts.partitionKeys == 0 {
+			return nil, fmt.Errorf("%s distribution requires partition keys", distHash)
+		}
+	default:
+		return nil, fmt.Errorf("invalid distribution strategy %q", *opts.distribution)
 	}
 
-	if *opts.ceData == "" {
+	if *opts.replay != "" {
+		if *opts.ceData != "" || *opts.sizes != "" || *opts.avroSchema != "" || *opts.profilesFile != "" ||
+			*opts.webhook != "" {
+			return nil, fmt.Errorf("replayed events and event data are mutually exclusive")
+		}
+		if *opts.speed <= 0 {
+			return nil, fmt.Errorf("speed factor must be greater than 0")
+		}
+		if *opts.mode == modeBatch {
+			return nil, fmt.Errorf("replayed events don't support %s content mode", modeBatch)
+		}
+		if len(opts.targetURLs) > 1 {
+			return nil, fmt.Errorf("replayed events can only be sent to a single target URL")
+		}
+		if *opts.eventFormat != eventFormatJSON {
+			return nil, fmt.Errorf("replayed events don't support the %s event format", *opts.eventFormat)
+		}
+		if len(opts.extensions) > 0 || *opts.subject != "" || *opts.dataSchema != "" || *opts.timeAttr != "" ||
+			*opts.stamp || *opts.partitionKeys > 0 {
+			return nil, fmt.Errorf("replayed events keep their original attributes")
+		}
+	} else if *opts.ceData == "" && *opts.sizes == "" && *opts.profilesFile == "" && *opts.webhook == "" {
 		return nil, fmt.Errorf("event data isn't set")
 	}
+	if *opts.ceData != "" && *opts.sizes != "" {
+		return nil, fmt.Errorf("event data and size distribution are mutually exclusive")
+	}
+	if *opts.avroSchema != "" {
+		if *opts.sizes != "" {
+			return nil, fmt.Errorf("size distribution and Avro schema are mutually exclusive")
+		}
+		if !isFlagSet(f, "datacontenttype") {
+			*opts.dataContentType = contentTypeAvro
+		}
+	}
 
-	return opts, nil
-}
+	for name := range opts.extensions {
+		if err := validateExtensionName(name); err != nil {
+			return nil, err
+		}
+		if err := opts.validateExtensionConflicts(name); err != nil {
+			return nil, err
+		}
+	}
+	if *opts.dataSchema != "" {
+		if _, err := url.Parse(*opts.dataSchema); err != nil {
+			return nil, fmt.Errorf("invalid data schema: %w", err)
+		}
+	}
+	if t := *opts.timeAttr; t != "" && t != timeNow {
+		if _, err := time.Parse(time.RFC3339, t); err != nil {
+			return nil, fmt.Errorf("invalid time attribute: %w", err)
+		}
+	}
+	if *opts.dataContentType == "" {
+		return nil, fmt.Errorf("data content type isn't set")
+	}
 
-// CloudEventTargetsGenerator generates CloudEvent vegeta targets.
-type CloudEventTargetsGenerator struct {
-	targetURL string
+	switch *opts.mode {
+	case modeBinary, modeStructured:
+	case modeBatch:
+		if *opts.batchSize == 0 {
+			return nil, fmt.Errorf("batch size must be greater than 0")
+		}
+	default:
+		return nil, fmt.Errorf("invalid content mode %q", *opts.mode)
+	}
 
-	uuidGen    *uuid.Generator
-	typeAttr   string
-	sourceAttr string
-	data       []byte
+	switch *opts.eventFormat {
+	case eventFormatJSON:
+	case eventFormatProtobuf:
+		if *opts.mode == modeBinary {
+			return nil, fmt.Errorf("%s event format requires %s or %s content mode",
+				eventFormatProtobuf, modeStructured, modeBatch)
+		}
+		if *opts.format == formatNDJSON {
+			return nil, fmt.Errorf("%s format doesn't support the %s event format", formatNDJSON, eventFormatProtobuf)
+		}
+	default:
+		return nil, fmt.Errorf("invalid event format %q", *opts.eventFormat)
+	}
 
-	// Once used to initialize the buffer pool on the first call to Generate.
-	bufOnce sync.Once
-}
+	switch *opts.format {
+	case formatJSON, formatK6:
+	case formatHTTP:
+		if *opts.bodyDir == "" {
+			return nil, fmt.Errorf("body directory isn't set")
+		}
+	case formatNDJSON:
+		if *opts.mode == modeBatch {
+			return nil, fmt.Errorf("%s format doesn't support %s content mode", formatNDJSON, modeBatch)
+		}
+	default:
+		return nil, fmt.Errorf("invalid output format %q", *opts.format)
+	}
 
-// NewCloudEventTargetsGenerator returns a generator that yields vegeta JSON
-// targets containing CloudEvents with static data and IDs that are guaranteed
-// to be unique.
-func NewCloudEventTargetsGenerator(url, typeAttr, sourceAttr string, data []byte) *CloudEventTargetsGenerator {
-	return &CloudEventTargetsGenerator{
-		targetURL:  url,
-		uuidGen:    uuid.MustNewGenerator(),
-		typeAttr:   typeAttr,
-		sourceAttr: sourceAttr,
-		data:       data,
+	if err := validateWebhook(*opts.webhook); err != nil {
+		return nil, err
+	}
+	if *opts.webhook != "" {
+		switch {
+		case len(opts.extensions) > 0 || *opts.subject != "" || *opts.dataSchema != "":
+			return nil, fmt.Errorf("webhook requests don't carry CloudEvent attributes")
+		case *opts.avroSchema != "":
+			return nil, fmt.Errorf("webhook requests don't support Avro data")
+		}
+		if err := opts.validateWebhookProfile(); err != nil {
+			return nil, err
+		}
 	}
-}
 
-// Buffer pool for jwriter.Writer's underlying Buffer and output.
-var writerBufPool *sync.Pool
+	if r := *opts.faultRate; r < 0 || r >= 1 {
+		return nil, fmt.Errorf("fault rate must be in the range [0, 1)")
+	}
+	if *opts.faultRate > 0 && *opts.replay != "" {
+		return nil, fmt.Errorf("faults can't be injected into replayed events")
+	}
+	faultKinds, err := parseFaultKinds(*opts.faultKindList)
+	if err != nil {
+		return nil, err
+	}
+	opts.faultKinds = faultKinds
 
-// Generate returns a target serialized as JSON.
-func (g *CloudEventTargetsGenerator) Generate() ([]byte, error) {
-	var t jsonTarget
+	if *opts.parallel == 0 {
+		return nil, fmt.Errorf("parallelism must be greater than 0")
+	}
+	if *opts.parallel > 1 {
+		switch {
+		case *opts.format != formatJSON:
+			return nil, fmt.Errorf("parallel generation only supports the %s output format", formatJSON)
+		case *opts.attack:
+			return nil, fmt.Errorf("parallel generation isn't supported in attack mode")
+		case *opts.replay != "":
+			return nil, fmt.Errorf("replayed events can't be generated in parallel")
+		}
+	}
 
-	t.Method = http.MethodPost
-	t.URL = g.targetURL
+	// the pace of replayed events is determined by their original timing
+	if *opts.attack && *opts.replay == "" {
+		if *opts.format != formatJSON {
+			return nil, fmt.Errorf("output format can't be set in attack mode")
+		}
 
-	// we avoid using http.Header.Set() because it attempts to
-	// sanitize every input, making it more expensive than
-	// accessing the Header map directly.
-	t.Header = http.Header{
-		"Ce-Id":          []string{g.uuidGen.Hex128()},
-		"Ce-Type":        []string{g.typeAttr},
-		"Ce-Source":      []string{g.sourceAttr},
-		"Ce-Specversion": []string{"1.0"},
-		"Content-Type":   []string{"application/json"},
+		var err error
+		if opts.pacer, err = parseLoadProfile(*opts.load, *opts.duration); err != nil {
+			return nil, err
+		}
 	}
 
-	t.Body = g.data
+	return opts, nil
+}
 
-	// encode inside the Once fn to determine the size of buffers in sync pools
-	g.bufOnce.Do(func() {
-		var jw jwriter.Writer
-		t.encode(&jw)
-		dataBytes, _ := jw.BuildBytes()
-		dataSize := len(dataBytes)
+// validateExtensionConflicts returns an error if the extension attribute with
+// the given name would be overwritten by an attribute which the command's
+// options set on every event.
+func (o *cmdOpts) validateExtensionConflicts(name string) error {
+	if *o.stamp && (name == extStampSeq || name == extStampTime) {
+		return fmt.Errorf("extension attribute %q conflicts with the stamping of events", name)
+	}
+	if *o.partitionKeys > 0 && name == extPartitionKey {
+		return fmt.Errorf("extension attribute %q conflicts with the generation of partition keys", name)
+	}
+	return nil
+}
 
-		writerBufPool = &sync.Pool{
-			New: func() interface{} {
-				return make([]byte, 0, dataSize)
-			},
+// validateProfiles returns an error if any of the given event profiles, read
+// from a configuration file, is incompatible with the command's options.
+func (o *cmdOpts) validateProfiles(profiles []*EventProfile) error {
+	for i, p := range profiles {
+		if p.Webhook != "" {
+			if err := o.validateWebhookProfile(); err != nil {
+				return fmt.Errorf("invalid profile at index %d: %w", i, err)
+			}
+			continue
 		}
-	})
-
-	writerBuf := writerBufPool.Get().([]byte)
-	buildBuf := writerBufPool.Get().([]byte)
-	defer writerBufPool.Put(buildBuf[:0])
-	defer writerBufPool.Put(writerBuf[:0])
 
-	jw := &jwriter.Writer{
-		Buffer: buffer.Buffer{
-			Buf: writerBuf,
-		},
+		for name := range p.Extensions {
+			if err := o.validateExtensionConflicts(name); err != nil {
+				return fmt.Errorf("invalid profile at index %d: %w", i, err)
+			}
+		}
 	}
+	return nil
+}
 
-	t.encode(jw)
+// validateWebhookProfile returns an error if the command's options are
+// incompatible with the generation of webhook requests.
+func (o *cmdOpts) validateWebhookProfile() error {
+	switch {
+	case *o.mode != modeBinary:
+		return fmt.Errorf("webhook requests require %s content mode", modeBinary)
+	case *o.format == formatNDJSON:
+		return fmt.Errorf("%s format doesn't support webhook requests", formatNDJSON)
+	case *o.timeAttr != "" || *o.stamp || *o.partitionKeys > 0:
+		return fmt.Errorf("webhook requests don't carry CloudEvent attributes")
+	case *o.faultRate > 0:
+		return fmt.Errorf("faults can't be injected into webhook requests")
+	}
+	return nil
+}
 
-	return jw.BuildBytes(buildBuf)
+// isFlagSet returns whether the flag with the given name was set on the
+// command line.
+func isFlagSet(f *flag.FlagSet, name string) bool {
+	var isSet bool
+	f.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			isSet = true
+		}
+	})
+	return isSet
 }
@@ -23,6 +23,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -202,6 +203,67 @@ func TestDataFromFile(t *testing.T) {
 	}
 }
 
+func TestBoundedGeneration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	t.Run("number of targets", func(t *testing.T) {
+		var stdout strings.Builder
+		var stderr strings.Builder
+
+		err := run(ctx, []string{tCmd, "-u=http://target", "-d={}", "-n=3"}, &stdout, &stderr)
+		if err != nil {
+			t.Fatal("Unexpected runtime error:", err)
+		}
+
+		if l := strings.Count(stdout.String(), "\n"); l != 3 {
+			t.Errorf("Expected 3 targets, got %d", l)
+		}
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u=http://target", "-d={}", "-duration=10ms"}, ioutil.Discard, ioutil.Discard)
+		if err != nil {
+			t.Fatal("Unexpected runtime error:", err)
+		}
+
+		if err := ctx.Err(); err != nil {
+			t.Fatal("Test context marked as done before generation stopped:", err)
+		}
+	})
+}
+
+func TestReproducibleGeneration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	generate := func(t *testing.T, seed string) string {
+		t.Helper()
+
+		var stdout strings.Builder
+		var stderr strings.Builder
+
+		err := run(ctx, []string{tCmd, "-u=http://target", "-n=10", "-seed=" + seed, "-template",
+			"-d={\"id\":\"{{uuid}}\",\"r\":\"{{rand 16}}\",\"c\":\"{{pick a,b,c}}\"}"}, &stdout, &stderr)
+		if err != nil {
+			t.Fatal("Unexpected runtime error:", err)
+		}
+
+		return stdout.String()
+	}
+
+	out1 := generate(t, "42")
+	out2 := generate(t, "42")
+	out3 := generate(t, "43")
+
+	if out1 != out2 {
+		t.Errorf("Expected identical outputs with the same seed:\n%s\n%s", out1, out2)
+	}
+	if out1 == out3 {
+		t.Error("Expected different outputs with different seeds")
+	}
+}
+
 func TestArgs(t *testing.T) {
 	var stdout strings.Builder
 	var stderr strings.Builder
@@ -233,6 +295,31 @@ func TestArgs(t *testing.T) {
 		}
 	})
 
+	t.Run("invalid -mode value", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-mode", "invalid"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := `invalid content mode "invalid"`
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("zero -batch-size value", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-mode", "batch", "-batch-size", "0"},
+			&stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "batch size must be greater than 0"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
 	t.Run("empty -d value", func(t *testing.T) {
 		err := run(ctx, []string{tCmd, "-u", "http://target"}, &stdout, &stderr)
 		if err == nil {
@@ -244,22 +331,259 @@ func TestArgs(t *testing.T) {
 			t.Fatalf("Unexpected error message: %q", errStr)
 		}
 	})
-}
+	t.Run("-format=http without -body-dir", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-format", "http"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
 
-func BenchmarkGenerate(b *testing.B) {
-	const (
-		url = "http://localhost"
-		typ = "test.event"
-		src = "cegen/go/benchmark"
-	)
+		expectMsg := "body directory isn't set"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("reserved -e name", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-e", "type=x"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
 
-	data := bytes.Repeat([]byte{'0'}, 2048)
+		expectMsg := `"type" is a reserved attribute name`
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
 
-	g := NewCloudEventTargetsGenerator(url, typ, src, data)
+	t.Run("invalid -time value", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-time", "yesterday"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
 
-	for i := 0; i < b.N; i++ {
-		if _, err := g.Generate(); err != nil {
-			b.Error("Generate returned an error:", err)
+		expectMsg := "invalid time attribute"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
 		}
-	}
+	})
+
+	t.Run("-distribution=hash without -partition-keys", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://a", "-u", "http://b", "-d", "{}", "-distribution", "hash"},
+			&stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "hash distribution requires partition keys"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("profile extension conflicting with -stamp", func(t *testing.T) {
+		profilesFile := filepath.Join(t.TempDir(), "profiles.json")
+		err := ioutil.WriteFile(profilesFile, []byte(`{"profiles": [{"data": "{}", "extensions": {"sequence": "1"}}]}`),
+			0644)
+		if err != nil {
+			t.Fatal("Error writing profiles file:", err)
+		}
+
+		err = run(ctx, []string{tCmd, "-u", "http://target", "-profiles", profilesFile, "-stamp"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := `invalid profile at index 0: extension attribute "sequence" conflicts with the stamping of events`
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("webhook profile with incompatible flags", func(t *testing.T) {
+		profilesFile := filepath.Join(t.TempDir(), "profiles.json")
+		err := ioutil.WriteFile(profilesFile, []byte(`{"profiles": [{"data": "{}"}, {"webhook": "github"}]}`), 0644)
+		if err != nil {
+			t.Fatal("Error writing profiles file:", err)
+		}
+
+		testCases := map[string]struct {
+			args      []string
+			expectMsg string
+		}{
+			"-fault-rate": {
+				args:      []string{"-fault-rate", "0.1"},
+				expectMsg: "invalid profile at index 1: faults can't be injected into webhook requests",
+			},
+			"-stamp": {
+				args:      []string{"-stamp"},
+				expectMsg: "invalid profile at index 1: webhook requests don't carry CloudEvent attributes",
+			},
+			"-partition-keys": {
+				args:      []string{"-partition-keys", "8"},
+				expectMsg: "invalid profile at index 1: webhook requests don't carry CloudEvent attributes",
+			},
+			"-mode": {
+				args:      []string{"-mode", "structured"},
+				expectMsg: "invalid profile at index 1: webhook requests require binary content mode",
+			},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				args := append([]string{tCmd, "-u", "http://target", "-profiles", profilesFile}, tc.args...)
+
+				err := run(ctx, args, &stdout, &stderr)
+				if err == nil {
+					t.Fatal("Expected command to fail")
+				}
+				if errStr := err.Error(); !strings.Contains(errStr, tc.expectMsg) {
+					t.Fatalf("Unexpected error message: %q", errStr)
+				}
+			})
+		}
+	})
+
+	t.Run("-replay with generated attributes", func(t *testing.T) {
+		testCases := map[string][]string{
+			"-stamp":          {"-stamp"},
+			"-e":              {"-e", "category=test"},
+			"-partition-keys": {"-partition-keys", "8"},
+			"-time":           {"-time", "now"},
+			"-subject":        {"-subject", "test"},
+		}
+
+		for name, flags := range testCases {
+			t.Run(name, func(t *testing.T) {
+				args := append([]string{tCmd, "-u", "http://target", "-replay", "capture.ndjson"}, flags...)
+
+				err := run(ctx, args, &stdout, &stderr)
+				if err == nil {
+					t.Fatal("Expected command to fail")
+				}
+
+				expectMsg := "replayed events keep their original attributes"
+				if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+					t.Fatalf("Unexpected error message: %q", errStr)
+				}
+			})
+		}
+	})
+
+	t.Run("-partition-keys out of range", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-partition-keys", "9223372036854775808"},
+			&stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "number of partition keys must not exceed 9223372036854775807"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("both -d and -size values", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-size", "fixed:size=64"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "mutually exclusive"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("-event-format=protobuf in binary mode", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-event-format", "protobuf"},
+			&stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "protobuf event format requires structured or batch content mode"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("-parallel with -format=k6", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-parallel", "2", "-format", "k6"},
+			&stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "parallel generation only supports the json output format"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("out of range -fault-rate value", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-fault-rate", "1"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "fault rate must be in the range [0, 1)"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("-webhook in structured mode", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-webhook", "github", "-mode", "structured"},
+			&stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "webhook requests require binary content mode"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("-webhook with CloudEvent attributes", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-webhook", "gitlab", "-stamp"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "webhook requests don't carry CloudEvent attributes"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("invalid -webhook value", func(t *testing.T) {
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-webhook", "bitbucket"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := `invalid webhook "bitbucket"`
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("invalid -avro-schema file", func(t *testing.T) {
+		schemaFile := filepath.Join(t.TempDir(), "schema.avsc")
+		if err := ioutil.WriteFile(schemaFile, []byte(`{"type": "unknown"}`), 0o644); err != nil {
+			t.Fatal("Error writing Avro schema:", err)
+		}
+
+		err := run(ctx, []string{tCmd, "-u", "http://target", "-d", "{}", "-avro-schema", schemaFile},
+			&stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "parsing Avro schema"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
 }
@@ -0,0 +1,116 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseSpec splits the given specification in the form "name:k1=v1,k2=v2"
+// into a name and its parameters.
+func parseSpec(spec string) (string, specParams, error) {
+	i := strings.IndexByte(spec, ':')
+	if i == -1 {
+		return spec, nil, nil
+	}
+
+	params, err := parseSpecParams(spec[i+1:])
+	if err != nil {
+		return "", nil, err
+	}
+
+	return spec[:i], params, nil
+}
+
+// specParams are the parameters of a specification such as a load profile or
+// a size distribution, expressed as a comma-separated list of key=value pairs.
+type specParams map[string]*specParam
+
+type specParam struct {
+	value string
+	used  bool
+}
+
+// parseSpecParams parses the given list of key=value pairs.
+func parseSpecParams(s string) (specParams, error) {
+	params := make(specParams)
+
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.IndexByte(kv, '=')
+		if i == -1 {
+			return nil, fmt.Errorf("invalid parameter %q, expected key=value", kv)
+		}
+		params[kv[:i]] = &specParam{value: kv[i+1:]}
+	}
+
+	return params, nil
+}
+
+// positiveInt returns the value of the given parameter as a positive integer.
+func (p specParams) positiveInt(key string) (uint, error) {
+	v, err := p.get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || r == 0 {
+		return 0, fmt.Errorf("parameter %q must be a positive integer, got %q", key, v)
+	}
+
+	return uint(r), nil
+}
+
+// duration returns the value of the given parameter as a time.Duration.
+func (p specParams) duration(key string) (time.Duration, error) {
+	v, err := p.get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("parameter %q must be a positive duration, got %q", key, v)
+	}
+
+	return d, nil
+}
+
+// get returns the raw value of the given parameter.
+func (p specParams) get(key string) (string, error) {
+	param, ok := p[key]
+	if !ok {
+		return "", fmt.Errorf("missing parameter %q", key)
+	}
+
+	param.used = true
+	return param.value, nil
+}
+
+// unused returns the names of parameters which were never read.
+func (p specParams) unused() []string {
+	var keys []string
+	for k, param := range p {
+		if !param.used {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// Number of consecutive targets generated by a worker in each batch.
+	pipelineBatchSize = 256
+	// Number of batches a worker can generate ahead of the writer.
+	pipelineDepth = 2
+	// Size of the buffer in which batches are written before being
+	// flushed to the output.
+	pipelineWriteBufferSize = 1 << 20
+)
+
+// pipeline generates vegeta JSON targets using multiple generators in
+// parallel, and writes them to an output in order, so that sequence numbers,
+// profiles and the round-robin distribution of targets match the ones of a
+// single generator. Values drawn randomly, such as template functions,
+// synthetic sizes, partition keys and faults, come from the source of
+// randomness of each worker, and therefore differ from the ones of a single
+// generator.
+//
+// Targets are split into batches of consecutive positions in the sequence of
+// targets, which are distributed to workers in round-robin: with N workers,
+// worker i generates batches i, i+N, i+2N, and so on. The writer collects
+// batches from workers in the same order.
+type pipeline struct {
+	// Generator of each worker. Generators must be configured identically,
+	// apart from their sources of randomness.
+	gens []*CloudEventTargetsGenerator
+	// Total number of targets to generate. 0 = unlimited.
+	numTargets uint64
+	// Number of targets per batch.
+	batchSize uint64
+}
+
+// targetBatch is a batch of vegeta JSON targets, each followed by a newline.
+type targetBatch struct {
+	buf []byte
+	err error
+}
+
+// newPipeline returns a pipeline which generates numTargets targets using the
+// given generators, one per worker.
+func newPipeline(gens []*CloudEventTargetsGenerator, numTargets uint64) *pipeline {
+	return &pipeline{
+		gens:       gens,
+		numTargets: numTargets,
+		batchSize:  pipelineBatchSize,
+	}
+}
+
+// run writes targets to w until either the context is cancelled or the
+// pipeline's number of targets is reached.
+func (p *pipeline) run(ctx context.Context, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	batches := make([]chan *targetBatch, len(p.gens))
+	freeBatches := make([]chan *targetBatch, len(p.gens))
+
+	for i, g := range p.gens {
+		batches[i] = make(chan *targetBatch, pipelineDepth)
+
+		// one batch per slot in the queue, plus the one being generated
+		freeBatches[i] = make(chan *targetBatch, pipelineDepth+1)
+		for j := 0; j < pipelineDepth+1; j++ {
+			freeBatches[i] <- &targetBatch{}
+		}
+
+		wg.Add(1)
+		go func(i int, g *CloudEventTargetsGenerator) {
+			defer wg.Done()
+			defer close(batches[i])
+			p.work(ctx, i, g, batches[i], freeBatches[i])
+		}(i, g)
+	}
+
+	bw := bufio.NewWriterSize(w, pipelineWriteBufferSize)
+
+	for i := 0; ; i = (i + 1) % len(p.gens) {
+		var b *targetBatch
+		var ok bool
+
+		select {
+		case <-ctx.Done():
+			return bw.Flush()
+		case b, ok = <-batches[i]:
+		}
+
+		// batches are collected in the order they were distributed,
+		// so the first worker to run out of batches marks the end of
+		// the sequence
+		if !ok {
+			return bw.Flush()
+		}
+
+		if b.err != nil {
+			return b.err
+		}
+
+		if _, err := bw.Write(b.buf); err != nil {
+			return fmt.Errorf("writing targets: %w", err)
+		}
+
+		freeBatches[i] <- b
+	}
+}
+
+// work generates the batches of targets attributed to the worker at the given
+// index, and sends them to out. Batches are recycled from free.
+func (p *pipeline) work(ctx context.Context, worker int, g *CloudEventTargetsGenerator,
+	out chan<- *targetBatch, free <-chan *targetBatch) {
+
+	stride := uint64(len(p.gens)) * p.batchSize
+
+	for start := uint64(worker) * p.batchSize; p.numTargets == 0 || start < p.numTargets; start += stride {
+		var b *targetBatch
+
+		select {
+		case <-ctx.Done():
+			return
+		case b = <-free:
+		}
+
+		count := p.batchSize
+		if p.numTargets != 0 && p.numTargets-start < count {
+			count = p.numTargets - start
+		}
+
+		b.buf = b.buf[:0]
+
+		g.seek(start)
+
+		// faulty targets are not counted, so that batches span
+		// consecutive sequence numbers
+		for n := uint64(0); n < count; {
+			trg, err := g.Generate()
+			if err != nil {
+				b.err = fmt.Errorf("generating vegeta JSON target: %w", err)
+				break
+			}
+
+			b.buf = append(b.buf, trg...)
+			b.buf = append(b.buf, '\n')
+
+			if g.fault == "" {
+				n++
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case out <- b:
+		}
+
+		if b.err != nil {
+			return
+		}
+	}
+}
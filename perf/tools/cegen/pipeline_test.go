@@ -0,0 +1,267 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mailru/easyjson/jlexer"
+)
+
+func TestPipeline(t *testing.T) {
+	profiles := []*EventProfile{
+		{Type: "type.a", Source: "cegen/go/test", Data: []byte("{}")},
+		{Type: "type.b", Source: "cegen/go/test", Data: []byte("{}"), Weight: 2},
+	}
+
+	newGens := func(n int) []*CloudEventTargetsGenerator {
+		gens := make([]*CloudEventTargetsGenerator, n)
+		for i := range gens {
+			gens[i] = NewWeightedCloudEventTargetsGenerator("http://localhost", profiles, WithStamping())
+		}
+		return gens
+	}
+
+	testCases := []struct {
+		workers    int
+		numTargets uint64
+	}{
+		{workers: 1, numTargets: 10},
+		{workers: 3, numTargets: 10},
+		// fewer targets than workers
+		{workers: 4, numTargets: 2},
+		{workers: 3, numTargets: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d workers, %d targets", tc.workers, tc.numTargets), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+
+			p := newPipeline(newGens(tc.workers), tc.numTargets)
+			p.batchSize = 3
+
+			var out bytes.Buffer
+			w := writerFunc(out.Write)
+
+			expectTargets := tc.numTargets
+			if tc.numTargets == 0 {
+				// unlimited generation is interrupted by cancelling the context
+				expectTargets = 50
+				w = func(b []byte) (int, error) {
+					if out.Len() > 0 {
+						cancel()
+					}
+					return out.Write(b)
+				}
+			}
+
+			if err := p.run(ctx, w); err != nil {
+				t.Fatal("Pipeline returned an error:", err)
+			}
+
+			s := bufio.NewScanner(&out)
+
+			var seq uint64
+			for s.Scan() {
+				seq++
+
+				var trg jsonTarget
+				trg.decode(&jlexer.Lexer{Data: s.Bytes()})
+
+				if trgSeq := trg.Header.Get(headerStampSeq); trgSeq != strconv.FormatUint(seq, 10) {
+					t.Fatalf("Expected sequence number %d, got %s", seq, trgSeq)
+				}
+
+				// profiles follow the schedule b, a, b
+				expectType := "type.b"
+				if seq%3 == 2 {
+					expectType = "type.a"
+				}
+				if typ := trg.Header.Get("Ce-Type"); typ != expectType {
+					t.Fatalf("Expected target %d to have type %s, got %s", seq, expectType, typ)
+				}
+			}
+
+			if tc.numTargets != 0 && seq != expectTargets {
+				t.Errorf("Expected %d targets, got %d", expectTargets, seq)
+			}
+			if tc.numTargets == 0 && seq < expectTargets {
+				t.Errorf("Expected at least %d targets, got %d", expectTargets, seq)
+			}
+		})
+	}
+
+	t.Run("round-robin distribution with faults", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		urls := []string{"http://a", "http://b", "http://c"}
+
+		newGen := func() *CloudEventTargetsGenerator {
+			return NewWeightedCloudEventTargetsGenerator("http://localhost", profiles, WithStamping(),
+				WithTargetURLs(urls, distRoundRobin), WithFaults(0.3, []string{faultMissingID}, 0))
+		}
+
+		// expectURLs verifies that each valid target is sent to the URL
+		// matching its position in the sequence of targets
+		expectURLs := func(t *testing.T, targets [][]byte) {
+			t.Helper()
+
+			var numValid int
+			for _, b := range targets {
+				var trg jsonTarget
+				trg.decode(&jlexer.Lexer{Data: b})
+
+				if trg.Header.Get(headerFault) != "" {
+					continue
+				}
+
+				if expect := urls[numValid%len(urls)]; trg.URL != expect {
+					t.Fatalf("Expected target %d to be sent to %s, got %s", numValid+1, expect, trg.URL)
+				}
+				numValid++
+			}
+		}
+
+		t.Run("single generator", func(t *testing.T) {
+			g := newGen()
+
+			var targets [][]byte
+			for i := 0; i < 100; i++ {
+				trg, err := g.Generate()
+				if err != nil {
+					t.Fatal("Generate returned an error:", err)
+				}
+				targets = append(targets, append([]byte(nil), trg...))
+			}
+
+			expectURLs(t, targets)
+		})
+
+		t.Run("pipeline", func(t *testing.T) {
+			gens := []*CloudEventTargetsGenerator{newGen(), newGen(), newGen()}
+
+			p := newPipeline(gens, 100)
+			p.batchSize = 4
+
+			var out bytes.Buffer
+			if err := p.run(ctx, &out); err != nil {
+				t.Fatal("Pipeline returned an error:", err)
+			}
+
+			var targets [][]byte
+			for s := bufio.NewScanner(&out); s.Scan(); {
+				targets = append(targets, append([]byte(nil), s.Bytes()...))
+			}
+
+			expectURLs(t, targets)
+		})
+	})
+
+	t.Run("generation error", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		codec, err := readAvroSchema(writeTestAvroSchema(t))
+		if err != nil {
+			t.Fatal("Error reading Avro schema:", err)
+		}
+
+		gens := make([]*CloudEventTargetsGenerator, 2)
+		for i := range gens {
+			gens[i] = NewWeightedCloudEventTargetsGenerator("http://localhost", []*EventProfile{{
+				Type:      "test.event",
+				Source:    "cegen/go/test",
+				Data:      []byte(`{"seq":"one"}`),
+				AvroCodec: codec,
+			}})
+		}
+
+		err = newPipeline(gens, 0).run(ctx, ioutil.Discard)
+		if err == nil {
+			t.Fatal("Expected pipeline to fail")
+		}
+
+		const expectMsg = "generating vegeta JSON target"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Errorf("Expected error to contain %q, got %q", expectMsg, errStr)
+		}
+	})
+}
+
+// writerFunc is an io.Writer implemented by a function.
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) { return f(b) }
+
+// BenchmarkGenerateOutput compares the throughput of the sequential generation
+// loop with the one of the parallel pipeline, with templated payloads written
+// to the null device.
+func BenchmarkGenerateOutput(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal("Error opening null device:", err)
+	}
+	defer devNull.Close()
+
+	data := `{"id":"{{uuid}}","seq":{{seq}},"value":"{{rand 1024}}"}`
+
+	tmpl, err := parseDataTemplate([]byte(data))
+	if err != nil {
+		b.Fatal("Error parsing template:", err)
+	}
+
+	newGen := func() *CloudEventTargetsGenerator {
+		return NewWeightedCloudEventTargetsGenerator("http://localhost", []*EventProfile{{
+			Type:     "test.event",
+			Source:   "cegen/go/benchmark",
+			Template: tmpl,
+		}})
+	}
+
+	b.Run("sequential", func(b *testing.B) {
+		n := uint64(b.N)
+		opts := &cmdOpts{numTargets: &n, duration: new(time.Duration)}
+
+		if err := generate(context.Background(), newGen(), nil, opts, devNull); err != nil {
+			b.Fatal("Generation returned an error:", err)
+		}
+	})
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("pipeline/%d workers", workers), func(b *testing.B) {
+			gens := make([]*CloudEventTargetsGenerator, workers)
+			for i := range gens {
+				gens[i] = newGen()
+			}
+
+			if err := newPipeline(gens, uint64(b.N)).run(context.Background(), devNull); err != nil {
+				b.Fatal("Pipeline returned an error:", err)
+			}
+		})
+	}
+}
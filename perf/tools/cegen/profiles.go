@@ -0,0 +1,324 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// EventProfile describes a category of CloudEvents to generate.
+type EventProfile struct {
+	Type       string
+	Source     string
+	Extensions map[string]string
+	// Optional context attributes, omitted when empty. The content type
+	// of the data defaults to application/json.
+	Subject         string
+	DataSchema      string
+	DataContentType string
+
+	Data []byte
+	// Template rendered into the data of each event. Data is ignored
+	// when a template is set.
+	Template *dataTemplate
+	// Distribution of the sizes of synthetic JSON payloads. Data and
+	// Template are ignored when a distribution is set.
+	Sizes sizeDistribution
+	// Codec used to convert Data, or the rendered Template, from Avro's
+	// JSON encoding to the Avro binary encoding. The content type of the
+	// data defaults to application/avro when a codec is set.
+	AvroCodec *goavro.Codec
+	// Vendor of the webhook requests generated instead of CloudEvents, in
+	// binary content mode. Context attributes are ignored, and the data
+	// defaults to the payload of a push event when none is set.
+	Webhook string
+	// Secret used to sign GitHub payloads, or sent as the token of GitLab
+	// requests. Webhook requests aren't authenticated when empty.
+	WebhookSecret string
+	// Frequency of events matching this profile, relative to the weights
+	// of other profiles. A weight of 0 is equivalent to 1, weights above
+	// maxProfileWeight are equivalent to maxProfileWeight.
+	Weight uint
+}
+
+// maxProfileWeight is the maximum weight of an event profile. It bounds the
+// length of the schedule in which profiles are interleaved.
+const maxProfileWeight = 1000
+
+// profilesConfig is the structure of a configuration file containing event
+// profiles.
+type profilesConfig struct {
+	Profiles []profileConfig `json:"profiles"`
+}
+
+// profileConfig is the structure of an event profile inside a configuration
+// file.
+type profileConfig struct {
+	Type       string            `json:"type"`
+	Source     string            `json:"source"`
+	Extensions map[string]string `json:"extensions"`
+
+	Subject         string `json:"subject"`
+	DataSchema      string `json:"dataschema"`
+	DataContentType string `json:"datacontenttype"`
+
+	Data     *string `json:"data"`
+	DataFile string  `json:"dataFile"`
+	Size     string  `json:"size"`
+	Weight   uint    `json:"weight"`
+
+	// Path of a file containing the Avro schema of the data.
+	AvroSchema string `json:"avroSchema"`
+
+	Webhook       string `json:"webhook"`
+	WebhookSecret string `json:"webhookSecret"`
+}
+
+// Extension attribute names are restricted to lower-case alphanumeric
+// characters.
+// https://github.com/cloudevents/spec/blob/v1.0.1/spec.md#attribute-naming-convention
+var extensionNameRegexp = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// reservedAttributes are the names of attributes which are defined by the
+// CloudEvents specification and can't be used as extension attributes.
+// https://github.com/cloudevents/spec/blob/v1.0.1/spec.md#context-attributes
+var reservedAttributes = map[string]struct{}{
+	"specversion":     {},
+	"id":              {},
+	"type":            {},
+	"source":          {},
+	"subject":         {},
+	"dataschema":      {},
+	"time":            {},
+	"datacontenttype": {},
+	"data":            {},
+	"data_base64":     {},
+}
+
+// validateExtensionName returns an error if the given name isn't a valid
+// name for an extension attribute.
+func validateExtensionName(name string) error {
+	if !extensionNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid extension attribute name %q", name)
+	}
+	if _, isReserved := reservedAttributes[name]; isReserved {
+		return fmt.Errorf("%q is a reserved attribute name", name)
+	}
+	return nil
+}
+
+// readProfilesConfig reads event profiles from the JSON configuration file
+// at the given path. Relative paths to data files are resolved from the
+// directory of the configuration file.
+// When parseTmpl is true, the data of each profile is parsed as a template.
+func readProfilesConfig(path string, parseTmpl bool) ([]*EventProfile, error) {
+	cfgData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading configuration file: %w", err)
+	}
+
+	cfg := &profilesConfig{}
+	if err := json.Unmarshal(cfgData, cfg); err != nil {
+		return nil, fmt.Errorf("parsing configuration file: %w", err)
+	}
+
+	if len(cfg.Profiles) == 0 {
+		return nil, fmt.Errorf("configuration file doesn't contain any profile")
+	}
+
+	profiles := make([]*EventProfile, len(cfg.Profiles))
+
+	for i, pCfg := range cfg.Profiles {
+		p, err := pCfg.eventProfile(filepath.Dir(path), parseTmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile at index %d: %w", i, err)
+		}
+		profiles[i] = p
+	}
+
+	return profiles, nil
+}
+
+// eventProfile returns the EventProfile described by the profile
+// configuration.
+func (c *profileConfig) eventProfile(baseDir string, parseTmpl bool) (*EventProfile, error) {
+	p := &EventProfile{
+		Type:       c.Type,
+		Source:     c.Source,
+		Extensions: c.Extensions,
+
+		Subject:         c.Subject,
+		DataSchema:      c.DataSchema,
+		DataContentType: c.DataContentType,
+
+		Webhook:       c.Webhook,
+		WebhookSecret: c.WebhookSecret,
+
+		Weight: c.Weight,
+	}
+
+	if err := validateWebhook(p.Webhook); err != nil {
+		return nil, err
+	}
+	if p.Webhook != "" && c.AvroSchema != "" {
+		return nil, fmt.Errorf("webhook and avroSchema are mutually exclusive")
+	}
+
+	if p.Type == "" {
+		p.Type = ceType
+	}
+	if p.Source == "" {
+		p.Source = ceSource
+	}
+
+	for name := range p.Extensions {
+		if err := validateExtensionName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Weight > maxProfileWeight {
+		return nil, fmt.Errorf("weight must not exceed %d", maxProfileWeight)
+	}
+
+	var numDataSources int
+	for _, isSet := range []bool{c.Data != nil, c.DataFile != "", c.Size != ""} {
+		if isSet {
+			numDataSources++
+		}
+	}
+	if numDataSources > 1 {
+		return nil, fmt.Errorf("data, dataFile and size are mutually exclusive")
+	}
+
+	if c.AvroSchema != "" {
+		if c.Size != "" {
+			return nil, fmt.Errorf("avroSchema and size are mutually exclusive")
+		}
+
+		var err error
+		if p.AvroCodec, err = readAvroSchema(resolvePath(baseDir, c.AvroSchema)); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case c.Size != "":
+		var err error
+		if p.Sizes, err = parseSizeDistribution(c.Size); err != nil {
+			return nil, err
+		}
+		return p, nil
+
+	case c.Data != nil:
+		p.Data = []byte(*c.Data)
+
+	case c.DataFile != "":
+		var err error
+		if p.Data, err = ioutil.ReadFile(resolvePath(baseDir, c.DataFile)); err != nil {
+			return nil, fmt.Errorf("reading data from file: %w", err)
+		}
+
+	case p.Webhook != "":
+		// the data defaults to the webhook's built-in payload
+		return p, nil
+
+	default:
+		return nil, fmt.Errorf("event data isn't set")
+	}
+
+	if parseTmpl {
+		tmpl, err := parseDataTemplate(p.Data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing data template: %w", err)
+		}
+		if !tmpl.isStatic() {
+			p.Template = tmpl
+		}
+	}
+
+	return p, nil
+}
+
+// resolvePath returns the given path, relative to baseDir if it isn't
+// absolute.
+func resolvePath(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// weightedSchedule returns a sequence of indexes in the given list of weights,
+// in which each index appears a number of times equal to its (reduced) weight.
+// Indexes are interleaved as evenly as possible using the smooth weighted
+// round-robin algorithm, so that repeating the sequence indefinitely yields a
+// stream in which each index appears in proportion to its weight.
+// Weights are capped to maxProfileWeight, which bounds the length of the
+// sequence.
+func weightedSchedule(weights []uint) []int {
+	ws := make([]int, len(weights))
+
+	var d uint
+	for i, w := range weights {
+		switch {
+		case w == 0:
+			w = 1
+		case w > maxProfileWeight:
+			w = maxProfileWeight
+		}
+		ws[i] = int(w)
+		d = gcd(d, w)
+	}
+
+	var total int
+	for i := range ws {
+		ws[i] /= int(d)
+		total += ws[i]
+	}
+
+	schedule := make([]int, 0, total)
+	current := make([]int, len(ws))
+
+	for len(schedule) < total {
+		best := 0
+		for i, w := range ws {
+			current[i] += w
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, best)
+	}
+
+	return schedule
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b uint) uint {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
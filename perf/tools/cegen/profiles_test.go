@@ -0,0 +1,238 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWeightedSchedule(t *testing.T) {
+	testCases := []struct {
+		name    string
+		weights []uint
+		expect  []int
+	}{
+		{
+			name:    "single profile",
+			weights: []uint{5},
+			expect:  []int{0},
+		},
+		{
+			name:    "zero weights",
+			weights: []uint{0, 0},
+			expect:  []int{0, 1},
+		},
+		{
+			name:    "reduced weights",
+			weights: []uint{20, 40},
+			expect:  []int{1, 0, 1},
+		},
+		{
+			name:    "interleaved",
+			weights: []uint{5, 1, 1},
+			expect:  []int{0, 0, 1, 0, 2, 0, 0},
+		},
+		{
+			name:    "capped weights",
+			weights: []uint{^uint(0), maxProfileWeight},
+			expect:  []int{0, 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := weightedSchedule(tc.weights)
+
+			if !reflect.DeepEqual(s, tc.expect) {
+				t.Errorf("Expected schedule %v, got %v", tc.expect, s)
+			}
+		})
+	}
+}
+
+func TestReadProfilesConfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cegen")
+	if err != nil {
+		t.Fatal("Error creating temp dir:", err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Fatal("Failed to remove temp dir:", err)
+		}
+	})
+
+	writeFile := func(t *testing.T, name, content string) string {
+		t.Helper()
+
+		path := filepath.Join(tmpDir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal("Error writing temp file:", err)
+		}
+		return path
+	}
+
+	writeFile(t, "data.json", `{"msg":"from file"}`)
+
+	t.Run("valid config", func(t *testing.T) {
+		cfgPath := writeFile(t, "valid.json", `{"profiles": [
+			{"type": "type.a", "source": "src.a", "extensions": {"category": "a"}, "subject": "sub.a", "data": "{}", "weight": 3},
+			{"dataFile": "data.json"}
+		]}`)
+
+		profiles, err := readProfilesConfig(cfgPath, false)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		expect := []*EventProfile{{
+			Type:       "type.a",
+			Source:     "src.a",
+			Extensions: map[string]string{"category": "a"},
+			Subject:    "sub.a",
+			Data:       []byte("{}"),
+			Weight:     3,
+		}, {
+			Type:   ceType,
+			Source: ceSource,
+			Data:   []byte(`{"msg":"from file"}`),
+		}}
+
+		if !reflect.DeepEqual(profiles, expect) {
+			t.Errorf("Unexpected profiles:\n%+v\n%+v", profiles[0], profiles[1])
+		}
+	})
+
+	t.Run("synthetic data", func(t *testing.T) {
+		cfgPath := writeFile(t, "synthetic.json", `{"profiles": [{"size": "fixed:size=1024"}]}`)
+
+		profiles, err := readProfilesConfig(cfgPath, false)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if s := profiles[0].Sizes; s != fixedSizeDistribution(1024) {
+			t.Errorf("Unexpected size distribution: %#v", s)
+		}
+	})
+
+	t.Run("templated data", func(t *testing.T) {
+		cfgPath := writeFile(t, "template.json", `{"profiles": [{"data": "{\"seq\":{{seq}}}"}]}`)
+
+		profiles, err := readProfilesConfig(cfgPath, true)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if profiles[0].Template == nil {
+			t.Error("Expected data to be parsed as a template")
+		}
+	})
+
+	t.Run("Avro data", func(t *testing.T) {
+		writeFile(t, "schema.avsc", `{"type": "record", "name": "R", "fields": [{"name": "n", "type": "int"}]}`)
+		cfgPath := writeFile(t, "avro.json", `{"profiles": [{"data": "{\"n\":1}", "avroSchema": "schema.avsc"}]}`)
+
+		profiles, err := readProfilesConfig(cfgPath, false)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if profiles[0].AvroCodec == nil {
+			t.Error("Expected an Avro codec to be set")
+		}
+	})
+
+	t.Run("webhook without data", func(t *testing.T) {
+		cfgPath := writeFile(t, "webhook.json", `{"profiles": [{"webhook": "github", "webhookSecret": "s3cr3t"}]}`)
+
+		profiles, err := readProfilesConfig(cfgPath, false)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if p := profiles[0]; p.Webhook != webhookGitHub || p.WebhookSecret != "s3cr3t" {
+			t.Errorf("Unexpected webhook %q with secret %q", p.Webhook, p.WebhookSecret)
+		}
+	})
+
+	invalidCases := []struct {
+		name      string
+		config    string
+		expectErr string
+	}{
+		{
+			name:      "no profile",
+			config:    `{"profiles": []}`,
+			expectErr: "doesn't contain any profile",
+		},
+		{
+			name:      "missing data",
+			config:    `{"profiles": [{"type": "type.a"}]}`,
+			expectErr: "event data isn't set",
+		},
+		{
+			name:      "ambiguous data",
+			config:    `{"profiles": [{"data": "{}", "dataFile": "data.json"}]}`,
+			expectErr: "mutually exclusive",
+		},
+		{
+			name:      "invalid size distribution",
+			config:    `{"profiles": [{"size": "fixed:size=0"}]}`,
+			expectErr: `invalid fixed size distribution`,
+		},
+		{
+			name:      "Avro data with size distribution",
+			config:    `{"profiles": [{"size": "fixed:size=64", "avroSchema": "schema.avsc"}]}`,
+			expectErr: "avroSchema and size are mutually exclusive",
+		},
+		{
+			name:      "invalid webhook",
+			config:    `{"profiles": [{"webhook": "bitbucket"}]}`,
+			expectErr: `invalid webhook "bitbucket"`,
+		},
+		{
+			name:      "invalid extension name",
+			config:    `{"profiles": [{"data": "{}", "extensions": {"Invalid-Name": "x"}}]}`,
+			expectErr: `invalid extension attribute name "Invalid-Name"`,
+		},
+		{
+			name:      "weight out of range",
+			config:    `{"profiles": [{"data": "{}", "weight": 1000000}]}`,
+			expectErr: "weight must not exceed 1000",
+		},
+		{
+			name:      "reserved extension name",
+			config:    `{"profiles": [{"data": "{}", "extensions": {"subject": "x"}}]}`,
+			expectErr: `"subject" is a reserved attribute name`,
+		},
+	}
+
+	for _, tc := range invalidCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfgPath := writeFile(t, "invalid.json", tc.config)
+
+			_, err := readProfilesConfig(cfgPath, false)
+			if err == nil {
+				t.Fatal("Expected reading to fail")
+			}
+			if errStr := err.Error(); !strings.Contains(errStr, tc.expectErr) {
+				t.Fatalf("Unexpected error message: %q", errStr)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "time"
+
+// CloudEvents protobuf messages are encoded by hand, without generated code,
+// to avoid the allocations of an intermediate representation of each event.
+// https://github.com/cloudevents/spec/blob/v1.0.1/protobuf-format.md
+//
+//	message CloudEvent {
+//	  string id = 1;
+//	  string source = 2;
+//	  string spec_version = 3;
+//	  string type = 4;
+//	  map<string, CloudEventAttributeValue> attributes = 5;
+//	  oneof data {
+//	    bytes binary_data = 6;
+//	    string text_data = 7;
+//	    google.protobuf.Any proto_data = 8;
+//	  }
+//	}
+//
+//	message CloudEventAttributeValue {
+//	  oneof attr {
+//	    bool ce_boolean = 1;
+//	    int32 ce_integer = 2;
+//	    string ce_string = 3;
+//	    bytes ce_bytes = 4;
+//	    string ce_uri = 5;
+//	    string ce_uri_ref = 6;
+//	    google.protobuf.Timestamp ce_timestamp = 7;
+//	  }
+//	}
+//
+//	message CloudEventBatch {
+//	  repeated CloudEvent events = 1;
+//	}
+
+// Media types of protobuf request bodies.
+const (
+	contentTypeCEProtobuf      = "application/cloudevents+protobuf"
+	contentTypeCEBatchProtobuf = "application/cloudevents-batch+protobuf"
+)
+
+// Fields of the CloudEvent message.
+const (
+	protoFieldID          = 1
+	protoFieldSource      = 2
+	protoFieldSpecVersion = 3
+	protoFieldType        = 4
+	protoFieldAttributes  = 5
+	protoFieldBinaryData  = 6
+	protoFieldTextData    = 7
+)
+
+// Fields of map entries.
+const (
+	protoFieldMapKey   = 1
+	protoFieldMapValue = 2
+)
+
+// Fields of the CloudEventAttributeValue message.
+const (
+	protoFieldAttrString    = 3
+	protoFieldAttrURI       = 5
+	protoFieldAttrTimestamp = 7
+)
+
+// Fields of the google.protobuf.Timestamp message.
+const (
+	protoFieldTimestampSeconds = 1
+	protoFieldTimestampNanos   = 2
+)
+
+// Field of the CloudEventBatch message.
+const protoFieldBatchEvents = 1
+
+// Protobuf wire types.
+const (
+	protoWireVarint = 0
+	protoWireBytes  = 2
+)
+
+// appendProtoEvent appends the next event, encoded as a CloudEvent protobuf
+// message, to dst and returns the extended buffer.
+func (g *CloudEventTargetsGenerator) appendProtoEvent(dst []byte) []byte {
+	p := g.nextEvent()
+
+	if g.fault != faultMissingID {
+		dst = appendProtoString(dst, protoFieldID, g.eventID())
+	}
+	if g.fault != faultMissingSource {
+		dst = appendProtoString(dst, protoFieldSource, p.sourceAttr)
+	}
+	if g.fault == faultSpecVersion {
+		dst = appendProtoString(dst, protoFieldSpecVersion, faultySpecVersion)
+	} else {
+		dst = appendProtoString(dst, protoFieldSpecVersion, "1.0")
+	}
+	dst = appendProtoString(dst, protoFieldType, p.typeAttr)
+
+	dst = appendProtoStringAttr(dst, "datacontenttype", protoFieldAttrString, p.dataContentType)
+
+	if p.subject != "" {
+		dst = appendProtoStringAttr(dst, "subject", protoFieldAttrString, p.subject)
+	}
+	if p.dataSchema != "" {
+		dst = appendProtoStringAttr(dst, "dataschema", protoFieldAttrURI, p.dataSchema)
+	}
+	if g.timeAttr != "" {
+		t := g.timeVal
+		if g.timeAttr == timeNow {
+			t = time.Now()
+		}
+		dst = appendProtoTimestampAttr(dst, "time", t)
+	}
+
+	for _, ext := range p.extensions {
+		dst = appendProtoStringAttr(dst, ext.name, protoFieldAttrString, ext.value)
+	}
+
+	if g.numPartitionKeys > 0 {
+		dst = appendProtoStringAttr(dst, extPartitionKey, protoFieldAttrString, g.partitionKey)
+	}
+
+	if g.stamp && g.fault == "" {
+		g.stampBuf = g.appendStampSeq(g.stampBuf[:0])
+		dst = appendProtoBytesAttr(dst, extStampSeq, protoFieldAttrString, g.stampBuf)
+		g.stampBuf = g.appendStampTime(g.stampBuf[:0])
+		dst = appendProtoBytesAttr(dst, extStampTime, protoFieldAttrString, g.stampBuf)
+	}
+
+	data := g.eventData(p)
+
+	if p.dataIsJSON {
+		dst = appendProtoBytes(dst, protoFieldTextData, data)
+	} else {
+		dst = appendProtoBytes(dst, protoFieldBinaryData, data)
+	}
+
+	return dst
+}
+
+// appendProtoBody appends the body of a structured or batched request, in the
+// protobuf format, to dst and returns the extended buffer.
+func (g *CloudEventTargetsGenerator) appendProtoBody(dst []byte) []byte {
+	if g.mode != modeBatch {
+		return g.appendProtoEvent(dst)
+	}
+
+	for i := 0; i < g.batchSize; i++ {
+		// the size of an event is only known after it has been encoded
+		g.protoEventBuf = g.appendProtoEvent(g.protoEventBuf[:0])
+		dst = appendProtoBytes(dst, protoFieldBatchEvents, g.protoEventBuf)
+	}
+
+	return dst
+}
+
+// appendProtoStringAttr appends an entry of the attributes map of a
+// CloudEvent message, with a string value of the given type, to dst and
+// returns the extended buffer.
+func appendProtoStringAttr(dst []byte, name string, valField int, val string) []byte {
+	dst = appendProtoAttrHeader(dst, name, valField, len(val))
+	return append(dst, val...)
+}
+
+// appendProtoBytesAttr is like appendProtoStringAttr, but takes the value as
+// a byte slice.
+func appendProtoBytesAttr(dst []byte, name string, valField int, val []byte) []byte {
+	dst = appendProtoAttrHeader(dst, name, valField, len(val))
+	return append(dst, val...)
+}
+
+// appendProtoTimestampAttr appends an entry of the attributes map of a
+// CloudEvent message, with a timestamp value, to dst and returns the extended
+// buffer.
+func appendProtoTimestampAttr(dst []byte, name string, t time.Time) []byte {
+	secs, nanos := uint64(t.Unix()), uint64(t.Nanosecond())
+
+	// fields with a zero value are omitted
+	var tsSize int
+	if secs != 0 {
+		tsSize += protoTagSize(protoFieldTimestampSeconds) + varintSize(secs)
+	}
+	if nanos != 0 {
+		tsSize += protoTagSize(protoFieldTimestampNanos) + varintSize(nanos)
+	}
+
+	dst = appendProtoAttrHeader(dst, name, protoFieldAttrTimestamp, tsSize)
+
+	if secs != 0 {
+		dst = appendProtoTag(dst, protoFieldTimestampSeconds, protoWireVarint)
+		dst = appendVarint(dst, secs)
+	}
+	if nanos != 0 {
+		dst = appendProtoTag(dst, protoFieldTimestampNanos, protoWireVarint)
+		dst = appendVarint(dst, nanos)
+	}
+
+	return dst
+}
+
+// appendProtoAttrHeader appends an entry of the attributes map of a
+// CloudEvent message to dst, up to the encoded value of the attribute, which
+// has the given field number in CloudEventAttributeValue and the given size.
+// The caller is responsible for appending the value itself.
+func appendProtoAttrHeader(dst []byte, name string, valField, valSize int) []byte {
+	attrValSize := protoBytesSize(valField, valSize)
+	entrySize := protoBytesSize(protoFieldMapKey, len(name)) + protoBytesSize(protoFieldMapValue, attrValSize)
+
+	dst = appendProtoTag(dst, protoFieldAttributes, protoWireBytes)
+	dst = appendVarint(dst, uint64(entrySize))
+	dst = appendProtoString(dst, protoFieldMapKey, name)
+	dst = appendProtoTag(dst, protoFieldMapValue, protoWireBytes)
+	dst = appendVarint(dst, uint64(attrValSize))
+	dst = appendProtoTag(dst, valField, protoWireBytes)
+	return appendVarint(dst, uint64(valSize))
+}
+
+// appendProtoString appends a length-delimited field to dst and returns the
+// extended buffer.
+func appendProtoString(dst []byte, field int, s string) []byte {
+	dst = appendProtoTag(dst, field, protoWireBytes)
+	dst = appendVarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// appendProtoBytes appends a length-delimited field to dst and returns the
+// extended buffer.
+func appendProtoBytes(dst []byte, field int, b []byte) []byte {
+	dst = appendProtoTag(dst, field, protoWireBytes)
+	dst = appendVarint(dst, uint64(len(b)))
+	return append(dst, b...)
+}
+
+// appendProtoTag appends the key of a field to dst and returns the extended
+// buffer.
+func appendProtoTag(dst []byte, field, wireType int) []byte {
+	return appendVarint(dst, uint64(field<<3|wireType))
+}
+
+// appendVarint appends v in the base 128 varint encoding to dst and returns
+// the extended buffer.
+func appendVarint(dst []byte, v uint64) []byte {
+	for v >= 0x80 {
+		dst = append(dst, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+// protoBytesSize returns the encoded size of a length-delimited field with a
+// value of the given length.
+func protoBytesSize(field, length int) int {
+	return protoTagSize(field) + varintSize(uint64(length)) + length
+}
+
+// protoTagSize returns the encoded size of the key of a field.
+func protoTagSize(field int) int {
+	return varintSize(uint64(field << 3))
+}
+
+// varintSize returns the size of v in the base 128 varint encoding.
+func varintSize(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mailru/easyjson/jlexer"
+)
+
+func TestProtobufFormat(t *testing.T) {
+	profiles := []*EventProfile{{
+		Type:       "test.event",
+		Source:     "cegen/go/test",
+		Extensions: map[string]string{"region": "eu"},
+		Subject:    "test-subject",
+		DataSchema: "http://schema",
+		Data:       []byte(`{"msg":"hello"}`),
+	}}
+
+	const timeAttr = "2020-01-01T00:00:00.5Z"
+
+	opts := []GeneratorOption{
+		WithProtobufFormat(),
+		WithTimeAttribute(timeAttr),
+		WithStamping(),
+	}
+
+	t.Run("structured", func(t *testing.T) {
+		g := NewWeightedCloudEventTargetsGenerator("http://localhost", profiles,
+			append(opts, WithStructuredMode())...)
+
+		body := generateProtoBody(t, g, contentTypeCEProtobuf)
+
+		e := decodeTestProtoEvent(t, body)
+
+		if e.id == "" {
+			t.Error("Event ID isn't set")
+		}
+		if e.specVersion != "1.0" {
+			t.Errorf("Unexpected spec_version %q", e.specVersion)
+		}
+		if e.typ != "test.event" || e.source != "cegen/go/test" {
+			t.Errorf("Unexpected type and source %q, %q", e.typ, e.source)
+		}
+
+		expectAttrs := map[string]string{
+			"datacontenttype": contentTypeJSON,
+			"subject":         "test-subject",
+			"dataschema":      "http://schema",
+			"region":          "eu",
+			extStampSeq:       "1",
+		}
+		for k, v := range expectAttrs {
+			if e.attrs[k] != v {
+				t.Errorf("Expected attribute %s to be %q, got %q", k, v, e.attrs[k])
+			}
+		}
+
+		if _, err := time.Parse(time.RFC3339Nano, e.attrs[extStampTime]); err != nil {
+			t.Errorf("Invalid %s attribute: %s", extStampTime, err)
+		}
+
+		expectTime, _ := time.Parse(time.RFC3339, timeAttr)
+		if !e.time.Equal(expectTime) {
+			t.Errorf("Expected time %s, got %s", expectTime, e.time)
+		}
+
+		// JSON data is carried as text
+		if e.textData != `{"msg":"hello"}` || e.binaryData != nil {
+			t.Errorf("Unexpected event data %q, %q", e.textData, e.binaryData)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		g := NewWeightedCloudEventTargetsGenerator("http://localhost", profiles,
+			append(opts, WithBatchMode(3))...)
+
+		body := generateProtoBody(t, g, contentTypeCEBatchProtobuf)
+
+		fields := decodeTestProtoFields(t, body)
+
+		events := fields[protoFieldBatchEvents]
+		if l := len(events); l != 3 {
+			t.Fatalf("Expected 3 events in batch, got %d", l)
+		}
+
+		ids := make(map[string]struct{}, len(events))
+		for i, b := range events {
+			e := decodeTestProtoEvent(t, b.([]byte))
+			ids[e.id] = struct{}{}
+
+			if seq := e.attrs[extStampSeq]; seq != strconv.Itoa(i+1) {
+				t.Errorf("Expected sequence %d, got %s", i+1, seq)
+			}
+		}
+		if len(ids) != len(events) {
+			t.Error("Expected unique event IDs in batch")
+		}
+	})
+
+	t.Run("binary data", func(t *testing.T) {
+		g := NewWeightedCloudEventTargetsGenerator("http://localhost", []*EventProfile{{
+			Type:            "test.event",
+			Source:          "cegen/go/test",
+			DataContentType: "text/plain",
+			Data:            []byte("hello"),
+		}}, WithProtobufFormat(), WithStructuredMode())
+
+		e := decodeTestProtoEvent(t, generateProtoBody(t, g, contentTypeCEProtobuf))
+
+		if string(e.binaryData) != "hello" || e.textData != "" {
+			t.Errorf("Unexpected event data %q, %q", e.textData, e.binaryData)
+		}
+	})
+}
+
+// generateProtoBody generates a target using the given generator and returns
+// its body, after asserting its content type.
+func generateProtoBody(t *testing.T, g *CloudEventTargetsGenerator, expectCType string) []byte {
+	t.Helper()
+
+	trg, err := g.Generate()
+	if err != nil {
+		t.Fatal("Generate returned an error:", err)
+	}
+
+	var decTrg jsonTarget
+	decTrg.decode(&jlexer.Lexer{Data: trg})
+
+	if ct := decTrg.Header.Get("Content-Type"); ct != expectCType {
+		t.Errorf("Expected Content-Type %q, got %q", expectCType, ct)
+	}
+
+	return decTrg.Body
+}
+
+// testProtoEvent is a decoded CloudEvent protobuf message. Attributes which
+// aren't timestamps are decoded as strings.
+type testProtoEvent struct {
+	id          string
+	source      string
+	specVersion string
+	typ         string
+	attrs       map[string]string
+	time        time.Time
+	textData    string
+	binaryData  []byte
+}
+
+// decodeTestProtoEvent decodes a CloudEvent protobuf message.
+func decodeTestProtoEvent(t *testing.T, b []byte) *testProtoEvent {
+	t.Helper()
+
+	fields := decodeTestProtoFields(t, b)
+
+	str := func(field int) string {
+		if vs := fields[field]; len(vs) > 0 {
+			return string(vs[0].([]byte))
+		}
+		return ""
+	}
+
+	e := &testProtoEvent{
+		id:          str(protoFieldID),
+		source:      str(protoFieldSource),
+		specVersion: str(protoFieldSpecVersion),
+		typ:         str(protoFieldType),
+		attrs:       make(map[string]string),
+		textData:    str(protoFieldTextData),
+	}
+	if vs := fields[protoFieldBinaryData]; len(vs) > 0 {
+		e.binaryData = vs[0].([]byte)
+	}
+
+	for _, entry := range fields[protoFieldAttributes] {
+		entryFields := decodeTestProtoFields(t, entry.([]byte))
+		name := string(entryFields[protoFieldMapKey][0].([]byte))
+		val := decodeTestProtoFields(t, entryFields[protoFieldMapValue][0].([]byte))
+
+		if ts, ok := val[protoFieldAttrTimestamp]; ok {
+			tsFields := decodeTestProtoFields(t, ts[0].([]byte))
+
+			var secs, nanos uint64
+			if vs := tsFields[protoFieldTimestampSeconds]; len(vs) > 0 {
+				secs = vs[0].(uint64)
+			}
+			if vs := tsFields[protoFieldTimestampNanos]; len(vs) > 0 {
+				nanos = vs[0].(uint64)
+			}
+			e.time = time.Unix(int64(secs), int64(nanos))
+			continue
+		}
+
+		for _, vs := range val {
+			e.attrs[name] = string(vs[0].([]byte))
+		}
+	}
+
+	return e
+}
+
+// decodeTestProtoFields decodes the fields of a protobuf message, indexed by
+// field number. Values are either a uint64 or a []byte, depending on their
+// wire type.
+func decodeTestProtoFields(t *testing.T, b []byte) map[int][]interface{} {
+	t.Helper()
+
+	fields := make(map[int][]interface{})
+
+	varint := func() uint64 {
+		var v uint64
+		for shift := uint(0); ; shift += 7 {
+			if len(b) == 0 {
+				t.Fatal("Truncated varint")
+			}
+			c := b[0]
+			b = b[1:]
+			v |= uint64(c&0x7f) << shift
+			if c < 0x80 {
+				return v
+			}
+		}
+	}
+
+	for len(b) > 0 {
+		key := varint()
+		field, wireType := int(key>>3), int(key&7)
+
+		switch wireType {
+		case protoWireVarint:
+			fields[field] = append(fields[field], varint())
+		case protoWireBytes:
+			l := int(varint())
+			if l > len(b) {
+				t.Fatalf("Truncated field %d", field)
+			}
+			fields[field] = append(fields[field], b[:l])
+			b = b[l:]
+		default:
+			t.Fatalf("Unexpected wire type %d", wireType)
+		}
+	}
+
+	return fields
+}
+
+func BenchmarkGenerateProtobuf(b *testing.B) {
+	const (
+		url = "http://localhost"
+		typ = "test.event"
+		src = "cegen/go/benchmark"
+	)
+
+	data := make([]byte, 2048)
+
+	g := NewCloudEventTargetsGenerator(url, typ, src, data, WithStructuredMode(), WithProtobufFormat())
+
+	for i := 0; i < b.N; i++ {
+		if _, err := g.Generate(); err != nil {
+			b.Error("Generate returned an error:", err)
+		}
+	}
+}
@@ -0,0 +1,547 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwriter "github.com/mailru/easyjson/jwriter"
+	uuid "github.com/rogpeppe/fastuuid"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Markers of the event-display log format, which is the output of the String()
+// method of events from the CloudEvents Go SDK.
+// https://github.com/cloudevents/sdk-go/blob/v2.3.1/v2/event/event.go#L87
+const (
+	eventDisplayStart      = "cloudevents.Event"
+	eventDisplayAttributes = "Context Attributes,"
+	eventDisplayExtensions = "Extensions,"
+	eventDisplayData       = "Data,"
+)
+
+// Maximum size of a line in NDJSON captures.
+const maxCaptureLineSize = 16 << 20
+
+// capturedEvent is a CloudEvent read from a capture.
+type capturedEvent struct {
+	// context attributes and extensions, indexed by name
+	attrs map[string]string
+	data  []byte
+	// whether data is a valid JSON value which can be embedded as is in
+	// structured events
+	dataIsJSON bool
+	// time at which the event was originally emitted, zero if unknown
+	time time.Time
+}
+
+// readCaptureFile reads captured CloudEvents from the file at the given path,
+// or from stdin if the path is "-".
+func readCaptureFile(path string) ([]*capturedEvent, error) {
+	if path == "-" {
+		return readCapture(os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening capture file: %w", err)
+	}
+	defer f.Close()
+
+	return readCapture(f)
+}
+
+// readCapture reads captured CloudEvents either in the JSON event format, one
+// event per line, or in the event-display log format. The format is detected
+// from the first non-blank character of the capture.
+func readCapture(r io.Reader) ([]*capturedEvent, error) {
+	br := bufio.NewReader(r)
+
+	var first byte
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return nil, fmt.Errorf("capture doesn't contain any event")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading capture: %w", err)
+		}
+		if !isSpace(b) {
+			first = b
+			_ = br.UnreadByte()
+			break
+		}
+	}
+
+	var events []*capturedEvent
+	var err error
+
+	if first == '{' {
+		events, err = readNDJSONCapture(br)
+	} else {
+		events, err = readEventDisplayCapture(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, fmt.Errorf("capture doesn't contain any event")
+	}
+
+	return events, nil
+}
+
+// readNDJSONCapture reads CloudEvents in the JSON event format, one event per
+// line.
+func readNDJSONCapture(r io.Reader) ([]*capturedEvent, error) {
+	var events []*capturedEvent
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxCaptureLineSize)
+
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		e, err := parseStructuredEvent(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		events = append(events, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading capture: %w", err)
+	}
+
+	return events, nil
+}
+
+// parseStructuredEvent parses a CloudEvent in the JSON event format.
+func parseStructuredEvent(b []byte) (*capturedEvent, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("parsing event: %w", err)
+	}
+
+	e := &capturedEvent{
+		attrs: make(map[string]string, len(fields)),
+	}
+
+	for name, val := range fields {
+		switch name {
+		case "data", "data_base64":
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(val, &s); err != nil {
+			// extensions may have non-string JSON types
+			s = string(val)
+		}
+		e.attrs[name] = s
+	}
+
+	if data, ok := fields["data_base64"]; ok {
+		if err := json.Unmarshal(data, &e.data); err != nil {
+			return nil, fmt.Errorf("decoding data_base64: %w", err)
+		}
+	} else if data, ok := fields["data"]; ok {
+		var s string
+		if !isJSONMediaType(e.contentType()) && json.Unmarshal(data, &s) == nil {
+			// text data, carried as a JSON string
+			e.data = []byte(s)
+		} else {
+			e.data = data
+			e.dataIsJSON = true
+		}
+	}
+
+	if err := e.init(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// readEventDisplayCapture reads CloudEvents in the event-display log format.
+// Lines which precede the first event are ignored.
+func readEventDisplayCapture(r io.Reader) ([]*capturedEvent, error) {
+	var events []*capturedEvent
+
+	var e *capturedEvent
+	var section string
+	var data []string
+
+	// finish completes the current event, if any.
+	finish := func() error {
+		if e == nil {
+			return nil
+		}
+
+		e.data = []byte(strings.TrimRight(strings.Join(data, "\n"), " \t\n"))
+
+		// JSON data is indented in event-display logs
+		var compacted bytes.Buffer
+		if isJSONMediaType(e.contentType()) && json.Compact(&compacted, e.data) == nil {
+			e.data = compacted.Bytes()
+			e.dataIsJSON = true
+		}
+
+		if err := e.init(); err != nil {
+			return fmt.Errorf("event %d: %w", len(events)+1, err)
+		}
+		events = append(events, e)
+
+		e, section, data = nil, "", nil
+		return nil
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxCaptureLineSize)
+
+	for s.Scan() {
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.Contains(line, eventDisplayStart):
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			e = &capturedEvent{attrs: make(map[string]string)}
+			continue
+
+		case e == nil:
+			continue
+
+		case trimmed == eventDisplayAttributes, trimmed == eventDisplayExtensions, trimmed == eventDisplayData:
+			section = trimmed
+			continue
+		}
+
+		switch section {
+		case eventDisplayAttributes, eventDisplayExtensions:
+			i := strings.Index(trimmed, ": ")
+			if i < 1 {
+				continue
+			}
+			e.attrs[trimmed[:i]] = trimmed[i+2:]
+
+		case eventDisplayData:
+			data = append(data, strings.TrimPrefix(line, "  "))
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading capture: %w", err)
+	}
+
+	if err := finish(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// init validates the event's attributes and determines the time at which it
+// was originally emitted from either its time attribute or, as a fallback,
+// the send time of stamped events.
+func (e *capturedEvent) init() error {
+	for _, name := range []string{"type", "source"} {
+		if e.attrs[name] == "" {
+			return fmt.Errorf("missing attribute %q", name)
+		}
+	}
+
+	for _, name := range []string{"time", extStampTime} {
+		if v := e.attrs[name]; v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return fmt.Errorf("invalid %s attribute: %w", name, err)
+			}
+			e.time = t
+			break
+		}
+	}
+
+	return nil
+}
+
+// contentType returns the content type of the event's data, which defaults to
+// JSON when the event doesn't have a datacontenttype attribute.
+func (e *capturedEvent) contentType() string {
+	if ct := e.attrs["datacontenttype"]; ct != "" {
+		return ct
+	}
+	return contentTypeJSON
+}
+
+// replayer yields targets containing captured CloudEvents, and determines
+// when each target should be sent to preserve the gaps between the original
+// events.
+type replayer struct {
+	targetURL string
+	mode      string
+	headers   http.Header
+
+	events []*capturedEvent
+	// time at which each event should be sent, relative to the start of
+	// the replay
+	offsets []time.Duration
+
+	// generator of IDs used to rewrite the IDs of events, nil if IDs are
+	// preserved
+	uuidGen idGenerator
+}
+
+// ReplayerOption is a functional option for a replayer.
+type ReplayerOption func(*replayer)
+
+// WithReplayStructuredMode sets the replayer to yield events in structured
+// content mode.
+func WithReplayStructuredMode() ReplayerOption {
+	return func(r *replayer) {
+		r.mode = modeStructured
+	}
+}
+
+// WithReplayHeaders sets the replayer to add the given HTTP headers to each
+// target.
+func WithReplayHeaders(h http.Header) ReplayerOption {
+	return func(r *replayer) {
+		r.headers = h
+	}
+}
+
+// WithIDRewriting sets the replayer to replace the ID of each event with a
+// unique ID. When seed is not 0, IDs are derived from it.
+func WithIDRewriting(seed int64) ReplayerOption {
+	return func(r *replayer) {
+		if seed == 0 {
+			r.uuidGen = uuid.MustNewGenerator()
+			return
+		}
+		r.uuidGen = &seededIDGenerator{rand: rand.New(rand.NewSource(seed))}
+	}
+}
+
+// newReplayer returns a replayer which replays the given events to the given
+// URL, with gaps between events divided by the given speed factor.
+// Events which don't carry any time are sent together with the preceding
+// event. Events are never reordered, so events with a time that precedes
+// the time of an earlier event are also sent together with that event.
+func newReplayer(url string, events []*capturedEvent, speed float64, opts ...ReplayerOption) *replayer {
+	r := &replayer{
+		targetURL: url,
+		mode:      modeBinary,
+		events:    events,
+		offsets:   make([]time.Duration, len(events)),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	var start time.Time
+	var prev time.Duration
+
+	for i, e := range events {
+		if !e.time.IsZero() {
+			if start.IsZero() {
+				start = e.time
+			}
+			if off := time.Duration(float64(e.time.Sub(start)) / speed); off > prev {
+				prev = off
+			}
+		}
+		r.offsets[i] = prev
+	}
+
+	return r
+}
+
+// Targeter returns a vegeta.Targeter which yields the captured events in
+// order, and returns vegeta.ErrNoTargets once all events have been yielded.
+// The returned Targeter is safe for concurrent use.
+func (r *replayer) Targeter() vegeta.Targeter {
+	var mu sync.Mutex
+	var pos int
+
+	return func(tgt *vegeta.Target) error {
+		if tgt == nil {
+			return vegeta.ErrNilTarget
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if pos == len(r.events) {
+			return vegeta.ErrNoTargets
+		}
+		e := r.events[pos]
+		pos++
+
+		id := e.attrs["id"]
+		if r.uuidGen != nil || id == "" {
+			id = r.newID()
+		}
+
+		tgt.Method = http.MethodPost
+		tgt.URL = r.targetURL
+
+		if r.mode == modeStructured {
+			r.setStructuredEvent(tgt, e, id)
+		} else {
+			r.setBinaryEvent(tgt, e, id)
+		}
+
+		for k, v := range r.headers {
+			tgt.Header[k] = v
+		}
+
+		return nil
+	}
+}
+
+// newID returns a unique event ID.
+func (r *replayer) newID() string {
+	if r.uuidGen == nil {
+		r.uuidGen = uuid.MustNewGenerator()
+	}
+	return r.uuidGen.Hex128()
+}
+
+// setBinaryEvent sets the HTTP headers and body of the given target to the
+// given event in binary content mode.
+func (r *replayer) setBinaryEvent(tgt *vegeta.Target, e *capturedEvent, id string) {
+	contentType := e.attrs["datacontenttype"]
+	if contentType == "" {
+		contentType = contentTypeOctetStream
+		if e.dataIsJSON {
+			contentType = contentTypeJSON
+		}
+	}
+
+	tgt.Header = http.Header{
+		"Ce-Specversion": []string{"1.0"},
+		"Content-Type":   []string{contentType},
+	}
+
+	for name, val := range e.attrs {
+		if name == "datacontenttype" {
+			continue
+		}
+		tgt.Header[http.CanonicalHeaderKey("Ce-"+name)] = []string{val}
+	}
+	tgt.Header["Ce-Id"] = []string{id}
+
+	tgt.Body = e.data
+}
+
+// setStructuredEvent sets the HTTP headers and body of the given target to
+// the given event in structured content mode.
+func (r *replayer) setStructuredEvent(tgt *vegeta.Target, e *capturedEvent, id string) {
+	tgt.Header = http.Header{
+		"Content-Type": []string{contentTypeCEJSON},
+	}
+
+	names := make([]string, 0, len(e.attrs))
+	for name := range e.attrs {
+		if name != "id" && name != "specversion" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var out jwriter.Writer
+
+	out.RawString(`{"specversion":"1.0","id":`)
+	out.String(id)
+
+	for _, name := range names {
+		out.RawByte(',')
+		out.String(name)
+		out.RawByte(':')
+		out.String(e.attrs[name])
+	}
+
+	if len(e.data) > 0 {
+		if e.dataIsJSON {
+			out.RawString(`,"data":`)
+			out.Raw(e.data, nil)
+		} else {
+			out.RawString(`,"data_base64":`)
+			out.Base64Bytes(e.data)
+		}
+	}
+
+	out.RawByte('}')
+
+	tgt.Body, _ = out.BuildBytes()
+}
+
+// Pacer returns a vegeta.Pacer which paces the hits of an attack according to
+// the time at which each captured event should be sent, and stops the attack
+// after the last event.
+func (r *replayer) Pacer() vegeta.Pacer {
+	return replayPacer(r.offsets)
+}
+
+// replayPacer is a vegeta.Pacer which sends each hit at a predefined time
+// since the start of the attack.
+type replayPacer []time.Duration
+
+// replayPacer implements vegeta.Pacer.
+var _ vegeta.Pacer = (replayPacer)(nil)
+
+// Pace implements vegeta.Pacer.
+func (p replayPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	if hits >= uint64(len(p)) {
+		return 0, true
+	}
+
+	if wait := p[hits] - elapsed; wait > 0 {
+		return wait, false
+	}
+	return 0, false
+}
+
+// Rate implements vegeta.Pacer.
+func (p replayPacer) Rate(elapsed time.Duration) float64 {
+	// number of hits scheduled within the second that follows elapsed
+	from := sort.Search(len(p), func(i int) bool { return p[i] >= elapsed })
+	to := sort.Search(len(p), func(i int) bool { return p[i] >= elapsed+time.Second })
+	return float64(to - from)
+}
+
+// isSpace returns whether b is an ASCII whitespace character.
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
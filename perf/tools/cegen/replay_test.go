@@ -0,0 +1,431 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+const testNDJSONCapture = `
+{"specversion":"1.0","id":"e1","type":"t.a","source":"s","time":"2020-01-01T00:00:00Z","data":{"n":1}}
+{"specversion":"1.0","id":"e2","type":"t.a","source":"s","time":"2020-01-01T00:00:00.5Z","region":"eu","datacontenttype":"text/plain","data":"hello"}
+
+{"specversion":"1.0","id":"e3","type":"t.b","source":"s","sendtime":"2020-01-01T00:00:02Z","data_base64":"AAEC"}
+`
+
+const testEventDisplayCapture = `2020/01/01 00:00:00 Starting event display
+☁️  cloudevents.Event
+Validation: valid
+Context Attributes,
+  specversion: 1.0
+  type: dev.knative.sources.ping
+  source: /apis/v1/namespaces/default/pingsources/test
+  id: 96d85307
+  time: 2020-04-22T22:34:00Z
+  datacontenttype: application/json
+Extensions,
+  region: eu
+Data,
+  {
+    "message": "Hello world!"
+  }
+☁️  cloudevents.Event
+Validation: valid
+Context Attributes,
+  specversion: 1.0
+  type: dev.knative.sources.ping
+  source: /apis/v1/namespaces/default/pingsources/test
+  id: 96d85308
+  datacontenttype: text/plain
+Data,
+  plain text
+`
+
+func TestReadCapture(t *testing.T) {
+	t.Run("NDJSON", func(t *testing.T) {
+		events, err := readCapture(strings.NewReader(testNDJSONCapture))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		expect := []*capturedEvent{{
+			attrs: map[string]string{
+				"specversion": "1.0", "id": "e1", "type": "t.a", "source": "s",
+				"time": "2020-01-01T00:00:00Z",
+			},
+			data:       []byte(`{"n":1}`),
+			dataIsJSON: true,
+			time:       time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		}, {
+			attrs: map[string]string{
+				"specversion": "1.0", "id": "e2", "type": "t.a", "source": "s",
+				"time": "2020-01-01T00:00:00.5Z", "region": "eu", "datacontenttype": "text/plain",
+			},
+			data: []byte("hello"),
+			time: time.Date(2020, 1, 1, 0, 0, 0, 500*int(time.Millisecond), time.UTC),
+		}, {
+			attrs: map[string]string{
+				"specversion": "1.0", "id": "e3", "type": "t.b", "source": "s",
+				"sendtime": "2020-01-01T00:00:02Z",
+			},
+			data: []byte{0, 1, 2},
+			time: time.Date(2020, 1, 1, 0, 0, 2, 0, time.UTC),
+		}}
+
+		assertCapturedEvents(t, events, expect)
+	})
+
+	t.Run("event-display", func(t *testing.T) {
+		events, err := readCapture(strings.NewReader(testEventDisplayCapture))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		expect := []*capturedEvent{{
+			attrs: map[string]string{
+				"specversion": "1.0", "id": "96d85307", "type": "dev.knative.sources.ping",
+				"source": "/apis/v1/namespaces/default/pingsources/test", "time": "2020-04-22T22:34:00Z",
+				"datacontenttype": "application/json", "region": "eu",
+			},
+			data:       []byte(`{"message":"Hello world!"}`),
+			dataIsJSON: true,
+			time:       time.Date(2020, 4, 22, 22, 34, 0, 0, time.UTC),
+		}, {
+			attrs: map[string]string{
+				"specversion": "1.0", "id": "96d85308", "type": "dev.knative.sources.ping",
+				"source": "/apis/v1/namespaces/default/pingsources/test", "datacontenttype": "text/plain",
+			},
+			data: []byte("plain text"),
+		}}
+
+		assertCapturedEvents(t, events, expect)
+	})
+
+	invalidCases := map[string]struct {
+		capture   string
+		expectErr string
+	}{
+		"empty capture": {
+			capture:   "\n\n",
+			expectErr: "doesn't contain any event",
+		},
+		"no event-display event": {
+			capture:   "some log line\n",
+			expectErr: "doesn't contain any event",
+		},
+		"invalid JSON": {
+			capture:   `{"type":`,
+			expectErr: "line 1: parsing event",
+		},
+		"missing source": {
+			capture:   `{"type":"t.a"}`,
+			expectErr: `missing attribute "source"`,
+		},
+		"invalid time": {
+			capture:   `{"type":"t.a","source":"s","time":"yesterday"}`,
+			expectErr: "invalid time attribute",
+		},
+	}
+
+	for name, tc := range invalidCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			_, err := readCapture(strings.NewReader(tc.capture))
+			if err == nil {
+				t.Fatal("Expected reading to fail")
+			}
+			if errStr := err.Error(); !strings.Contains(errStr, tc.expectErr) {
+				t.Fatalf("Unexpected error message: %q", errStr)
+			}
+		})
+	}
+}
+
+// assertCapturedEvents asserts that the given captured events match the
+// expected ones.
+func assertCapturedEvents(t *testing.T, events, expect []*capturedEvent) {
+	t.Helper()
+
+	if len(events) != len(expect) {
+		t.Fatalf("Expected %d events, got %d", len(expect), len(events))
+	}
+
+	for i := range events {
+		if !reflect.DeepEqual(events[i], expect[i]) {
+			t.Errorf("Unexpected event at index %d:\n%+v\nexpected\n%+v", i, events[i], expect[i])
+		}
+	}
+}
+
+func TestReplayerOffsets(t *testing.T) {
+	at := func(sec float64) time.Time {
+		return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(sec * float64(time.Second)))
+	}
+
+	events := []*capturedEvent{
+		{}, // no time
+		{time: at(10)},
+		{time: at(11)},
+		{},               // no time
+		{time: at(10.5)}, // out of order
+		{time: at(14)},
+	}
+
+	rp := newReplayer("http://localhost", events, 2)
+
+	expect := []time.Duration{
+		0,
+		0,
+		500 * time.Millisecond,
+		500 * time.Millisecond,
+		500 * time.Millisecond,
+		2 * time.Second,
+	}
+
+	if !reflect.DeepEqual(rp.offsets, expect) {
+		t.Errorf("Expected offsets %v, got %v", expect, rp.offsets)
+	}
+}
+
+func TestReplayerTargets(t *testing.T) {
+	events, err := readCapture(strings.NewReader(testNDJSONCapture))
+	if err != nil {
+		t.Fatal("Error reading capture:", err)
+	}
+
+	// replay returns the targets yielded by a replayer created with the
+	// given options.
+	replay := func(t *testing.T, opts ...ReplayerOption) []*vegeta.Target {
+		t.Helper()
+
+		next := newReplayer("http://localhost", events, 1, opts...).Targeter()
+
+		var trgs []*vegeta.Target
+		for {
+			var trg vegeta.Target
+			err := next(&trg)
+			if err == vegeta.ErrNoTargets {
+				break
+			}
+			if err != nil {
+				t.Fatal("Error generating target:", err)
+			}
+			trgs = append(trgs, &trg)
+		}
+
+		if len(trgs) != len(events) {
+			t.Fatalf("Expected %d targets, got %d", len(events), len(trgs))
+		}
+		return trgs
+	}
+
+	t.Run("binary", func(t *testing.T) {
+		trgs := replay(t, WithReplayHeaders(http.Header{"Authorization": []string{"Bearer abc"}}))
+
+		expectHeaders := map[string]string{
+			"Ce-Id":         "e2",
+			"Ce-Type":       "t.a",
+			"Ce-Region":     "eu",
+			"Ce-Time":       "2020-01-01T00:00:00.5Z",
+			"Content-Type":  "text/plain",
+			"Authorization": "Bearer abc",
+		}
+		for k, v := range expectHeaders {
+			if hv := trgs[1].Header.Get(k); hv != v {
+				t.Errorf("Expected header %s to be %q, got %q", k, v, hv)
+			}
+		}
+		if body := string(trgs[1].Body); body != "hello" {
+			t.Errorf("Unexpected body %q", body)
+		}
+
+		if ct := trgs[2].Header.Get("Content-Type"); ct != contentTypeOctetStream {
+			t.Errorf("Expected binary data to have content type %q, got %q", contentTypeOctetStream, ct)
+		}
+	})
+
+	t.Run("structured", func(t *testing.T) {
+		trgs := replay(t, WithReplayStructuredMode())
+
+		for i, trg := range trgs {
+			e, err := parseStructuredEvent(trg.Body)
+			if err != nil {
+				t.Fatalf("Body of target %d isn't a valid event: %s\n%s", i, err, trg.Body)
+			}
+
+			if !reflect.DeepEqual(e, events[i]) {
+				t.Errorf("Unexpected event at index %d:\n%+v\nexpected\n%+v", i, e, events[i])
+			}
+		}
+	})
+
+	t.Run("rewritten IDs", func(t *testing.T) {
+		trgs1 := replay(t, WithIDRewriting(42))
+		trgs2 := replay(t, WithIDRewriting(42))
+
+		ids := make(map[string]struct{})
+		for i := range trgs1 {
+			id := trgs1[i].Header.Get("Ce-Id")
+			if id == events[i].attrs["id"] {
+				t.Errorf("Expected ID of event %d to be rewritten", i)
+			}
+			if id2 := trgs2[i].Header.Get("Ce-Id"); id2 != id {
+				t.Errorf("Expected the same seed to yield the same IDs, got %s and %s", id, id2)
+			}
+			ids[id] = struct{}{}
+		}
+
+		if len(ids) != len(events) {
+			t.Errorf("Expected unique IDs, got %v", ids)
+		}
+	})
+}
+
+func TestReplayPacer(t *testing.T) {
+	p := replayPacer{0, 0, time.Second, 3 * time.Second}
+
+	testCases := []struct {
+		name       string
+		elapsed    time.Duration
+		hits       uint64
+		expectWait time.Duration
+		expectStop bool
+	}{
+		{
+			name:       "first hit",
+			elapsed:    0,
+			hits:       0,
+			expectWait: 0,
+		},
+		{
+			name:       "ahead",
+			elapsed:    200 * time.Millisecond,
+			hits:       2,
+			expectWait: 800 * time.Millisecond,
+		},
+		{
+			name:       "behind",
+			elapsed:    2 * time.Second,
+			hits:       2,
+			expectWait: 0,
+		},
+		{
+			name:       "last event sent",
+			elapsed:    4 * time.Second,
+			hits:       4,
+			expectStop: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wait, stop := p.Pace(tc.elapsed, tc.hits)
+
+			if stop != tc.expectStop {
+				t.Fatalf("Expected stop to be %t", tc.expectStop)
+			}
+			if wait != tc.expectWait {
+				t.Errorf("Expected wait %s, got %s", tc.expectWait, wait)
+			}
+		})
+	}
+
+	if r := p.Rate(0); r != 2 {
+		t.Errorf("Expected rate of 2 hits/s at start, got %v", r)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	capturePath := filepath.Join(t.TempDir(), "capture.ndjson")
+	if err := ioutil.WriteFile(capturePath, []byte(testNDJSONCapture), 0644); err != nil {
+		t.Fatal("Error writing capture file:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*testTimeout)
+	defer cancel()
+
+	t.Run("pipe", func(t *testing.T) {
+		var stdout strings.Builder
+		var stderr strings.Builder
+
+		start := time.Now()
+
+		err := run(ctx, []string{tCmd, "-u=http://target", "-replay=" + capturePath, "-speed=10",
+			"-format=ndjson"}, &stdout, &stderr)
+		if err != nil {
+			t.Fatal("Unexpected runtime error:", err)
+		}
+
+		// the last event was emitted 2s after the first one
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Errorf("Expected replay to take at least 200ms, took %s", elapsed)
+		}
+
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Expected 3 events, got %d:\n%s", len(lines), stdout.String())
+		}
+
+		var e struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal([]byte(lines[2]), &e); err != nil || e.ID != "e3" {
+			t.Errorf("Expected last event to have ID e3, got %s", lines[2])
+		}
+	})
+
+	t.Run("attack", func(t *testing.T) {
+		var mu sync.Mutex
+		var received []string
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			received = append(received, r.Header.Get("Ce-Id"))
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer srv.Close()
+
+		var stdout strings.Builder
+		var stderr strings.Builder
+
+		err := run(ctx, []string{tCmd, "-u=" + srv.URL, "-replay=" + capturePath, "-speed=10", "-attack",
+			"-n=2"}, &stdout, &stderr)
+		if err != nil {
+			t.Fatal("Unexpected runtime error:", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if expect := []string{"e1", "e2"}; !reflect.DeepEqual(received, expect) {
+			t.Errorf("Expected events %v to be received, got %v", expect, received)
+		}
+	})
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Shapes of size distributions.
+const (
+	sizeFixed   = "fixed"   // fixed:size=<n>
+	sizeUniform = "uniform" // uniform:min=<n>,max=<n>
+	sizeNormal  = "normal"  // normal:mean=<n>,stddev=<n>
+	sizeHist    = "hist"    // hist:file=<path>
+)
+
+// Synthetic payloads are JSON objects with a single string attribute, which
+// is padded to match the desired size.
+const (
+	syntheticDataPrefix = `{"data":"`
+	syntheticDataSuffix = `"}`
+
+	minSyntheticDataSize = len(syntheticDataPrefix) + len(syntheticDataSuffix)
+)
+
+// Number of standard deviations above the mean at which normally distributed
+// sizes are capped.
+const normalMaxStdDevs = 6
+
+// sizeDistribution is a distribution of payload sizes, in bytes.
+type sizeDistribution interface {
+	// next returns a random size drawn from the distribution.
+	next(r *rand.Rand) int
+	// max returns the maximum size which can be drawn from the distribution.
+	max() int
+}
+
+// parseSizeDistribution returns the sizeDistribution described by the given
+// specification.
+func parseSizeDistribution(spec string) (sizeDistribution, error) {
+	shape, params, err := parseSpec(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid size distribution: %w", err)
+	}
+
+	var d sizeDistribution
+
+	switch shape {
+	case sizeFixed:
+		d, err = fixedSizes(params)
+	case sizeUniform:
+		d, err = uniformSizes(params)
+	case sizeNormal:
+		d, err = normalSizes(params)
+	case sizeHist:
+		d, err = histogramSizes(params)
+	default:
+		return nil, fmt.Errorf("unknown size distribution %q", shape)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s size distribution: %w", shape, err)
+	}
+	if unused := params.unused(); len(unused) > 0 {
+		return nil, fmt.Errorf("invalid %s size distribution: unknown parameters %q", shape, unused)
+	}
+
+	return d, nil
+}
+
+// fixedSizeDistribution always yields the same size.
+type fixedSizeDistribution int
+
+func fixedSizes(params specParams) (sizeDistribution, error) {
+	size, err := params.positiveInt("size")
+	if err != nil {
+		return nil, err
+	}
+
+	return fixedSizeDistribution(size), nil
+}
+
+func (d fixedSizeDistribution) next(*rand.Rand) int { return int(d) }
+func (d fixedSizeDistribution) max() int            { return int(d) }
+
+// uniformSizeDistribution yields sizes uniformly distributed between a
+// minimum and a maximum (inclusive).
+type uniformSizeDistribution struct {
+	min, maxSize int
+}
+
+func uniformSizes(params specParams) (sizeDistribution, error) {
+	min, err := params.positiveInt("min")
+	if err != nil {
+		return nil, err
+	}
+	max, err := params.positiveInt("max")
+	if err != nil {
+		return nil, err
+	}
+	if max < min {
+		return nil, fmt.Errorf("the maximum size must be greater than or equal to the minimum size")
+	}
+
+	return &uniformSizeDistribution{min: int(min), maxSize: int(max)}, nil
+}
+
+func (d *uniformSizeDistribution) next(r *rand.Rand) int { return d.min + r.Intn(d.maxSize-d.min+1) }
+func (d *uniformSizeDistribution) max() int              { return d.maxSize }
+
+// normalSizeDistribution yields normally distributed sizes, capped at a
+// number of standard deviations above the mean.
+type normalSizeDistribution struct {
+	mean, stddev float64
+}
+
+func normalSizes(params specParams) (sizeDistribution, error) {
+	mean, err := params.positiveInt("mean")
+	if err != nil {
+		return nil, err
+	}
+	stddev, err := params.positiveInt("stddev")
+	if err != nil {
+		return nil, err
+	}
+
+	return &normalSizeDistribution{mean: float64(mean), stddev: float64(stddev)}, nil
+}
+
+func (d *normalSizeDistribution) next(r *rand.Rand) int {
+	s := int(math.Round(r.NormFloat64()*d.stddev + d.mean))
+	if s < 0 {
+		return 0
+	}
+	if max := d.max(); s > max {
+		return max
+	}
+	return s
+}
+
+func (d *normalSizeDistribution) max() int {
+	return int(d.mean + normalMaxStdDevs*d.stddev)
+}
+
+// histogramSizeDistribution yields sizes from an empirical histogram, in
+// proportion to the weight of each bucket.
+type histogramSizeDistribution struct {
+	sizes []int
+	// cumulative weights of buckets
+	cumWeights  []float64
+	totalWeight float64
+}
+
+func histogramSizes(params specParams) (sizeDistribution, error) {
+	path, err := params.get("file")
+	if err != nil {
+		return nil, err
+	}
+
+	return readHistogramFile(path)
+}
+
+// readHistogramFile reads a histogram of sizes from a file containing one
+// bucket per line, in the format "<size>,<weight>". Empty lines and lines
+// starting with '#' are ignored.
+func readHistogramFile(path string) (*histogramSizeDistribution, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening histogram file: %w", err)
+	}
+	defer f.Close()
+
+	d := &histogramSizeDistribution{}
+
+	s := bufio.NewScanner(f)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected <size>,<weight>, got %q", lineNum, line)
+		}
+
+		size, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid size %q", lineNum, fields[0])
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("line %d: invalid weight %q", lineNum, fields[1])
+		}
+		if weight == 0 {
+			continue
+		}
+
+		d.totalWeight += weight
+		d.sizes = append(d.sizes, int(size))
+		d.cumWeights = append(d.cumWeights, d.totalWeight)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading histogram file: %w", err)
+	}
+
+	if len(d.sizes) == 0 {
+		return nil, fmt.Errorf("histogram file doesn't contain any bucket with a positive weight")
+	}
+
+	return d, nil
+}
+
+func (d *histogramSizeDistribution) next(r *rand.Rand) int {
+	w := r.Float64() * d.totalWeight
+	return d.sizes[sort.SearchFloat64s(d.cumWeights, w)]
+}
+
+func (d *histogramSizeDistribution) max() int {
+	var max int
+	for _, s := range d.sizes {
+		if s > max {
+			max = s
+		}
+	}
+	return max
+}
+
+// syntheticData generates JSON payloads with sizes that follow a given
+// distribution.
+type syntheticData struct {
+	sizes sizeDistribution
+	// random characters used to pad payloads
+	padding []byte
+}
+
+// newSyntheticData returns a syntheticData which pads payloads with random
+// characters drawn from r.
+func newSyntheticData(sizes sizeDistribution, r *rand.Rand) *syntheticData {
+	padLen := sizes.max() - minSyntheticDataSize
+	if padLen < 0 {
+		padLen = 0
+	}
+
+	tc := &templateContext{rand: r}
+
+	return &syntheticData{
+		sizes:   sizes,
+		padding: renderRandFn(padLen)(make([]byte, 0, padLen), tc),
+	}
+}
+
+// render appends a payload of the given size to dst and returns the extended
+// buffer. Sizes smaller than the minimum size of a JSON payload are rounded up
+// to that minimum.
+func (s *syntheticData) render(dst []byte, size int) []byte {
+	padLen := size - minSyntheticDataSize
+	if padLen < 0 {
+		padLen = 0
+	}
+
+	dst = append(dst, syntheticDataPrefix...)
+	dst = append(dst, s.padding[:padLen]...)
+	return append(dst, syntheticDataSuffix...)
+}
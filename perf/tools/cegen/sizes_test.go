@@ -0,0 +1,184 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSizeDistribution(t *testing.T) {
+	testCases := []struct {
+		spec      string
+		expectMax int
+		expectErr string
+	}{
+		{
+			spec:      "fixed:size=1024",
+			expectMax: 1024,
+		},
+		{
+			spec:      "uniform:min=512,max=4096",
+			expectMax: 4096,
+		},
+		{
+			spec:      "normal:mean=1000,stddev=100",
+			expectMax: 1600,
+		},
+		{
+			spec:      "unknown:size=1",
+			expectErr: `unknown size distribution "unknown"`,
+		},
+		{
+			spec:      "fixed",
+			expectErr: `missing parameter "size"`,
+		},
+		{
+			spec:      "fixed:size=0",
+			expectErr: `parameter "size" must be a positive integer`,
+		},
+		{
+			spec:      "uniform:min=100,max=10",
+			expectErr: "maximum size must be greater than or equal to the minimum size",
+		},
+		{
+			spec:      "normal:mean=100,stddev=10,skew=1",
+			expectErr: `unknown parameters ["skew"]`,
+		},
+		{
+			spec:      "hist:file=/does/not/exist",
+			expectErr: "opening histogram file",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			d, err := parseSizeDistribution(tc.spec)
+
+			if tc.expectErr != "" {
+				if err == nil {
+					t.Fatal("Expected parsing to fail")
+				}
+				if errStr := err.Error(); !strings.Contains(errStr, tc.expectErr) {
+					t.Fatalf("Unexpected error message: %q", errStr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+
+			if max := d.max(); max != tc.expectMax {
+				t.Errorf("Expected max size %d, got %d", tc.expectMax, max)
+			}
+
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 1000; i++ {
+				if s := d.next(r); s < 0 || s > tc.expectMax {
+					t.Fatalf("Size %d is out of bounds", s)
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramSizes(t *testing.T) {
+	writeFile := func(t *testing.T, content string) string {
+		t.Helper()
+
+		path := filepath.Join(t.TempDir(), "hist.csv")
+		if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal("Error writing histogram file:", err)
+		}
+		return path
+	}
+
+	t.Run("valid histogram", func(t *testing.T) {
+		path := writeFile(t, "# size,weight\n100,3\n\n1000,1\n5000,0\n")
+
+		d, err := parseSizeDistribution("hist:file=" + path)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		if max := d.max(); max != 1000 {
+			t.Errorf("Expected max size 1000, got %d", max)
+		}
+
+		const numDraws = 10000
+
+		counts := make(map[int]int)
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < numDraws; i++ {
+			counts[d.next(r)]++
+		}
+
+		if len(counts) != 2 {
+			t.Fatalf("Expected sizes from 2 buckets, got %v", counts)
+		}
+		// 3:1 ratio, with some tolerance
+		if c := counts[100]; c < numDraws*70/100 || c > numDraws*80/100 {
+			t.Errorf("Unexpected proportion of size 100: %d/%d", c, numDraws)
+		}
+	})
+
+	invalidCases := map[string]string{
+		"missing weight":     "100\n",
+		"invalid size":       "big,1\n",
+		"negative weight":    "100,-1\n",
+		"no positive bucket": "100,0\n",
+	}
+
+	for name, content := range invalidCases {
+		content := content
+		t.Run(name, func(t *testing.T) {
+			path := writeFile(t, content)
+
+			if _, err := parseSizeDistribution("hist:file=" + path); err == nil {
+				t.Fatal("Expected parsing to fail")
+			}
+		})
+	}
+}
+
+func TestSyntheticData(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	d := &uniformSizeDistribution{min: 1, maxSize: 2048}
+	s := newSyntheticData(d, r)
+
+	for _, size := range []int{1, minSyntheticDataSize, 100, 2048} {
+		data := s.render(nil, size)
+
+		expectSize := size
+		if expectSize < minSyntheticDataSize {
+			expectSize = minSyntheticDataSize
+		}
+		if len(data) != expectSize {
+			t.Errorf("Expected data of size %d, got %d", expectSize, len(data))
+		}
+
+		if !json.Valid(data) {
+			t.Errorf("Generated data isn't valid JSON: %s", data)
+		}
+	}
+}
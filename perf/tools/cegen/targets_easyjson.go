@@ -1,7 +1,8 @@
 /* Source: https://github.com/tsenart/vegeta/blob/master/lib/targets_easyjson.go */
 
 // This file has been modified from the original generated code to make it work with
-// type alias jsonTarget so that the methods aren't exposed in Target.
+// type alias jsonTarget so that the methods aren't exposed in Target, and to encode
+// headers in a deterministic order.
 
 package main
 
@@ -142,11 +143,15 @@ func (t jsonTarget) encode(out *jwriter.Writer) {
 		}
 		{
 			out.RawByte('{')
-			v6First := true
-			for v6Name, v6Value := range t.Header {
-				if v6First {
-					v6First = false
-				} else {
+			var v6NamesArr [16]string
+			v6Names := v6NamesArr[:0]
+			for v6Name := range t.Header {
+				v6Names = append(v6Names, v6Name)
+			}
+			sortStrings(v6Names)
+			for v6I, v6Name := range v6Names {
+				v6Value := t.Header[v6Name]
+				if v6I > 0 {
 					out.RawByte(',')
 				}
 				out.String(string(v6Name))
@@ -169,3 +174,13 @@ func (t jsonTarget) encode(out *jwriter.Writer) {
 	}
 	out.RawByte('}')
 }
+
+// sortStrings sorts a short slice of strings in increasing order without
+// allocating.
+func sortStrings(s []string) {
+	for i := 1; i < len(s); i++ {
+		for j := i; j > 0 && s[j] < s[j-1]; j-- {
+			s[j], s[j-1] = s[j-1], s[j]
+		}
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delimiters of template directives.
+const (
+	tmplLeftDelim  = "{{"
+	tmplRightDelim = "}}"
+)
+
+// Names of supported template directives.
+const (
+	tmplDirectiveSeq  = "seq"  // {{seq}}
+	tmplDirectiveNow  = "now"  // {{now}}
+	tmplDirectiveRand = "rand" // {{rand <length>}}
+	tmplDirectiveUUID = "uuid" // {{uuid}}
+	tmplDirectivePick = "pick" // {{pick <val1>,<val2>,...}}
+)
+
+// Characters used in random strings. Those are all safe to use inside JSON
+// strings without escaping.
+const randAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// dataTemplate is a pre-parsed representation of some data containing
+// template directives, which can be rendered with little overhead for each
+// generated event.
+type dataTemplate struct {
+	segments []tmplSegment
+}
+
+// tmplSegment is either a literal sequence of bytes, or a directive that
+// renders a value dynamically.
+type tmplSegment struct {
+	literal []byte
+	render  renderFunc
+}
+
+// renderFunc appends a rendered value to dst and returns the extended buffer.
+type renderFunc func(dst []byte, tc *templateContext) []byte
+
+// templateContext contains the state used for rendering the templated data of
+// a single event.
+type templateContext struct {
+	// sequence number of the event
+	seq uint64
+	// source of randomness
+	rand *rand.Rand
+	// generator of unique IDs
+	uuidGen idGenerator
+}
+
+// parseDataTemplate parses the given data into a dataTemplate.
+//
+// Supported directives are:
+//
+//	{{seq}}               sequence number of the event, starting at 1
+//	{{now}}               current time in RFC 3339 format
+//	{{rand <length>}}     random alphanumeric string of the given length
+//	{{uuid}}              unique UUID
+//	{{pick <v1>,<v2>...}} value picked randomly from the given list
+func parseDataTemplate(data []byte) (*dataTemplate, error) {
+	t := &dataTemplate{}
+
+	for len(data) > 0 {
+		start := bytes.Index(data, []byte(tmplLeftDelim))
+		if start == -1 {
+			t.segments = append(t.segments, tmplSegment{literal: data})
+			break
+		}
+
+		if start > 0 {
+			t.segments = append(t.segments, tmplSegment{literal: data[:start]})
+		}
+		data = data[start+len(tmplLeftDelim):]
+
+		end := bytes.Index(data, []byte(tmplRightDelim))
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated template directive at offset %d", start)
+		}
+
+		render, err := parseDirective(string(data[:end]))
+		if err != nil {
+			return nil, fmt.Errorf("parsing template directive %q: %w",
+				tmplLeftDelim+string(data[:end])+tmplRightDelim, err)
+		}
+		t.segments = append(t.segments, tmplSegment{render: render})
+
+		data = data[end+len(tmplRightDelim):]
+	}
+
+	return t, nil
+}
+
+// parseDirective returns a renderFunc for the given template directive.
+func parseDirective(d string) (renderFunc, error) {
+	fields := strings.Fields(d)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty directive")
+	}
+
+	name, args := fields[0], fields[1:]
+
+	switch name {
+	case tmplDirectiveSeq:
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%s doesn't accept any argument", name)
+		}
+		return renderSeq, nil
+
+	case tmplDirectiveNow:
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%s doesn't accept any argument", name)
+		}
+		return renderNow, nil
+
+	case tmplDirectiveUUID:
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%s doesn't accept any argument", name)
+		}
+		return renderUUID, nil
+
+	case tmplDirectiveRand:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s requires exactly one argument", name)
+		}
+		length, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil || length == 0 {
+			return nil, fmt.Errorf("invalid length %q", args[0])
+		}
+		return renderRandFn(int(length)), nil
+
+	case tmplDirectivePick:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s requires exactly one comma-separated list of values", name)
+		}
+		return renderPickFn(strings.Split(args[0], ",")), nil
+
+	default:
+		return nil, fmt.Errorf("unknown directive %q", name)
+	}
+}
+
+// isStatic returns whether the template doesn't contain any directive.
+func (t *dataTemplate) isStatic() bool {
+	for _, s := range t.segments {
+		if s.render != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// render appends the rendered template to dst and returns the extended buffer.
+func (t *dataTemplate) render(dst []byte, tc *templateContext) []byte {
+	for _, s := range t.segments {
+		if s.render != nil {
+			dst = s.render(dst, tc)
+			continue
+		}
+		dst = append(dst, s.literal...)
+	}
+	return dst
+}
+
+func renderSeq(dst []byte, tc *templateContext) []byte {
+	return strconv.AppendUint(dst, tc.seq, 10)
+}
+
+func renderNow(dst []byte, _ *templateContext) []byte {
+	return time.Now().AppendFormat(dst, time.RFC3339Nano)
+}
+
+func renderUUID(dst []byte, tc *templateContext) []byte {
+	return append(dst, tc.uuidGen.Hex128()...)
+}
+
+func renderRandFn(length int) renderFunc {
+	// number of bits required to index any character of the alphabet
+	const (
+		idxBits = 6
+		idxMask = 1<<idxBits - 1
+		idxMax  = 63 / idxBits
+	)
+
+	return func(dst []byte, tc *templateContext) []byte {
+		// consume random bits in chunks of idxBits to reduce the number
+		// of calls to the random source
+		for i, cache, remain := 0, tc.rand.Int63(), idxMax; i < length; {
+			if remain == 0 {
+				cache, remain = tc.rand.Int63(), idxMax
+			}
+			if idx := int(cache & idxMask); idx < len(randAlphabet) {
+				dst = append(dst, randAlphabet[idx])
+				i++
+			}
+			cache >>= idxBits
+			remain--
+		}
+		return dst
+	}
+}
+
+func renderPickFn(vals []string) renderFunc {
+	return func(dst []byte, tc *templateContext) []byte {
+		return append(dst, vals[tc.rand.Intn(len(vals))]...)
+	}
+}
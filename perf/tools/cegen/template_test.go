@@ -0,0 +1,153 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mailru/easyjson/jlexer"
+	uuid "github.com/rogpeppe/fastuuid"
+)
+
+func TestParseDataTemplate(t *testing.T) {
+	testCases := []struct {
+		input     string
+		expectErr string
+	}{
+		{input: `{"msg":"hello"}`},
+		{input: `{"seq":{{seq}},"ts":"{{ now }}","id":"{{uuid}}","r":"{{rand 8}}","c":"{{pick a,b}}"}`},
+		{input: `{{seq`, expectErr: "unterminated template directive"},
+		{input: `{{}}`, expectErr: "empty directive"},
+		{input: `{{unknown}}`, expectErr: `unknown directive "unknown"`},
+		{input: `{{seq 1}}`, expectErr: "doesn't accept any argument"},
+		{input: `{{rand}}`, expectErr: "requires exactly one argument"},
+		{input: `{{rand 0}}`, expectErr: `invalid length "0"`},
+		{input: `{{rand x}}`, expectErr: `invalid length "x"`},
+		{input: `{{pick}}`, expectErr: "requires exactly one comma-separated list of values"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			_, err := parseDataTemplate([]byte(tc.input))
+
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("Expected parsing to fail")
+			}
+			if errStr := err.Error(); !strings.Contains(errStr, tc.expectErr) {
+				t.Fatalf("Unexpected error message: %q", errStr)
+			}
+		})
+	}
+}
+
+func TestRenderDataTemplate(t *testing.T) {
+	tmpl, err := parseDataTemplate([]byte(
+		`{"seq":{{seq}},"ts":"{{now}}","id":"{{uuid}}","r":"{{rand 70}}","c":"{{pick a,b}}"}`,
+	))
+	if err != nil {
+		t.Fatal("Error parsing template:", err)
+	}
+
+	if tmpl.isStatic() {
+		t.Fatal("Expected template to contain directives")
+	}
+
+	tc := &templateContext{
+		seq:     42,
+		rand:    rand.New(rand.NewSource(0)),
+		uuidGen: uuid.MustNewGenerator(),
+	}
+
+	out := tmpl.render(nil, tc)
+
+	expectOut := regexp.MustCompile(`^{"seq":42,"ts":"(.+)","id":"[0-9a-f-]{36}","r":"[0-9a-zA-Z]{70}","c":"(a|b)"}$`)
+
+	subm := expectOut.FindSubmatch(out)
+	if subm == nil {
+		t.Fatal("Unexpected rendered template:", string(out))
+	}
+
+	if _, err := time.Parse(time.RFC3339Nano, string(subm[1])); err != nil {
+		t.Error("Rendered time isn't in RFC 3339 format:", err)
+	}
+}
+
+func TestGenerateWithTemplate(t *testing.T) {
+	tmpl, err := parseDataTemplate([]byte(`{"seq":{{seq}}}`))
+	if err != nil {
+		t.Fatal("Error parsing template:", err)
+	}
+
+	g := NewWeightedCloudEventTargetsGenerator("http://localhost", []*EventProfile{{
+		Type:     "test.event",
+		Source:   "cegen/go/test",
+		Template: tmpl,
+	}})
+
+	for _, expectBody := range []string{`{"seq":1}`, `{"seq":2}`, `{"seq":3}`} {
+		trg, err := g.Generate()
+		if err != nil {
+			t.Fatal("Generate returned an error:", err)
+		}
+
+		var decTrg jsonTarget
+		decTrg.decode(&jlexer.Lexer{Data: trg})
+
+		if !bytes.Equal(decTrg.Body, []byte(expectBody)) {
+			t.Errorf("Expected body %s, got %s", expectBody, decTrg.Body)
+		}
+	}
+}
+
+func BenchmarkGenerateWithTemplate(b *testing.B) {
+	const (
+		url = "http://localhost"
+		typ = "test.event"
+		src = "cegen/go/benchmark"
+	)
+
+	data := []byte(`{"seq":{{seq}},"id":"{{uuid}}","pad":"` + strings.Repeat("0", 1948) + `","r":"{{rand 32}}"}`)
+
+	tmpl, err := parseDataTemplate(data)
+	if err != nil {
+		b.Fatal("Error parsing template:", err)
+	}
+
+	g := NewWeightedCloudEventTargetsGenerator(url, []*EventProfile{{
+		Type:     typ,
+		Source:   src,
+		Template: tmpl,
+	}})
+
+	for i := 0; i < b.N; i++ {
+		if _, err := g.Generate(); err != nil {
+			b.Error("Generate returned an error:", err)
+		}
+	}
+}
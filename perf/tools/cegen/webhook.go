@@ -0,0 +1,183 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+)
+
+// Vendors of webhook requests.
+const (
+	// https://docs.github.com/en/developers/webhooks-and-events/webhooks/webhook-events-and-payloads#push
+	webhookGitHub = "github"
+	// https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html#push-events
+	webhookGitLab = "gitlab"
+)
+
+// Headers of GitHub webhook requests.
+const (
+	headerGitHubEvent        = "X-Github-Event"
+	headerGitHubDelivery     = "X-Github-Delivery"
+	headerGitHubSignature    = "X-Hub-Signature"
+	headerGitHubSignature256 = "X-Hub-Signature-256"
+)
+
+// Headers of GitLab webhook requests.
+const (
+	headerGitLabEvent     = "X-Gitlab-Event"
+	headerGitLabEventUUID = "X-Gitlab-Event-Uuid"
+	headerGitLabToken     = "X-Gitlab-Token"
+)
+
+// githubPushPayload is a template of the payload of a GitHub "push" event.
+// Commit hashes are random strings, which are not validated by receivers.
+const githubPushPayload = `{"ref":"refs/heads/main","before":"{{rand 40}}","after":"{{rand 40}}",` +
+	`"created":false,"deleted":false,"forced":false,"base_ref":null,` +
+	`"compare":"https://github.com/triggermesh/cegen/compare/main",` +
+	`"commits":[{"id":"{{rand 40}}","tree_id":"{{rand 40}}","distinct":true,"message":"Commit {{seq}}",` +
+	`"timestamp":"{{now}}","url":"https://github.com/triggermesh/cegen/commit/{{seq}}",` +
+	`"author":{"name":"cegen","email":"cegen@example.com","username":"cegen"},` +
+	`"committer":{"name":"cegen","email":"cegen@example.com","username":"cegen"},` +
+	`"added":[],"removed":[],"modified":["README.md"]}],` +
+	`"head_commit":null,` +
+	`"repository":{"id":1,"node_id":"MDEwOlJlcG9zaXRvcnkx","name":"cegen","full_name":"triggermesh/cegen",` +
+	`"private":false,"owner":{"name":"triggermesh","email":null,"login":"triggermesh","id":1},` +
+	`"html_url":"https://github.com/triggermesh/cegen","url":"https://github.com/triggermesh/cegen",` +
+	`"created_at":1577836800,"pushed_at":1577836800,"default_branch":"main","master_branch":"main"},` +
+	`"pusher":{"name":"cegen","email":"cegen@example.com"},` +
+	`"sender":{"login":"cegen","id":1,"type":"User"}}`
+
+// gitlabPushPayload is a template of the payload of a GitLab "Push Hook"
+// event. Commit hashes are random strings, which are not validated by
+// receivers.
+const gitlabPushPayload = `{"object_kind":"push","event_name":"push",` +
+	`"before":"{{rand 40}}","after":"{{rand 40}}","ref":"refs/heads/main","checkout_sha":"{{rand 40}}",` +
+	`"user_id":1,"user_name":"cegen","user_username":"cegen","user_email":"cegen@example.com","user_avatar":"",` +
+	`"project_id":1,"project":{"id":1,"name":"cegen","description":"","web_url":"https://gitlab.com/triggermesh/cegen",` +
+	`"avatar_url":null,"git_ssh_url":"git@gitlab.com:triggermesh/cegen.git",` +
+	`"git_http_url":"https://gitlab.com/triggermesh/cegen.git","namespace":"triggermesh","visibility_level":0,` +
+	`"path_with_namespace":"triggermesh/cegen","default_branch":"main"},` +
+	`"commits":[{"id":"{{rand 40}}","message":"Commit {{seq}}","title":"Commit {{seq}}","timestamp":"{{now}}",` +
+	`"url":"https://gitlab.com/triggermesh/cegen/-/commit/{{seq}}",` +
+	`"author":{"name":"cegen","email":"cegen@example.com"},"added":[],"modified":["README.md"],"removed":[]}],` +
+	`"total_commits_count":1,"repository":{"name":"cegen","url":"git@gitlab.com:triggermesh/cegen.git",` +
+	`"description":"","homepage":"https://gitlab.com/triggermesh/cegen",` +
+	`"git_http_url":"https://gitlab.com/triggermesh/cegen.git","git_ssh_url":"git@gitlab.com:triggermesh/cegen.git",` +
+	`"visibility_level":0}}`
+
+// validateWebhook verifies that the given kind of webhook is supported.
+func validateWebhook(kind string) error {
+	switch kind {
+	case "", webhookGitHub, webhookGitLab:
+		return nil
+	default:
+		return fmt.Errorf("invalid webhook %q", kind)
+	}
+}
+
+// defaultWebhookPayload returns a template of the payload of a push event for
+// the given kind of webhook.
+func defaultWebhookPayload(kind string) *dataTemplate {
+	payload := githubPushPayload
+	if kind == webhookGitLab {
+		payload = gitlabPushPayload
+	}
+
+	tmpl, err := parseDataTemplate([]byte(payload))
+	if err != nil {
+		panic(fmt.Errorf("parsing built-in %s payload: %w", kind, err))
+	}
+	return tmpl
+}
+
+// webhookSigner computes the signatures of GitHub webhook payloads.
+// https://docs.github.com/en/developers/webhooks-and-events/webhooks/securing-your-webhooks
+type webhookSigner struct {
+	sha1   hash.Hash
+	sha256 hash.Hash
+	// buffers in which signatures are computed and hex-encoded
+	sumBuf []byte
+	hexBuf []byte
+}
+
+// newWebhookSigner returns a webhookSigner which signs payloads with the
+// given secret.
+func newWebhookSigner(secret string) *webhookSigner {
+	return &webhookSigner{
+		sha1:   hmac.New(sha1.New, []byte(secret)),
+		sha256: hmac.New(sha256.New, []byte(secret)),
+	}
+}
+
+// sign returns the signature of the given payload computed with h, in the
+// format "<prefix>=<hex digest>".
+func (s *webhookSigner) sign(h hash.Hash, prefix string, payload []byte) string {
+	h.Reset()
+	_, _ = h.Write(payload)
+	s.sumBuf = h.Sum(s.sumBuf[:0])
+
+	n := len(prefix) + 1
+	size := n + hex.EncodedLen(len(s.sumBuf))
+	if cap(s.hexBuf) < size {
+		s.hexBuf = make([]byte, size)
+	}
+	s.hexBuf = s.hexBuf[:size]
+
+	copy(s.hexBuf, prefix)
+	s.hexBuf[n-1] = '='
+	hex.Encode(s.hexBuf[n:], s.sumBuf)
+
+	return string(s.hexBuf)
+}
+
+// setWebhookHeaderAndBody sets the HTTP headers and body of the given target
+// to the ones of a webhook request carrying the data of the current event.
+func (g *CloudEventTargetsGenerator) setWebhookHeaderAndBody(t *jsonTarget, p *eventProfile) {
+	t.Body = g.eventData(p)
+
+	t.Header = http.Header{
+		"Content-Type": []string{p.dataContentType},
+	}
+
+	switch p.webhook {
+	case webhookGitHub:
+		t.Header["User-Agent"] = []string{"GitHub-Hookshot/cegen"}
+		t.Header[headerGitHubEvent] = []string{"push"}
+		t.Header[headerGitHubDelivery] = []string{g.eventID()}
+
+		if p.webhookSigner != nil {
+			s := p.webhookSigner
+			t.Header[headerGitHubSignature] = []string{s.sign(s.sha1, "sha1", t.Body)}
+			t.Header[headerGitHubSignature256] = []string{s.sign(s.sha256, "sha256", t.Body)}
+		}
+
+	case webhookGitLab:
+		t.Header["User-Agent"] = []string{"GitLab/cegen"}
+		t.Header[headerGitLabEvent] = []string{"Push Hook"}
+		t.Header[headerGitLabEventUUID] = []string{g.eventID()}
+
+		if p.webhookSecret != "" {
+			t.Header[headerGitLabToken] = []string{p.webhookSecret}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mailru/easyjson/jlexer"
+)
+
+func TestWebhook(t *testing.T) {
+	const secret = "s3cr3t"
+
+	testCases := []struct {
+		name         string
+		profile      *EventProfile
+		expectHeader http.Header
+		// whether the payload is signed with the secret
+		signed bool
+	}{
+		{
+			name: "GitHub with secret",
+			profile: &EventProfile{
+				Webhook:       webhookGitHub,
+				WebhookSecret: secret,
+			},
+			expectHeader: http.Header{
+				"Content-Type":    []string{contentTypeJSON},
+				"User-Agent":      []string{"GitHub-Hookshot/cegen"},
+				headerGitHubEvent: []string{"push"},
+			},
+			signed: true,
+		},
+		{
+			name: "GitHub without secret",
+			profile: &EventProfile{
+				Webhook: webhookGitHub,
+			},
+			expectHeader: http.Header{
+				"Content-Type":    []string{contentTypeJSON},
+				"User-Agent":      []string{"GitHub-Hookshot/cegen"},
+				headerGitHubEvent: []string{"push"},
+			},
+		},
+		{
+			name: "GitLab with secret",
+			profile: &EventProfile{
+				Webhook:       webhookGitLab,
+				WebhookSecret: secret,
+			},
+			expectHeader: http.Header{
+				"Content-Type":    []string{contentTypeJSON},
+				"User-Agent":      []string{"GitLab/cegen"},
+				headerGitLabEvent: []string{"Push Hook"},
+				headerGitLabToken: []string{secret},
+			},
+		},
+		{
+			name: "GitHub with custom data",
+			profile: &EventProfile{
+				Data:          []byte(`{"zen":"Keep it logically awesome."}`),
+				Webhook:       webhookGitHub,
+				WebhookSecret: secret,
+			},
+			expectHeader: http.Header{
+				"Content-Type":    []string{contentTypeJSON},
+				"User-Agent":      []string{"GitHub-Hookshot/cegen"},
+				headerGitHubEvent: []string{"push"},
+			},
+			signed: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWeightedCloudEventTargetsGenerator("http://localhost", []*EventProfile{tc.profile})
+
+			// ensure signers are reset between payloads
+			for i := 0; i < 2; i++ {
+				trg, err := g.Generate()
+				if err != nil {
+					t.Fatal("Generate returned an error:", err)
+				}
+
+				var decTrg jsonTarget
+				decTrg.decode(&jlexer.Lexer{Data: trg})
+
+				if !json.Valid(decTrg.Body) {
+					t.Fatalf("Body isn't valid JSON: %s", decTrg.Body)
+				}
+
+				for k := range decTrg.Header {
+					if strings.HasPrefix(k, "Ce-") {
+						t.Errorf("Unexpected CloudEvent header %s", k)
+					}
+				}
+
+				for k, v := range tc.expectHeader {
+					if got := decTrg.Header[k]; len(got) != 1 || got[0] != v[0] {
+						t.Errorf("Expected header %s to be %q, got %q", k, v, got)
+					}
+				}
+
+				signatures := map[string]string{
+					headerGitHubSignature:    "",
+					headerGitHubSignature256: "",
+				}
+				if tc.signed {
+					signatures[headerGitHubSignature] = "sha1=" + testHMAC(sha1.New, secret, decTrg.Body)
+					signatures[headerGitHubSignature256] = "sha256=" + testHMAC(sha256.New, secret, decTrg.Body)
+				}
+
+				for k, expectSig := range signatures {
+					if sig := decTrg.Header.Get(k); sig != expectSig {
+						t.Errorf("Expected header %s to be %q, got %q", k, expectSig, sig)
+					}
+				}
+			}
+		})
+	}
+}
+
+// testHMAC returns the hex-encoded HMAC of the given payload, computed with
+// the given hash function and secret.
+func testHMAC(h func() hash.Hash, secret string, payload []byte) string {
+	mac := hmac.New(h, []byte(secret))
+	_, _ = mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
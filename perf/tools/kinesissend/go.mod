@@ -0,0 +1,5 @@
+module kinesissend
+
+go 1.15
+
+require github.com/aws/aws-sdk-go v1.35.15
@@ -0,0 +1,536 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+)
+
+const (
+	// https://docs.aws.amazon.com/kinesis/latest/APIReference/API_PutRecords.html
+	maxBatchSizeBytes = 5 * 1024 * 1024 // 5 MiB
+	maxBatchRecords   = 500
+
+	// the size of a record includes the size of its partition key
+	maxRecordSizeBytes = 1024 * 1024 // 1 MiB
+
+	defaultRecordSizeBytes = 2 * 1024 // 2 KiB
+	defaultNumRecords      = 100
+	defaultNumKeys         = 100
+)
+
+// Retries of records which failed with a transient error.
+const (
+	maxSendRetries = 5
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+func main() {
+	cg := &clientGetter{configProvider: session.Must(session.NewSession())}
+
+	if err := run(cg, os.Args, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running command: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(cg ClientGetter, args []string, stderr io.Writer) error {
+	cmdName := filepath.Base(args[0])
+
+	flags := flag.NewFlagSet(cmdName, flag.ExitOnError)
+	flags.SetOutput(stderr)
+
+	opts, err := readOpts(flags, args)
+	if err != nil {
+		return fmt.Errorf("reading options: %w", err)
+	}
+
+	s := &batchSender{cli: cg.Get(&opts.region)}
+
+	if *opts.rate > 0 {
+		return sendSustained(s, opts, stderr)
+	}
+
+	return s.send(batchList(prepareRecordBatches(opts)))
+}
+
+// cmdOpts are the options that can be passed to the command.
+type cmdOpts struct {
+	streamName string
+	region     string
+	numRecords *uint
+	recordSize *uint
+	numKeys    *uint
+
+	rate     *uint
+	duration *time.Duration
+}
+
+// readOpts parses and validates options from commmand-line flags.
+func readOpts(f *flag.FlagSet, args []string) (*cmdOpts, error) {
+	opts := &cmdOpts{}
+	streamARN := f.String("a", "", "ARN of the Amazon Kinesis data stream to send records to")
+	opts.numRecords = f.Uint("n", defaultNumRecords, "Number of records to send")
+	opts.recordSize = f.Uint("s", defaultRecordSizeBytes, "Size of the data of records in bytes")
+	opts.numKeys = f.Uint("keys", defaultNumKeys, "Number of distinct partition keys which records are "+
+		"distributed across. Higher values spread the load more evenly across the shards of the stream")
+	opts.rate = f.Uint("rate", 0, "Number of records to send per second, during the time set by -duration. "+
+		"0 = send the number of records set by -n in a single burst")
+	opts.duration = f.Duration("duration", 0, "Duration of the sending of records at the rate set by -rate")
+
+	err := f.Parse(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if *streamARN == "" {
+		return nil, fmt.Errorf("stream ARN isn't set")
+	}
+	if opts.streamName, opts.region, err = parseStreamARN(*streamARN); err != nil {
+		return nil, fmt.Errorf("invalid stream ARN: %w", err)
+	}
+
+	if *opts.numKeys == 0 {
+		return nil, fmt.Errorf("number of partition keys must be greater than 0")
+	}
+
+	keySize := len(partitionKey(*opts.numKeys - 1))
+	if s := int(*opts.recordSize) + keySize; s > maxRecordSizeBytes {
+		return nil, fmt.Errorf("record size %d B exceeds the maximum of %d B", s, maxRecordSizeBytes)
+	}
+
+	switch {
+	case *opts.rate > 0 && *opts.duration <= 0:
+		return nil, fmt.Errorf("duration must be greater than 0 when a rate is set")
+	case *opts.rate > 0 && isFlagSet(f, "n"):
+		return nil, fmt.Errorf("number of records and rate are mutually exclusive")
+	case *opts.rate == 0 && isFlagSet(f, "duration"):
+		return nil, fmt.Errorf("duration requires a rate to be set")
+	}
+
+	return opts, nil
+}
+
+// isFlagSet returns whether the flag with the given name was explicitly set.
+func isFlagSet(f *flag.FlagSet, name string) bool {
+	var isSet bool
+	f.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			isSet = true
+		}
+	})
+	return isSet
+}
+
+// parseStreamARN returns the name and the AWS region of the Kinesis data
+// stream with the given ARN.
+func parseStreamARN(s string) (name, region string, err error) {
+	// The expected format is "arn:aws:kinesis:us-west-2:123456789012:stream/MyStream"
+	a, err := arn.Parse(s)
+	if err != nil {
+		return "", "", err
+	}
+
+	if a.Service != kinesis.ServiceName {
+		return "", "", fmt.Errorf("unexpected service %q", a.Service)
+	}
+
+	const resourcePrefix = "stream/"
+
+	if !strings.HasPrefix(a.Resource, resourcePrefix) || len(a.Resource) == len(resourcePrefix) {
+		return "", "", fmt.Errorf("resource %q isn't a stream", a.Resource)
+	}
+
+	return strings.TrimPrefix(a.Resource, resourcePrefix), a.Region, nil
+}
+
+// partitionKey returns the partition key with the given index.
+func partitionKey(i uint) string {
+	return "key-" + strconv.FormatUint(uint64(i), 10)
+}
+
+// prepareRecordBatches builds a list of batch requests containing the records
+// to be sent to the stream.
+func prepareRecordBatches(o *cmdOpts) []*kinesis.PutRecordsInput {
+	gen := newRecordGenerator(o)
+	p := newBatchPacker(o.streamName)
+
+	for i := uint(0); i < *o.numRecords; i++ {
+		p.add(gen.next())
+	}
+
+	return p.batches
+}
+
+// recordGenerator generates records with data of a fixed size, distributed
+// across partition keys in a round-robin fashion.
+type recordGenerator struct {
+	data []byte
+	keys []string
+	seq  uint
+}
+
+// newRecordGenerator returns a recordGenerator for the records described by
+// the command's options.
+func newRecordGenerator(o *cmdOpts) *recordGenerator {
+	keys := make([]string, *o.numKeys)
+	for i := range keys {
+		keys[i] = partitionKey(uint(i))
+	}
+
+	return &recordGenerator{
+		data: []byte(strings.Repeat("0", int(*o.recordSize))),
+		keys: keys,
+	}
+}
+
+// next returns a new record. The data of all records is shared.
+func (g *recordGenerator) next() *kinesis.PutRecordsRequestEntry {
+	r := &kinesis.PutRecordsRequestEntry{
+		Data:         g.data,
+		PartitionKey: &g.keys[g.seq%uint(len(g.keys))],
+	}
+
+	g.seq++
+
+	return r
+}
+
+// batchPacker packs records into batch requests, within the limits of
+// Kinesis on the number of records and the size of a batch.
+type batchPacker struct {
+	streamName *string
+	batches    []*kinesis.PutRecordsInput
+
+	// size of the last batch
+	size int
+}
+
+// newBatchPacker returns a batchPacker for the given stream.
+func newBatchPacker(streamName string) *batchPacker {
+	return &batchPacker{
+		streamName: &streamName,
+	}
+}
+
+// add adds the given record to the last batch, or to a new batch if the
+// last batch can't hold it.
+func (p *batchPacker) add(r *kinesis.PutRecordsRequestEntry) {
+	size := recordSize(r)
+
+	if n := len(p.batches); n == 0 ||
+		len(p.batches[n-1].Records) == maxBatchRecords ||
+		p.size+size > maxBatchSizeBytes {
+
+		p.batches = append(p.batches, &kinesis.PutRecordsInput{
+			StreamName: p.streamName,
+		})
+		p.size = 0
+	}
+
+	b := p.batches[len(p.batches)-1]
+	b.Records = append(b.Records, r)
+	p.size += size
+}
+
+// recordSize returns the size of the given record, as accounted by Kinesis
+// towards the size limit of a batch.
+func recordSize(r *kinesis.PutRecordsRequestEntry) int {
+	return len(r.Data) + len(aws.StringValue(r.PartitionKey))
+}
+
+// batchProducer writes batches of records to batchCh until it is done or the
+// given context is cancelled. It must not close batchCh.
+type batchProducer func(ctx context.Context, batchCh chan<- *kinesis.PutRecordsInput)
+
+// batchList returns a batchProducer which writes the given batches.
+func batchList(batches []*kinesis.PutRecordsInput) batchProducer {
+	return func(ctx context.Context, batchCh chan<- *kinesis.PutRecordsInput) {
+		for _, b := range batches {
+			select {
+			case batchCh <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// batchSender sends batches of records concurrently.
+type batchSender struct {
+	cli Client
+
+	// records the outcome of each batch, if not nil
+	prog *progress
+}
+
+// send sends the record batches written by the given producer concurrently.
+func (s *batchSender) send(produce batchProducer) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	batchCh := make(chan *kinesis.PutRecordsInput)
+
+	go func() {
+		produce(ctx, batchCh)
+		close(batchCh)
+	}()
+
+	// try 1 batch first, and send the rest in bulk only if this succeeded
+	firstBatch, ok := <-batchCh
+	if !ok {
+		return nil
+	}
+
+	err := s.sendBatch(firstBatch)
+	s.prog.record(len(firstBatch.Records), err)
+	if err != nil {
+		return fmt.Errorf("sending first batch of %d records: %w", len(firstBatch.Records), err)
+	}
+
+	errCh := make(chan error)
+
+	s.runBatchProcessors(batchCh, errCh)
+
+	var errs []error
+	var failedRecords int
+
+	for err := range errCh {
+		if err != nil {
+			if errSend := (&errSendBatch{}); errors.As(err, &errSend) {
+				failedRecords += errSend.count
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("sending %d records: %w", failedRecords, &errList{errs: errs})
+	}
+
+	return nil
+}
+
+// runBatchProcessors runs background task processors that process batches of
+// records from batchCh and send their results to errCh. errCh is closed once
+// all batches have been processed.
+func (s *batchSender) runBatchProcessors(batchCh <-chan *kinesis.PutRecordsInput, errCh chan<- error) {
+	// Each processor spends most of its time waiting for the network, so
+	// we can run more than one per thread.
+	const processorPerProc = 4
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < runtime.GOMAXPROCS(-1)*processorPerProc; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				b, ok := <-batchCh
+				if !ok {
+					return
+				}
+
+				err := s.sendBatch(b)
+				s.prog.record(len(b.Records), err)
+
+				// always write to errCh to notify the batch has been processed
+				errCh <- err
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
+}
+
+// sendBatch sends the given batch of records. Records which the API reports
+// as failed are sent again in a new batch, after an exponential backoff, up
+// to maxSendRetries times. Kinesis only reports transient errors for
+// individual records: ProvisionedThroughputExceededException and
+// InternalFailure.
+// The returned error, if any, is a *errSendBatch.
+func (s *batchSender) sendBatch(b *kinesis.PutRecordsInput) error {
+	var failedCodes []string
+
+	for attempt := 0; ; attempt++ {
+		out, err := s.cli.PutRecords(b)
+		if err != nil {
+			return &errSendBatch{
+				count: len(b.Records) + len(failedCodes),
+				err:   err,
+			}
+		}
+		if out == nil || aws.Int64Value(out.FailedRecordCount) == 0 {
+			break
+		}
+
+		var retry []*kinesis.PutRecordsRequestEntry
+
+		// results are in the same order as the records of the request
+		for i, res := range out.Records {
+			if res.ErrorCode == nil || i >= len(b.Records) {
+				continue
+			}
+			if attempt == maxSendRetries {
+				failedCodes = append(failedCodes, *res.ErrorCode)
+				continue
+			}
+			retry = append(retry, b.Records[i])
+		}
+
+		if len(retry) == 0 {
+			break
+		}
+
+		sleep(retryDelay(attempt))
+
+		b = &kinesis.PutRecordsInput{
+			Records:    retry,
+			StreamName: b.StreamName,
+		}
+	}
+
+	if len(failedCodes) > 0 {
+		return &errSendBatch{
+			count: len(failedCodes),
+			err:   &errFailedRecords{codes: failedCodes},
+		}
+	}
+
+	return nil
+}
+
+// retryDelay returns the time to wait before the given retry attempt (from 0),
+// which grows exponentially with a random jitter.
+func retryDelay(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+	if d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+var sleep = time.Sleep
+
+// Client is an alias for kinesisiface.KinesisAPI.
+type Client = kinesisiface.KinesisAPI
+
+// ClientGetter can obtain Kinesis clients.
+type ClientGetter interface {
+	Get(region *string) Client
+}
+
+// clientGetter gets Kinesis clients using a awsclient.ConfigProvider.
+type clientGetter struct {
+	configProvider client.ConfigProvider
+}
+
+// clientGetter implements ClientGetter.
+var _ ClientGetter = (*clientGetter)(nil)
+
+// Get implements ClientGetter.
+func (g *clientGetter) Get(region *string) Client {
+	var cfgs []*aws.Config
+
+	if region != nil {
+		cfgs = append(cfgs, aws.NewConfig().WithRegion(*region))
+	}
+
+	return kinesis.New(g.configProvider, cfgs...)
+}
+
+// ClientGetterFunc allows the use of ordinary functions as ClientGetter.
+type ClientGetterFunc func(region *string) Client
+
+// ClientGetterFunc implements ClientGetter.
+var _ ClientGetter = (ClientGetterFunc)(nil)
+
+// Get implements ClientGetter.
+func (f ClientGetterFunc) Get(region *string) Client {
+	return f(region)
+}
+
+type errList struct {
+	errs []error
+}
+
+var _ error = (*errList)(nil)
+
+// Error implements the error interface.
+func (e *errList) Error() string {
+	return fmt.Sprintf("%q", e.errs)
+}
+
+// errSendBatch indicates that a batch of records couldn't be sent.
+type errSendBatch struct {
+	count int
+	err   error
+}
+
+// Error implements the error interface.
+func (e *errSendBatch) Error() string {
+	return e.err.Error()
+}
+
+// errFailedRecords indicates that records of a batch were reported as failed
+// by the API, after all retries were exhausted.
+type errFailedRecords struct {
+	// error codes of the failed records
+	codes []string
+}
+
+// Error implements the error interface.
+func (e *errFailedRecords) Error() string {
+	countByCode := make(map[string]int)
+	for _, c := range e.codes {
+		countByCode[c]++
+	}
+
+	codes := make([]string, 0, len(countByCode))
+	for c, n := range countByCode {
+		codes = append(codes, fmt.Sprintf("%s (%d)", c, n))
+	}
+	sort.Strings(codes)
+
+	return fmt.Sprintf("%d records failed: %s", len(e.codes), strings.Join(codes, ", "))
+}
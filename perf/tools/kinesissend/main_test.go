@@ -0,0 +1,468 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+)
+
+const tCmd = "test"
+
+const tStreamARN = "arn:aws:kinesis:us-west-2:123456789012:stream/MyStream"
+
+func TestSend(t *testing.T) {
+	testCases := []struct {
+		numRecords int
+		expectReq  int
+	}{
+		{
+			numRecords: 0,
+			expectReq:  0,
+		},
+		{
+			numRecords: 1,
+			expectReq:  1,
+		},
+		{
+			numRecords: maxBatchRecords,
+			expectReq:  1,
+		},
+		{
+			numRecords: maxBatchRecords + 1,
+			expectReq:  2,
+		},
+		{
+			numRecords: 9_999,
+			expectReq:  20, // assuming maxBatchRecords is 500
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strconv.Itoa(tc.numRecords)+" record(s)", func(t *testing.T) {
+			cli := &mockKinesisSender{}
+			cg := staticClientGetter(cli)
+
+			var stderr strings.Builder
+
+			err := run(cg, []string{tCmd, "-a=" + tStreamARN, "-n", strconv.Itoa(tc.numRecords)}, &stderr)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+
+			gotReq := cli.reqSent
+			if gotReq != tc.expectReq {
+				t.Errorf("Expected %d requests to be sent, got %d", tc.expectReq, gotReq)
+			}
+
+			gotRecords := cli.recordsSent
+			if gotRecords != tc.numRecords {
+				t.Errorf("Expected %d records to be sent, got %d", tc.numRecords, gotRecords)
+			}
+		})
+	}
+}
+
+func TestBatchPacking(t *testing.T) {
+	const keySize = uint(len("key-0"))
+
+	testCases := []struct {
+		recordSize uint
+		numRecords uint
+		// number of records in each batch
+		expectBatches []int
+	}{
+		{
+			recordSize:    1,
+			numRecords:    1200,
+			expectBatches: []int{500, 500, 200},
+		},
+		{
+			recordSize:    maxBatchSizeBytes/4 - keySize,
+			numRecords:    9,
+			expectBatches: []int{4, 4, 1},
+		},
+		{
+			recordSize:    maxRecordSizeBytes - keySize,
+			numRecords:    6,
+			expectBatches: []int{5, 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strconv.FormatUint(uint64(tc.recordSize), 10)+" B records", func(t *testing.T) {
+			batches := prepareRecordBatches(&cmdOpts{
+				streamName: "MyStream",
+				numRecords: &tc.numRecords,
+				recordSize: &tc.recordSize,
+				numKeys:    aws.Uint(1),
+			})
+
+			gotBatches := make([]int, len(batches))
+			for i, b := range batches {
+				gotBatches[i] = len(b.Records)
+
+				var size int
+				for _, r := range b.Records {
+					size += recordSize(r)
+				}
+				if size > maxBatchSizeBytes {
+					t.Errorf("Batch %d exceeds the maximum size: %d B", i, size)
+				}
+			}
+
+			if len(gotBatches) != len(tc.expectBatches) {
+				t.Fatalf("Expected batches of %v records, got %v", tc.expectBatches, gotBatches)
+			}
+			for i := range gotBatches {
+				if gotBatches[i] != tc.expectBatches[i] {
+					t.Fatalf("Expected batches of %v records, got %v", tc.expectBatches, gotBatches)
+				}
+			}
+		})
+	}
+}
+
+func TestPartitionKeys(t *testing.T) {
+	const numKeys = 7
+
+	batches := prepareRecordBatches(&cmdOpts{
+		streamName: "MyStream",
+		numRecords: aws.Uint(100),
+		recordSize: aws.Uint(1),
+		numKeys:    aws.Uint(numKeys),
+	})
+
+	keys := make(map[string]int)
+	for _, b := range batches {
+		for _, r := range b.Records {
+			keys[*r.PartitionKey]++
+		}
+	}
+
+	if len(keys) != numKeys {
+		t.Fatalf("Expected records to be distributed across %d partition keys, got %d", numKeys, len(keys))
+	}
+	for k, n := range keys {
+		if n < 100/numKeys || n > 100/numKeys+1 {
+			t.Errorf("Expected records to be evenly distributed, got %d records with key %q", n, k)
+		}
+	}
+}
+
+func TestSendWithError(t *testing.T) {
+	const numRequests = 10
+	const numRecords = maxBatchRecords * numRequests
+
+	testCases := []struct {
+		failEvery int
+		expectMsg string
+	}{
+		{
+			failEvery: 1,
+			expectMsg: "sending first batch of " + strconv.Itoa(maxBatchRecords) + " records: fake error",
+		},
+		{
+			failEvery: 3,
+			expectMsg: "sending " + strconv.Itoa(maxBatchRecords*(numRequests/3)) + ` records: ["fake error" `,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run("fail every "+strconv.Itoa(tc.failEvery)+" request(s)", func(t *testing.T) {
+			cli := &mockKinesisSender{
+				failEvery: tc.failEvery,
+			}
+			cg := staticClientGetter(cli)
+
+			var stderr strings.Builder
+
+			err := run(cg, []string{tCmd, "-a=" + tStreamARN, "-n", strconv.Itoa(numRecords)}, &stderr)
+			if err == nil {
+				t.Fatal("Expected command to fail")
+			}
+
+			if errStr := err.Error(); !strings.Contains(errStr, tc.expectMsg) {
+				t.Fatalf("Unexpected error message: %q", errStr)
+			}
+		})
+	}
+}
+
+func TestSendWithFailedRecords(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	const failCode = "ProvisionedThroughputExceededException"
+
+	t.Run("failed records are retried", func(t *testing.T) {
+		const numRecords = 1000
+		const numFailed = 3
+
+		cli := &mockKinesisSender{
+			recordErrCode:  failCode,
+			failRecords:    numFailed,
+			failedRequests: 2,
+		}
+		cg := staticClientGetter(cli)
+
+		var stderr strings.Builder
+
+		err := run(cg, []string{tCmd, "-a=" + tStreamARN, "-n", strconv.Itoa(numRecords)}, &stderr)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		// the first numFailed records of the first 2 requests are sent again
+		if expect := numRecords + 2*numFailed; cli.recordsSent != expect {
+			t.Errorf("Expected %d records to be sent, got %d", expect, cli.recordsSent)
+		}
+	})
+
+	t.Run("records are reported as failed after all retries", func(t *testing.T) {
+		cli := &mockKinesisSender{
+			recordErrCode:  failCode,
+			failRecords:    1,
+			failedRequests: -1,
+		}
+		cg := staticClientGetter(cli)
+
+		var stderr strings.Builder
+
+		err := run(cg, []string{tCmd, "-a=" + tStreamARN, "-n", "10"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "sending first batch of 10 records: 1 records failed: " + failCode + " (1)"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+
+		if expect := 10 + maxSendRetries; cli.recordsSent != expect {
+			t.Errorf("Expected %d records to be sent, got %d", expect, cli.recordsSent)
+		}
+	})
+}
+
+func TestArgs(t *testing.T) {
+	cli := &mockKinesisSender{}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	t.Run("missing -a flag", func(t *testing.T) {
+		err := run(cg, []string{tCmd}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "stream ARN isn't set"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("invalid -a value", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-a", "arn:aws:sqs:us-west-2:123456789012:MyQueue"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := `invalid stream ARN: unexpected service "sqs"`
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("record exceeding the maximum size", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-a=" + tStreamARN, "-s", strconv.Itoa(maxRecordSizeBytes)}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "exceeds the maximum of"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("zero partition keys", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-a=" + tStreamARN, "-keys", "0"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "number of partition keys must be greater than 0"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("-rate without -duration", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-a=" + tStreamARN, "-rate", "100"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "duration must be greater than 0 when a rate is set"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("-rate with -n", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-a=" + tStreamARN, "-rate", "100", "-duration", "1s", "-n", "10"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "number of records and rate are mutually exclusive"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+}
+
+func TestParseStreamARN(t *testing.T) {
+	testCases := []struct {
+		input        string
+		expectName   string
+		expectRegion string
+		expectErr    bool
+	}{
+		{
+			input:        "arn:aws:kinesis:us-west-2:123456789012:stream/MyStream",
+			expectName:   "MyStream",
+			expectRegion: "us-west-2",
+		},
+		{
+			input:        "arn:aws-us-gov:kinesis:us-gov-west-1:123456789012:stream/MyStream",
+			expectName:   "MyStream",
+			expectRegion: "us-gov-west-1",
+		},
+		{
+			input:     "arn:aws:kinesis:us-west-2:123456789012:stream/",
+			expectErr: true,
+		},
+		{
+			input:     "arn:aws:kinesis:us-west-2:123456789012:MyStream",
+			expectErr: true,
+		},
+		{
+			input:     "MyStream",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			name, region, err := parseStreamARN(tc.input)
+
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+
+			if name != tc.expectName {
+				t.Errorf("Expected name %s, got %s", tc.expectName, name)
+			}
+			if region != tc.expectRegion {
+				t.Errorf("Expected region %s, got %s", tc.expectRegion, region)
+			}
+		})
+	}
+}
+
+func staticClientGetter(cli Client) ClientGetterFunc {
+	return func(*string) Client {
+		return cli
+	}
+}
+
+type mockKinesisSender struct {
+	kinesisiface.KinesisAPI
+
+	sync.Mutex
+	reqSent     int
+	recordsSent int
+
+	failEvery int
+
+	// the first failRecords records of the first failedRequests requests
+	// are reported as failed with recordErrCode. A negative
+	// failedRequests fails records of all requests.
+	recordErrCode  string
+	failRecords    int
+	failedRequests int
+}
+
+func (m *mockKinesisSender) PutRecords(in *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	var out *kinesis.PutRecordsOutput
+	var err error
+
+	if in != nil {
+		m.Lock()
+		m.reqSent++
+		m.recordsSent += len(in.Records)
+
+		if m.failEvery > 0 && m.reqSent%m.failEvery == 0 {
+			err = errors.New("fake error")
+		}
+
+		if m.recordErrCode != "" && m.failedRequests != 0 {
+			m.failedRequests--
+
+			out = &kinesis.PutRecordsOutput{
+				Records: make([]*kinesis.PutRecordsResultEntry, len(in.Records)),
+			}
+
+			var failed int64
+			for i := range in.Records {
+				out.Records[i] = &kinesis.PutRecordsResultEntry{
+					SequenceNumber: aws.String(strconv.Itoa(i)),
+					ShardId:        aws.String("shardId-000000000000"),
+				}
+				if i < m.failRecords {
+					out.Records[i] = &kinesis.PutRecordsResultEntry{
+						ErrorCode:    &m.recordErrCode,
+						ErrorMessage: aws.String("Rate exceeded for shard"),
+					}
+					failed++
+				}
+			}
+			out.FailedRecordCount = &failed
+		}
+
+		m.Unlock()
+	}
+
+	return out, err
+}
@@ -0,0 +1,441 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/API_ReceiveMessage.html
+	maxReceiveMessages = 10
+	maxWaitTime        = 20 * time.Second
+
+	defaultWaitTime    = maxWaitTime
+	defaultQuietPeriod = 10 * time.Second
+
+	// Interval at which the progress of the reception is reported.
+	progressInterval = time.Second
+)
+
+// Retries of failed receive calls.
+const (
+	// maximum number of consecutive failed calls after which a receiver
+	// gives up
+	maxReceiveRetries = 5
+	retryBaseDelay    = 100 * time.Millisecond
+	retryMaxDelay     = 5 * time.Second
+)
+
+// allMessageAttributes is the name which selects all message attributes in
+// receive requests.
+const allMessageAttributes = "All"
+
+func main() {
+	cg := &clientGetter{configProvider: session.Must(session.NewSession())}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	if err := run(ctx, cg, os.Args, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running command: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cg ClientGetter, args []string, stdout, stderr io.Writer) error {
+	cmdName := filepath.Base(args[0])
+
+	flags := flag.NewFlagSet(cmdName, flag.ExitOnError)
+	flags.SetOutput(stderr)
+
+	opts, err := readOpts(flags, args)
+	if err != nil {
+		return fmt.Errorf("reading options: %w", err)
+	}
+
+	r := &receiver{
+		cli:      cg.Get(parseRegionFromQueueURL(opts.queueURL)),
+		queueURL: aws.String(opts.queueURL.String()),
+		waitTime: aws.Int64(int64(*opts.waitTime / time.Second)),
+		limit:    uint64(*opts.numMsgs),
+	}
+
+	// results are written even if the reception failed, so that the
+	// messages received until then aren't lost
+	recs, recvErr := r.receive(ctx, int(*opts.numReceivers), *opts.quietPeriod, stderr)
+
+	out := stdout
+	if *opts.outFile != "" {
+		f, err := os.Create(*opts.outFile)
+		if err != nil {
+			return fmt.Errorf("creating results file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	res := processResults(recs)
+
+	if err := writeResults(out, res); err != nil {
+		return fmt.Errorf("writing results: %w", err)
+	}
+
+	writeSummary(stderr, res, atomic.LoadUint64(&r.deleteFailures), atomic.LoadUint64(&r.receiveFailures))
+
+	if recvErr != nil {
+		return fmt.Errorf("receiving messages: %w", recvErr)
+	}
+
+	return nil
+}
+
+// cmdOpts are the options that can be passed to the command.
+type cmdOpts struct {
+	queueURL     *url.URL
+	numReceivers *uint
+	numMsgs      *uint
+	waitTime     *time.Duration
+	quietPeriod  *time.Duration
+	outFile      *string
+}
+
+// readOpts parses and validates options from commmand-line flags.
+func readOpts(f *flag.FlagSet, args []string) (*cmdOpts, error) {
+	opts := &cmdOpts{}
+	queueURL := f.String("u", "", "URL of the Amazon SQS queue to receive messages from")
+	opts.numReceivers = f.Uint("c", 0, "Number of concurrent receivers polling the queue. 0 = 4 per CPU")
+	opts.numMsgs = f.Uint("n", 0, "Number of messages to receive before stopping. 0 = no limit")
+	opts.waitTime = f.Duration("wait", defaultWaitTime, "Duration for which each receive call waits for "+
+		"messages to arrive (long polling). Maximum "+maxWaitTime.String())
+	opts.quietPeriod = f.Duration("quiet-period", defaultQuietPeriod, "Duration after which the reception "+
+		"stops if no new message was received, once a first message was received. 0 = never stop")
+	opts.outFile = f.String("o", "", "Path of the file to write the results to. Defaults to the standard output")
+
+	err := f.Parse(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if *queueURL == "" {
+		return nil, fmt.Errorf("queue URL isn't set")
+	}
+	if opts.queueURL, err = url.Parse(*queueURL); err != nil {
+		return nil, fmt.Errorf("invalid queue URL: %w", err)
+	}
+
+	if *opts.numReceivers == 0 {
+		*opts.numReceivers = uint(runtime.GOMAXPROCS(-1) * receiversPerProc)
+	}
+
+	if w := *opts.waitTime; w < 0 || w > maxWaitTime || w%time.Second != 0 {
+		return nil, fmt.Errorf("wait time must be a whole number of seconds between 0s and %s", maxWaitTime)
+	}
+
+	return opts, nil
+}
+
+var awsRegionRegexp = regexp.MustCompile(`[a-z]{2}(-gov)?-[a-z]+-\d`)
+
+// parseRegionFromQueueURL reads the AWS region from the SQS queue's URL.
+func parseRegionFromQueueURL(url *url.URL) (region *string) {
+	// The expected host format is "sqs.us-west-2.amazonaws.com/123456789012/MyQueue"
+	subs := strings.Split(url.Host, ".")
+
+	if len(subs) == 4 && awsRegionRegexp.MatchString(subs[1]) {
+		region = &subs[1]
+	}
+
+	return
+}
+
+// Each receiver spends most of its time waiting for the network, so we can
+// run more than one per thread.
+const receiversPerProc = 4
+
+// receiver receives messages from a queue and deletes them.
+type receiver struct {
+	cli      Client
+	queueURL *string
+	waitTime *int64
+
+	// number of messages after which the reception stops, if not 0
+	limit uint64
+
+	// accessed atomically
+	received        uint64
+	deleteFailures  uint64
+	receiveFailures uint64
+	// time of the last reception, in Unix nanoseconds
+	lastReceived int64
+}
+
+// receive runs the given number of concurrent receivers until either the
+// context is cancelled, the receiver's limit is reached, or no message was
+// received during the given quiet period after a first message was received.
+// It reports the progress of the reception to the given writer, and returns
+// the records of all received messages, along with the first error which
+// stopped a receiver, if any.
+func (r *receiver) receive(ctx context.Context, numReceivers int, quietPeriod time.Duration,
+	w io.Writer) ([]msgRecord, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	recs := make([][]msgRecord, numReceivers)
+	errCh := make(chan error, numReceivers)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < numReceivers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			var err error
+			if recs[i], err = r.runReceiver(ctx, cancel); err != nil {
+				errCh <- err
+				cancel()
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	start := time.Now()
+
+	t := time.NewTicker(progressInterval)
+	defer t.Stop()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+
+		case <-t.C:
+			received := atomic.LoadUint64(&r.received)
+			elapsed := time.Since(start)
+
+			fmt.Fprintf(w, "[%s] %d messages received (%.1f msg/s)\n",
+				elapsed.Round(time.Second), received, float64(received)/elapsed.Seconds())
+
+			last := atomic.LoadInt64(&r.lastReceived)
+			if quietPeriod > 0 && last != 0 && time.Since(time.Unix(0, last)) >= quietPeriod {
+				fmt.Fprintf(w, "No message received for %s, stopping\n", quietPeriod)
+				cancel()
+			}
+		}
+	}
+
+	var all []msgRecord
+	for _, rs := range recs {
+		all = append(all, rs...)
+	}
+
+	close(errCh)
+	return all, <-errCh
+}
+
+// runReceiver receives messages and deletes them in batches until the
+// context is cancelled or the receiver's limit is reached, and returns the
+// records of the received messages. It calls stop once the limit is reached.
+// Failed receive calls are retried after an exponential backoff, up to
+// maxReceiveRetries consecutive times, after which the records received so
+// far are returned along with the error.
+// Messages received concurrently by other receivers once the limit is reached
+// are deleted, but not recorded.
+func (r *receiver) runReceiver(ctx context.Context, stop func()) ([]msgRecord, error) {
+	var recs []msgRecord
+
+	in := &sqs.ReceiveMessageInput{
+		QueueUrl:              r.queueURL,
+		MaxNumberOfMessages:   aws.Int64(maxReceiveMessages),
+		WaitTimeSeconds:       r.waitTime,
+		MessageAttributeNames: []*string{aws.String(allMessageAttributes)},
+	}
+
+	var failures int
+
+	for ctx.Err() == nil {
+		out, err := r.cli.ReceiveMessageWithContext(ctx, in)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+
+			atomic.AddUint64(&r.receiveFailures, 1)
+
+			if failures == maxReceiveRetries {
+				return recs, err
+			}
+			sleep(ctx, retryDelay(failures))
+			failures++
+			continue
+		}
+		failures = 0
+
+		if len(out.Messages) == 0 {
+			continue
+		}
+
+		rcvAt := time.Now()
+		atomic.StoreInt64(&r.lastReceived, rcvAt.UnixNano())
+
+		n := atomic.AddUint64(&r.received, uint64(len(out.Messages)))
+
+		msgs := out.Messages
+		if r.limit > 0 && n > r.limit {
+			// other receivers may have reached the limit concurrently
+			excess := n - r.limit
+			if excess > uint64(len(msgs)) {
+				excess = uint64(len(msgs))
+			}
+			msgs = msgs[:uint64(len(msgs))-excess]
+		}
+
+		for _, m := range msgs {
+			recs = append(recs, newMsgRecord(m, rcvAt))
+		}
+
+		// deletions aren't interrupted by a cancellation, so that
+		// received messages aren't received again by a later run
+		r.deleteMessages(out.Messages)
+
+		if r.limit > 0 && n >= r.limit {
+			stop()
+		}
+	}
+
+	return recs, nil
+}
+
+// retryDelay returns the time to wait before the given retry attempt (from 0),
+// which grows exponentially with a random jitter.
+func retryDelay(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+	if d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+var sleep = sleepCtx
+
+// sleepCtx waits for the given duration, or until the context is cancelled.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// deleteMessages deletes the given messages in a single batch request.
+func (r *receiver) deleteMessages(msgs []*sqs.Message) {
+	in := &sqs.DeleteMessageBatchInput{
+		QueueUrl: r.queueURL,
+		Entries:  make([]*sqs.DeleteMessageBatchRequestEntry, len(msgs)),
+	}
+
+	for i, m := range msgs {
+		in.Entries[i] = &sqs.DeleteMessageBatchRequestEntry{
+			Id:            m.MessageId,
+			ReceiptHandle: m.ReceiptHandle,
+		}
+	}
+
+	out, err := r.cli.DeleteMessageBatch(in)
+	switch {
+	case err != nil:
+		atomic.AddUint64(&r.deleteFailures, uint64(len(msgs)))
+	case out != nil:
+		atomic.AddUint64(&r.deleteFailures, uint64(len(out.Failed)))
+	}
+}
+
+// Client is an alias for sqsiface.SQSAPI.
+type Client = sqsiface.SQSAPI
+
+// ClientGetter can obtain SQS clients.
+type ClientGetter interface {
+	Get(region *string) Client
+}
+
+// clientGetter gets SQS clients using a awsclient.ConfigProvider.
+type clientGetter struct {
+	configProvider client.ConfigProvider
+}
+
+// clientGetter implements ClientGetter.
+var _ ClientGetter = (*clientGetter)(nil)
+
+// Get implements ClientGetter.
+func (g *clientGetter) Get(region *string) Client {
+	var cfgs []*aws.Config
+
+	if region != nil {
+		cfgs = append(cfgs, aws.NewConfig().WithRegion(*region))
+	}
+
+	return sqs.New(g.configProvider, cfgs...)
+}
+
+// ClientGetterFunc allows the use of ordinary functions as ClientGetter.
+type ClientGetterFunc func(region *string) Client
+
+// ClientGetterFunc implements ClientGetter.
+var _ ClientGetter = (ClientGetterFunc)(nil)
+
+// Get implements ClientGetter.
+func (f ClientGetterFunc) Get(region *string) Client {
+	return f(region)
+}
@@ -0,0 +1,327 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const tCmd = "test"
+
+func TestReceive(t *testing.T) {
+	const numMsg = 95
+
+	cli := newMockSQSReceiver(numMsg)
+	cg := staticClientGetter(cli)
+
+	resultsFile := filepath.Join(t.TempDir(), "results.csv")
+
+	var stdout, stderr strings.Builder
+
+	err := run(context.Background(), cg, []string{tCmd, "-u=http://queue", "-n", strconv.Itoa(numMsg),
+		"-c", "4", "-o", resultsFile}, &stdout, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if cli.deleted != numMsg {
+		t.Errorf("Expected %d messages to be deleted, got %d", numMsg, cli.deleted)
+	}
+
+	f, err := os.Open(resultsFile)
+	if err != nil {
+		t.Fatal("Failed to open results file:", err)
+	}
+	defer f.Close()
+
+	if rows := countResultRows(t, f); rows != numMsg {
+		t.Errorf("Expected %d rows of results, got %d", numMsg, rows)
+	}
+
+	if out := stderr.String(); !strings.Contains(out, "Received "+strconv.Itoa(numMsg)+" messages") {
+		t.Errorf("Expected a summary to be written, got %q", out)
+	}
+}
+
+func TestReceiveUntilQuiet(t *testing.T) {
+	const numMsg = 20
+
+	cli := newMockSQSReceiver(numMsg)
+	cg := staticClientGetter(cli)
+
+	var stdout, stderr strings.Builder
+
+	err := run(context.Background(), cg, []string{tCmd, "-u=http://queue", "-quiet-period", "500ms"},
+		&stdout, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if cli.deleted != numMsg {
+		t.Errorf("Expected %d messages to be deleted, got %d", numMsg, cli.deleted)
+	}
+
+	if out := stderr.String(); !strings.Contains(out, "No message received for 500ms") {
+		t.Errorf("Expected the reception to stop after a quiet period, got %q", out)
+	}
+}
+
+func TestReceiveWithError(t *testing.T) {
+	sleep = func(context.Context, time.Duration) {}
+	t.Cleanup(func() { sleep = sleepCtx })
+
+	t.Run("receive calls keep failing", func(t *testing.T) {
+		cli := newMockSQSReceiver(0)
+		cli.receiveErr = errors.New("fake error")
+		cg := staticClientGetter(cli)
+
+		var stdout, stderr strings.Builder
+
+		err := run(context.Background(), cg, []string{tCmd, "-u=http://queue", "-c", "1"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "receiving messages: fake error"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+
+		if cli.calls != maxReceiveRetries+1 {
+			t.Errorf("Expected %d receive calls, got %d", maxReceiveRetries+1, cli.calls)
+		}
+	})
+
+	t.Run("receive calls fail transiently", func(t *testing.T) {
+		const numMsg = 50
+
+		cli := newMockSQSReceiver(numMsg)
+		cli.receiveErr = errors.New("fake error")
+		cli.receiveErrCalls = 2
+		cg := staticClientGetter(cli)
+
+		var stdout, stderr strings.Builder
+
+		err := run(context.Background(), cg, []string{tCmd, "-u=http://queue", "-n", strconv.Itoa(numMsg),
+			"-c", "1"}, &stdout, &stderr)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if rows := countResultRows(t, strings.NewReader(stdout.String())); rows != numMsg {
+			t.Errorf("Expected %d rows of results, got %d", numMsg, rows)
+		}
+		if out := stderr.String(); !strings.Contains(out, "2 receive calls failed") {
+			t.Errorf("Expected the failed calls to be reported, got %q", out)
+		}
+	})
+
+	t.Run("results are written after a failure", func(t *testing.T) {
+		const numMsg = 50
+
+		cli := newMockSQSReceiver(numMsg)
+		cli.emptyErr = errors.New("fake error")
+		cg := staticClientGetter(cli)
+
+		var stdout, stderr strings.Builder
+
+		err := run(context.Background(), cg, []string{tCmd, "-u=http://queue", "-c", "1"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		if rows := countResultRows(t, strings.NewReader(stdout.String())); rows != numMsg {
+			t.Errorf("Expected %d rows of results, got %d", numMsg, rows)
+		}
+		if out := stderr.String(); !strings.Contains(out, "Received "+strconv.Itoa(numMsg)+" messages") {
+			t.Errorf("Expected a summary to be written, got %q", out)
+		}
+	})
+}
+
+func TestReceiveLimit(t *testing.T) {
+	const numMsg = 1000
+	const limit = 25
+
+	cli := newMockSQSReceiver(numMsg)
+	cg := staticClientGetter(cli)
+
+	var stdout, stderr strings.Builder
+
+	err := run(context.Background(), cg, []string{tCmd, "-u=http://queue", "-n", strconv.Itoa(limit),
+		"-c", "16"}, &stdout, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if rows := countResultRows(t, strings.NewReader(stdout.String())); rows != limit {
+		t.Errorf("Expected %d rows of results, got %d", limit, rows)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	cli := newMockSQSReceiver(0)
+	cg := staticClientGetter(cli)
+
+	var stdout, stderr strings.Builder
+
+	t.Run("missing -u flag", func(t *testing.T) {
+		err := run(context.Background(), cg, []string{tCmd}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "queue URL isn't set"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("invalid -wait value", func(t *testing.T) {
+		err := run(context.Background(), cg, []string{tCmd, "-u=http://queue", "-wait", "1500ms"}, &stdout, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "wait time must be a whole number of seconds"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+}
+
+// countResultRows returns the number of rows of results read from r.
+func countResultRows(t *testing.T, r io.Reader) int {
+	t.Helper()
+
+	var rows int
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if !strings.HasPrefix(s.Text(), "#") {
+			rows++
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal("Failed to read results:", err)
+	}
+
+	return rows
+}
+
+func staticClientGetter(cli Client) ClientGetterFunc {
+	return func(*string) Client {
+		return cli
+	}
+}
+
+type mockSQSReceiver struct {
+	sqsiface.SQSAPI
+
+	sync.Mutex
+	// messages available in the queue
+	msgs    []*sqs.Message
+	deleted int
+
+	// receive calls fail with receiveErr, only the first receiveErrCalls
+	// times if not 0
+	receiveErr      error
+	receiveErrCalls int
+	// receive calls fail with emptyErr once the queue is empty, if not nil
+	emptyErr error
+	calls    int
+}
+
+// newMockSQSReceiver returns a mockSQSReceiver with the given number of
+// messages in its queue.
+func newMockSQSReceiver(numMsg int) *mockSQSReceiver {
+	m := &mockSQSReceiver{
+		msgs: make([]*sqs.Message, numMsg),
+	}
+
+	for i := range m.msgs {
+		id := strconv.Itoa(i)
+		m.msgs[i] = &sqs.Message{
+			MessageId:     &id,
+			ReceiptHandle: &id,
+			Body:          aws.String("0"),
+		}
+	}
+
+	return m
+}
+
+func (m *mockSQSReceiver) ReceiveMessageWithContext(ctx context.Context, in *sqs.ReceiveMessageInput,
+	_ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+
+	m.Lock()
+
+	m.calls++
+	if m.receiveErr != nil && (m.receiveErrCalls == 0 || m.calls <= m.receiveErrCalls) {
+		m.Unlock()
+		return nil, m.receiveErr
+	}
+	if m.emptyErr != nil && len(m.msgs) == 0 {
+		m.Unlock()
+		return nil, m.emptyErr
+	}
+
+	n := int(aws.Int64Value(in.MaxNumberOfMessages))
+	if n > len(m.msgs) {
+		n = len(m.msgs)
+	}
+
+	out := &sqs.ReceiveMessageOutput{
+		Messages: m.msgs[:n],
+	}
+	m.msgs = m.msgs[n:]
+
+	m.Unlock()
+
+	// simulate a short long poll on an empty queue
+	if n == 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	return out, nil
+}
+
+func (m *mockSQSReceiver) DeleteMessageBatch(in *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	m.Lock()
+	m.deleted += len(in.Entries)
+	m.Unlock()
+
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
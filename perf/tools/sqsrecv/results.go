@@ -0,0 +1,219 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Names of the message attribute and of the attribute of structured
+// CloudEvents which contain the time at which a message was sent, as set by
+// sqssend and cegen.
+const (
+	attrSendTime = "ce-sendtime"
+	extSendTime  = "sendtime"
+)
+
+// Window over which the receive throughput is computed.
+const throughputWindow = time.Second
+
+// msgRecord records the reception of a message.
+type msgRecord struct {
+	rcvAt time.Time
+	// zero if the message doesn't carry a send time
+	sentAt time.Time
+}
+
+// newMsgRecord returns a msgRecord for the given message, received at the
+// given time.
+func newMsgRecord(m *sqs.Message, rcvAt time.Time) msgRecord {
+	return msgRecord{
+		rcvAt:  rcvAt,
+		sentAt: parseSendTime(m),
+	}
+}
+
+// parseSendTime returns the time at which the given message was sent, if the
+// message carries it either as a message attribute or as an attribute of a
+// structured CloudEvent. It returns the zero time otherwise.
+func parseSendTime(m *sqs.Message) time.Time {
+	var sendTime string
+
+	if attr, ok := m.MessageAttributes[attrSendTime]; ok {
+		sendTime = aws.StringValue(attr.StringValue)
+	} else if body := aws.StringValue(m.Body); len(body) > 0 && body[0] == '{' {
+		event := &struct {
+			SendTime string `json:"sendtime"`
+		}{}
+		if err := json.Unmarshal([]byte(body), event); err == nil {
+			sendTime = event.SendTime
+		}
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, sendTime)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// results are the processed records of received messages.
+type results struct {
+	// sorted by reception time
+	recs []msgRecord
+	// receive throughput at the reception of each record
+	thrpt []int
+	// sorted end-to-end latencies of the messages which carry a send time
+	latencies []time.Duration
+}
+
+// processResults returns the results of the given records.
+func processResults(recs []msgRecord) *results {
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].rcvAt.Before(recs[j].rcvAt)
+	})
+
+	res := &results{
+		recs:  recs,
+		thrpt: make([]int, len(recs)),
+	}
+
+	// number of records received within the throughput window preceding
+	// each record
+	var first int
+	for i, r := range recs {
+		for r.rcvAt.Sub(recs[first].rcvAt) >= throughputWindow {
+			first++
+		}
+		res.thrpt[i] = i - first + 1
+
+		if !r.sentAt.IsZero() {
+			res.latencies = append(res.latencies, r.rcvAt.Sub(r.sentAt))
+		}
+	}
+
+	sort.Slice(res.latencies, func(i, j int) bool {
+		return res.latencies[i] < res.latencies[j]
+	})
+
+	return res
+}
+
+// writeResults writes the given results to w in the CSV format of the Mako
+// stub used by thrpt-receiver, so that the same tools can be used to plot
+// them. Each row contains the reception time of a message in milliseconds
+// since the epoch, an empty error message, the receive throughput at that
+// time ("rt"), and the end-to-end latency of the message in milliseconds
+// ("lt") when messages carry a send time.
+func writeResults(w io.Writer, res *results) error {
+	withLatency := len(res.latencies) > 0
+
+	header := "# inputValue,errorMessage,rt"
+	if withLatency {
+		header += ",lt"
+	}
+
+	if _, err := fmt.Fprintf(w, "# Received input\n# Input completed\n# Benchmark  - Message throughput\n%s\n",
+		header); err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+
+	row := make([]string, 3, 4)
+	for i, r := range res.recs {
+		row = row[:3]
+		row[0] = strconv.FormatFloat(msSinceEpoch(r.rcvAt), 'g', -1, 64)
+		row[1] = ""
+		row[2] = strconv.Itoa(res.thrpt[i])
+
+		if withLatency {
+			var lt string
+			if !r.sentAt.IsZero() {
+				lt = strconv.FormatFloat(durationMs(r.rcvAt.Sub(r.sentAt)), 'f', 3, 64)
+			}
+			row = append(row, lt)
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(w, "# CSV end")
+	return err
+}
+
+// writeSummary writes a human-readable summary of the given results to w.
+func writeSummary(w io.Writer, res *results, deleteFailures, receiveFailures uint64) {
+	var elapsed time.Duration
+	if n := len(res.recs); n > 0 {
+		elapsed = res.recs[n-1].rcvAt.Sub(res.recs[0].rcvAt)
+	}
+
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(len(res.recs)) / elapsed.Seconds()
+	}
+
+	fmt.Fprintf(w, "Received %d messages in %s (%.1f msg/s), %d could not be deleted, %d receive calls failed\n",
+		len(res.recs), elapsed.Round(time.Millisecond), rate, deleteFailures, receiveFailures)
+
+	lats := res.latencies
+	if len(lats) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "End-to-end latency of %d messages: min %s, p50 %s, p90 %s, p99 %s, max %s\n",
+		len(lats), lats[0], percentile(lats, 50), percentile(lats, 90), percentile(lats, 99), lats[len(lats)-1])
+}
+
+// percentile returns the p-th percentile of the given sorted durations, using
+// the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// msSinceEpoch returns the number of milliseconds elapsed between the Unix
+// epoch and t.
+func msSinceEpoch(t time.Time) float64 {
+	return float64(t.Unix())*1e3 + float64(t.Nanosecond())/float64(time.Millisecond)
+}
+
+// durationMs returns the given duration in milliseconds.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
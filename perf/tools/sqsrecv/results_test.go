@@ -0,0 +1,143 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestParseSendTime(t *testing.T) {
+	const sendTime = "2021-02-15T10:00:00.123456789Z"
+
+	expect, _ := time.Parse(time.RFC3339Nano, sendTime)
+
+	testCases := []struct {
+		name   string
+		msg    *sqs.Message
+		expect time.Time
+	}{
+		{
+			name: "message attribute",
+			msg: &sqs.Message{
+				Body: aws.String("hello"),
+				MessageAttributes: map[string]*sqs.MessageAttributeValue{
+					attrSendTime: {DataType: aws.String("String"), StringValue: aws.String(sendTime)},
+				},
+			},
+			expect: expect,
+		},
+		{
+			name: "structured event",
+			msg: &sqs.Message{
+				Body: aws.String(`{"specversion":"1.0","id":"0","data":{},"sendtime":"` + sendTime + `"}`),
+			},
+			expect: expect,
+		},
+		{
+			name: "JSON without send time",
+			msg: &sqs.Message{
+				Body: aws.String(`{"hello":"world"}`),
+			},
+		},
+		{
+			name: "raw data",
+			msg: &sqs.Message{
+				Body: aws.String("00000000"),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parseSendTime(tc.msg); !got.Equal(tc.expect) {
+				t.Errorf("Expected %s, got %s", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestProcessResults(t *testing.T) {
+	start := time.Unix(1_600_000_000, 0)
+
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	recs := []msgRecord{
+		{rcvAt: at(1500)},
+		{rcvAt: at(0), sentAt: at(-30)},
+		{rcvAt: at(100), sentAt: at(90)},
+		{rcvAt: at(900)},
+		{rcvAt: at(1000), sentAt: at(980)},
+	}
+
+	res := processResults(recs)
+
+	for i := 1; i < len(res.recs); i++ {
+		if res.recs[i].rcvAt.Before(res.recs[i-1].rcvAt) {
+			t.Fatal("Expected records to be sorted by reception time")
+		}
+	}
+
+	expectThrpt := []int{1, 2, 3, 3, 3}
+	for i := range expectThrpt {
+		if res.thrpt[i] != expectThrpt[i] {
+			t.Fatalf("Expected throughput %v, got %v", expectThrpt, res.thrpt)
+		}
+	}
+
+	expectLats := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond}
+	if len(res.latencies) != len(expectLats) {
+		t.Fatalf("Expected latencies %v, got %v", expectLats, res.latencies)
+	}
+	for i := range expectLats {
+		if res.latencies[i] != expectLats[i] {
+			t.Fatalf("Expected latencies %v, got %v", expectLats, res.latencies)
+		}
+	}
+}
+
+func TestWriteResults(t *testing.T) {
+	start := time.Unix(1_600_000_000, 0)
+
+	res := processResults([]msgRecord{
+		{rcvAt: start, sentAt: start.Add(-5 * time.Millisecond)},
+		{rcvAt: start.Add(250 * time.Millisecond)},
+	})
+
+	var out strings.Builder
+	if err := writeResults(&out, res); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expect := "# Received input\n" +
+		"# Input completed\n" +
+		"# Benchmark  - Message throughput\n" +
+		"# inputValue,errorMessage,rt,lt\n" +
+		"1.6e+12,,1,5.000\n" +
+		"1.60000000025e+12,,2,\n" +
+		"# CSV end\n"
+
+	if got := out.String(); got != expect {
+		t.Errorf("Unexpected results:\n%s\nExpected:\n%s", got, expect)
+	}
+}
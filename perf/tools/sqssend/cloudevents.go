@@ -0,0 +1,171 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Content modes of messages.
+const (
+	// The data is sent as is.
+	modeRaw = "raw"
+	// The body is a CloudEvent in the JSON event format.
+	// https://github.com/cloudevents/spec/blob/v1.0.1/json-format.md
+	modeStructured = "structured"
+	// The body is the data, and CloudEvent attributes are message
+	// attributes.
+	modeBinary = "binary"
+)
+
+const (
+	ceType   = "io.triggermesh.perf.drill"
+	ceSource = "sqssend"
+)
+
+const contentTypeJSON = "application/json"
+
+// Extension attributes which allow receivers to compute the latency and loss
+// of events.
+const (
+	// sequence number of the event
+	extStampSeq = "sequence"
+	// time at which the message was sent
+	extStampTime = "sendtime"
+)
+
+// Message attributes set in binary mode.
+const (
+	attrPrefix      = "ce-"
+	attrContentType = "content-type"
+	attrStampTime   = attrPrefix + extStampTime
+)
+
+// sendTimeLayout is the RFC 3339 layout of send times. Its length is fixed
+// for times in UTC, so that messages can be sized before they are stamped.
+const sendTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// sendTimePlaceholder is the value of the send time of messages which haven't
+// been stamped yet.
+const sendTimePlaceholder = "0000-00-00T00:00:00.000000000Z"
+
+// structuredEventSuffix is the end of structured events, which contains the
+// send time.
+const structuredEventSuffix = `,"` + extStampTime + `":"` + sendTimePlaceholder + `"}`
+
+// stampFunc stamps a message with the time at which it is sent.
+type stampFunc func(msg *sqs.SendMessageBatchRequestEntry, t time.Time)
+
+// stamperFor returns the stampFunc of messages in the given content mode, or
+// nil if messages in this mode aren't stamped.
+func stamperFor(mode string) stampFunc {
+	switch mode {
+	case modeStructured:
+		return stampStructuredEvent
+	case modeBinary:
+		return stampEventAttributes
+	default:
+		return nil
+	}
+}
+
+// stampStructuredEvent replaces the placeholder of the send time in the body
+// of the given message.
+func stampStructuredEvent(msg *sqs.SendMessageBatchRequestEntry, t time.Time) {
+	body := *msg.MessageBody
+	body = body[:len(body)-len(structuredEventSuffix)] +
+		`,"` + extStampTime + `":"` + t.UTC().Format(sendTimeLayout) + `"}`
+	msg.MessageBody = &body
+}
+
+// stampEventAttributes sets the send time attribute of the given message.
+func stampEventAttributes(msg *sqs.SendMessageBatchRequestEntry, t time.Time) {
+	msg.MessageAttributes[attrStampTime].StringValue = aws.String(t.UTC().Format(sendTimeLayout))
+}
+
+// structuredEvent returns a CloudEvent in the JSON event format, with the
+// given ID, sequence number and data. The send time is a placeholder until
+// the event is stamped.
+func (g *msgGenerator) structuredEvent(id, seq, data string) string {
+	var b strings.Builder
+	b.Grow(len(data) + 256)
+
+	b.WriteString(`{"specversion":"1.0","id":`)
+	writeJSONString(&b, id)
+	b.WriteString(`,"type":`)
+	writeJSONString(&b, g.ceType)
+	b.WriteString(`,"source":`)
+	writeJSONString(&b, g.ceSource)
+	b.WriteString(`,"datacontenttype":`)
+	writeJSONString(&b, g.contentType)
+	b.WriteString(`,"` + extStampSeq + `":"`)
+	b.WriteString(seq)
+	b.WriteString(`","data":`)
+	if g.dataIsJSON {
+		b.WriteString(data)
+	} else {
+		writeJSONString(&b, data)
+	}
+	b.WriteString(structuredEventSuffix)
+
+	return b.String()
+}
+
+// eventAttributes returns the message attributes of a message in binary
+// mode, with the given event ID and sequence number. The send time is a
+// placeholder until the message is stamped.
+func (g *msgGenerator) eventAttributes(id, seq string) map[string]*sqs.MessageAttributeValue {
+	return map[string]*sqs.MessageAttributeValue{
+		attrPrefix + "specversion": stringAttribute("1.0"),
+		attrPrefix + "id":          stringAttribute(id),
+		attrPrefix + "type":        stringAttribute(g.ceType),
+		attrPrefix + "source":      stringAttribute(g.ceSource),
+		attrContentType:            stringAttribute(g.contentType),
+		attrPrefix + extStampSeq:   stringAttribute(seq),
+		attrStampTime:              stringAttribute(sendTimePlaceholder),
+	}
+}
+
+// stringAttribute returns a message attribute with the given string value.
+func stringAttribute(val string) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: &val,
+	}
+}
+
+// writeJSONString writes s to b as a JSON string.
+func writeJSONString(b *strings.Builder, s string) {
+	// marshaling a string never fails
+	enc, _ := json.Marshal(s)
+	b.Write(enc)
+}
+
+// isJSONMediaType returns whether the given media type denotes JSON data.
+func isJSONMediaType(mediaType string) bool {
+	mt, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return false
+	}
+	return mt == contentTypeJSON || strings.HasSuffix(mt, "+json")
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestSendStructured(t *testing.T) {
+	const numMsg = 25
+
+	cli := &mockSQSSender{
+		recordBatches: true,
+	}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	start := time.Now()
+
+	err := run(cg, []string{tCmd, "-u=http://queue", "-n", strconv.Itoa(numMsg), "-mode", modeStructured,
+		"-d", `{"hello":"world"}`, "-source", "test-source"}, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var seq int
+
+	for _, b := range cli.batches {
+		for _, e := range b.Entries {
+			event := make(map[string]interface{})
+			if err := json.Unmarshal([]byte(aws.StringValue(e.MessageBody)), &event); err != nil {
+				t.Fatalf("Message body isn't a valid JSON event: %s\n%s", err, *e.MessageBody)
+			}
+
+			if v := event["specversion"]; v != "1.0" {
+				t.Errorf("Unexpected specversion %v", v)
+			}
+			if v := event["type"]; v != ceType {
+				t.Errorf("Unexpected type %v", v)
+			}
+			if v := event["source"]; v != "test-source" {
+				t.Errorf("Unexpected source %v", v)
+			}
+			if v, ok := event["id"].(string); !ok || !strings.HasSuffix(v, "-"+strconv.Itoa(seq)) {
+				t.Errorf("Unexpected id %v", event["id"])
+			}
+			if v := event[extStampSeq]; v != strconv.Itoa(seq) {
+				t.Errorf("Expected sequence %d, got %v", seq, v)
+			}
+			if v, ok := event["data"].(map[string]interface{}); !ok || v["hello"] != "world" {
+				t.Errorf("Expected data to be embedded as JSON, got %v", event["data"])
+			}
+
+			sendTime, err := time.Parse(time.RFC3339Nano, event[extStampTime].(string))
+			if err != nil {
+				t.Fatal("Unexpected send time:", err)
+			}
+			if sendTime.Before(start) || sendTime.After(time.Now()) {
+				t.Errorf("Send time %s isn't within the run of the command", sendTime)
+			}
+
+			seq++
+		}
+	}
+
+	if seq != numMsg {
+		t.Errorf("Expected %d messages to be sent, got %d", numMsg, seq)
+	}
+}
+
+func TestSendBinary(t *testing.T) {
+	cli := &mockSQSSender{
+		recordBatches: true,
+	}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	err := run(cg, []string{tCmd, "-u=http://queue", "-n", "1", "-mode", modeBinary,
+		"-d", "hello", "-datacontenttype", "text/plain"}, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	msg := cli.batches[0].Entries[0]
+
+	if body := aws.StringValue(msg.MessageBody); body != "hello" {
+		t.Errorf("Expected the body to contain the data, got %q", body)
+	}
+
+	expectAttrs := map[string]string{
+		"ce-specversion":    "1.0",
+		"ce-type":           ceType,
+		"ce-source":         ceSource,
+		"content-type":      "text/plain",
+		"ce-" + extStampSeq: "0",
+	}
+	for name, expectVal := range expectAttrs {
+		attr, ok := msg.MessageAttributes[name]
+		if !ok {
+			t.Errorf("Missing message attribute %q", name)
+			continue
+		}
+		if v := aws.StringValue(attr.StringValue); v != expectVal {
+			t.Errorf("Expected attribute %q to be %q, got %q", name, expectVal, v)
+		}
+	}
+
+	sendTime := aws.StringValue(msg.MessageAttributes[attrStampTime].StringValue)
+	if _, err := time.Parse(time.RFC3339Nano, sendTime); err != nil {
+		t.Errorf("Unexpected send time %q: %s", sendTime, err)
+	}
+}
+
+func TestDataTemplate(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "data.tmpl")
+	if err := ioutil.WriteFile(f, []byte(`{"seq":{{.Seq}},"id":"{{.ID}}"}`), 0o644); err != nil {
+		t.Fatal("Failed to write data file:", err)
+	}
+
+	g := newMsgGenerator(mustReadOpts(t, "-u=http://queue", "-d", "@"+f, "-template"))
+
+	for i := 0; i < 3; i++ {
+		msg := g.next()
+
+		data := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(aws.StringValue(msg.MessageBody)), &data); err != nil {
+			t.Fatalf("Rendered data isn't valid JSON: %s\n%s", err, *msg.MessageBody)
+		}
+
+		if v := data["seq"]; v != float64(i) {
+			t.Errorf("Expected seq %d, got %v", i, v)
+		}
+		if v := data["id"]; v != g.runID+"-"+strconv.Itoa(i) {
+			t.Errorf("Unexpected id %v", v)
+		}
+	}
+}
+
+func TestMsgSizeWithAttributes(t *testing.T) {
+	const size = 10
+
+	g := newMsgGenerator(mustReadOpts(t, "-u=http://queue", "-s", strconv.Itoa(size), "-mode", modeBinary))
+	msg := g.next()
+
+	if s := msgSize(msg); s <= size {
+		t.Errorf("Expected message attributes to count towards the message size, got %d B", s)
+	}
+
+	sizeBefore := msgSize(msg)
+	stampEventAttributes(msg, time.Now())
+	if s := msgSize(msg); s != sizeBefore {
+		t.Errorf("Expected the size of the message to remain %d B after stamping, got %d B", sizeBefore, s)
+	}
+
+	g = newMsgGenerator(mustReadOpts(t, "-u=http://queue", "-s", strconv.Itoa(size), "-mode", modeStructured))
+	msg = g.next()
+
+	sizeBefore = msgSize(msg)
+	stampStructuredEvent(msg, time.Now().In(time.FixedZone("UTC+1", 3600)))
+	if s := msgSize(msg); s != sizeBefore {
+		t.Errorf("Expected the size of the message to remain %d B after stamping, got %d B", sizeBefore, s)
+	}
+}
+
+func TestCloudEventArgs(t *testing.T) {
+	cli := &mockSQSSender{}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	t.Run("invalid -mode value", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-mode", "foo"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := `invalid content mode "foo"`
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("CloudEvent attributes in raw mode", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-type", "foo"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "CloudEvent attributes require the structured or binary mode"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("-d with -s", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-d", "foo", "-s", "3"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "data and message size are mutually exclusive"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("missing data file", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-d", "@/does/not/exist"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "reading data from file"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-d", "{{.Seq", "-template"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "parsing data template"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("template with an unknown field", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-d", "{{.Foo}}", "-template"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "rendering data template"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("message attributes exceeding the maximum size", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-s", strconv.Itoa(int(maxMsgSizeBytes)), "-mode", modeBinary}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "exceeds the maximum of"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Each batch processor spends most of its time waiting for the network, so
+// we can run more than one per thread.
+const processorsPerProc = 4
+
+// Adaptive concurrency.
+const (
+	// maximum number of batch processors, unless set explicitly
+	defaultMaxAdaptiveProcs = 256
+	// interval at which the throughput is measured and the number of
+	// batch processors is adjusted
+	adaptInterval = time.Second
+	// minimum relative increase of the throughput for the number of batch
+	// processors to keep growing
+	adaptMinGain = 0.05
+)
+
+// throttlingCodes are error codes which indicate that requests are throttled.
+var throttlingCodes = map[string]struct{}{
+	"RequestThrottled":         {},
+	"ThrottlingException":      {},
+	"Throttling":               {},
+	"TooManyRequestsException": {},
+}
+
+// defaultConcurrency returns the default number of batch processors.
+func defaultConcurrency() int {
+	return runtime.GOMAXPROCS(-1) * processorsPerProc
+}
+
+// concurrency returns the number of batch processors set in the command's
+// options.
+func (o *cmdOpts) concurrency() int {
+	if *o.numProcs == 0 {
+		return defaultConcurrency()
+	}
+	return int(*o.numProcs)
+}
+
+// httpClient returns the HTTP client of SQS clients described by the
+// command's options.
+func (o *cmdOpts) httpClient() *http.Client {
+	maxIdleConns := int(*o.maxIdleConns)
+	if maxIdleConns == 0 {
+		// one idle connection per batch processor, so that connections
+		// are reused instead of being closed after each request
+		maxIdleConns = o.concurrency()
+		if *o.adaptive && *o.numProcs == 0 {
+			maxIdleConns = defaultMaxAdaptiveProcs
+		}
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = maxIdleConns
+	if t.MaxIdleConns < maxIdleConns {
+		t.MaxIdleConns = maxIdleConns
+	}
+
+	return &http.Client{
+		Transport: t,
+		Timeout:   *o.timeout,
+	}
+}
+
+// adaptiveController grows the number of batch processors until the
+// throughput stops improving, or requests get throttled.
+type adaptiveController struct {
+	// accessed atomically
+	sent      uint64
+	throttled uint64
+
+	max int
+	w   io.Writer
+
+	// current and previous number of batch processors
+	n     int
+	prevN int
+	// throughput measured with prevN batch processors
+	bestRate float64
+}
+
+// newAdaptiveController returns an adaptiveController which runs at most max
+// batch processors and reports changes of concurrency to the given writer.
+func newAdaptiveController(max int, w io.Writer) *adaptiveController {
+	return &adaptiveController{
+		max: max,
+		w:   w,
+	}
+}
+
+// record records the outcome of the sending of a batch of the given number of
+// messages.
+func (c *adaptiveController) record(numMsgs int, err error) {
+	if c == nil {
+		return
+	}
+
+	errSend := &errSendBatch{}
+	if !errors.As(err, &errSend) {
+		atomic.AddUint64(&c.sent, uint64(numMsgs))
+		return
+	}
+
+	atomic.AddUint64(&c.sent, uint64(numMsgs-errSend.count))
+
+	if awsErr := awserr.Error(nil); errors.As(errSend.err, &awsErr) && isThrottlingCode(awsErr.Code()) {
+		atomic.AddUint64(&c.throttled, 1)
+	}
+}
+
+// recordFailedEntries records batch entries reported as failed by the API,
+// including entries which are retried.
+func (c *adaptiveController) recordFailedEntries(failed []*sqs.BatchResultErrorEntry) {
+	if c == nil {
+		return
+	}
+
+	for _, f := range failed {
+		if isThrottlingCode(aws.StringValue(f.Code)) {
+			atomic.AddUint64(&c.throttled, 1)
+		}
+	}
+}
+
+// isThrottlingCode returns whether the given error code indicates that a
+// request was throttled.
+func isThrottlingCode(code string) bool {
+	_, ok := throttlingCodes[code]
+	return ok
+}
+
+// run starts batch processors with the given function, and adjusts their
+// number at every adaptInterval until it settles, or done is closed.
+// startProc starts a batch processor which returns when stop is closed.
+func (c *adaptiveController) run(startProc func(stop <-chan struct{}), done <-chan struct{}) {
+	// stop channels of the running processors, in the order in which
+	// they were started
+	var stops []chan struct{}
+
+	scaleTo := func(n int) {
+		for len(stops) < n {
+			stop := make(chan struct{})
+			stops = append(stops, stop)
+			startProc(stop)
+		}
+		for len(stops) > n {
+			close(stops[len(stops)-1])
+			stops = stops[:len(stops)-1]
+		}
+	}
+
+	scaleTo(c.start())
+
+	ticker := time.NewTicker(adaptInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		rate := float64(atomic.SwapUint64(&c.sent, 0)) / adaptInterval.Seconds()
+		throttled := atomic.SwapUint64(&c.throttled, 0) > 0
+
+		n, settled := c.adjust(rate, throttled)
+		scaleTo(n)
+
+		if settled {
+			return
+		}
+	}
+}
+
+// start returns the initial number of batch processors, which is the number
+// of threads.
+func (c *adaptiveController) start() int {
+	c.n = runtime.GOMAXPROCS(-1)
+	if c.n > c.max {
+		c.n = c.max
+	}
+	return c.n
+}
+
+// adjust returns the number of batch processors which should be running,
+// given the throughput measured during the last interval and whether
+// requests were throttled during that interval. The number of processors
+// doubles for as long as the throughput improves, and reverts to its previous
+// value when requests get throttled. The returned boolean is true once the
+// number of processors has settled.
+func (c *adaptiveController) adjust(rate float64, throttled bool) (n int, settled bool) {
+	switch {
+	case throttled:
+		if c.prevN > 0 {
+			c.n = c.prevN
+		}
+		fmt.Fprintf(c.w, "Requests throttled, settling at %d batch processors\n", c.n)
+		return c.n, true
+
+	case rate < c.bestRate*(1+adaptMinGain):
+		fmt.Fprintf(c.w, "Throughput stopped improving (%.1f msg/s), settling at %d batch processors\n",
+			rate, c.n)
+		return c.n, true
+
+	case c.n == c.max:
+		fmt.Fprintf(c.w, "Reached the maximum of %d batch processors (%.1f msg/s)\n", c.n, rate)
+		return c.n, true
+	}
+
+	c.bestRate = rate
+	c.prevN = c.n
+
+	c.n *= 2
+	if c.n > c.max {
+		c.n = c.max
+	}
+
+	fmt.Fprintf(c.w, "Throughput %.1f msg/s, growing to %d batch processors\n", rate, c.n)
+	return c.n, false
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestConcurrency(t *testing.T) {
+	const numProcs = 3
+
+	cli := &concurrencyTracker{
+		mockSQSSender: &mockSQSSender{},
+		delay:         5 * time.Millisecond,
+	}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	err := run(cg, []string{tCmd, "-u=http://queue", "-n", "500", "-c", strconv.Itoa(numProcs)}, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if cli.msgsSent != 500 {
+		t.Errorf("Expected %d messages to be sent, got %d", 500, cli.msgsSent)
+	}
+	if cli.maxInFlight != numProcs {
+		t.Errorf("Expected %d concurrent requests, got %d", numProcs, cli.maxInFlight)
+	}
+}
+
+func TestAdaptiveController(t *testing.T) {
+	const max = 64
+
+	t.Run("grows until the throughput stops improving", func(t *testing.T) {
+		c := newAdaptiveController(max, ioutil.Discard)
+		c.n = 2
+
+		steps := []struct {
+			rate          float64
+			expectN       int
+			expectSettled bool
+		}{
+			{rate: 100, expectN: 4},
+			{rate: 200, expectN: 8},
+			{rate: 203, expectN: 8, expectSettled: true},
+		}
+
+		for i, s := range steps {
+			n, settled := c.adjust(s.rate, false)
+			if n != s.expectN || settled != s.expectSettled {
+				t.Fatalf("Step %d: expected (%d, %t), got (%d, %t)", i, s.expectN, s.expectSettled, n, settled)
+			}
+		}
+	})
+
+	t.Run("reverts when requests get throttled", func(t *testing.T) {
+		c := newAdaptiveController(max, ioutil.Discard)
+		c.n = 2
+
+		if n, _ := c.adjust(100, false); n != 4 {
+			t.Fatalf("Expected to grow to 4 processors, got %d", n)
+		}
+
+		n, settled := c.adjust(200, true)
+		if n != 2 || !settled {
+			t.Fatalf("Expected to settle at 2 processors, got (%d, %t)", n, settled)
+		}
+	})
+
+	t.Run("stops at the maximum", func(t *testing.T) {
+		c := newAdaptiveController(max, ioutil.Discard)
+		c.n = max / 2
+
+		if n, settled := c.adjust(100, false); n != max || settled {
+			t.Fatalf("Expected to grow to %d processors, got (%d, %t)", max, n, settled)
+		}
+		if n, settled := c.adjust(1000, false); n != max || !settled {
+			t.Fatalf("Expected to settle at %d processors, got (%d, %t)", max, n, settled)
+		}
+	})
+}
+
+func TestAdaptiveControllerRecord(t *testing.T) {
+	c := newAdaptiveController(8, ioutil.Discard)
+
+	c.record(10, nil)
+	c.record(10, &errSendBatch{
+		count: 10,
+		err:   awserr.New("RequestThrottled", "request was throttled", nil),
+	})
+	c.record(10, &errSendBatch{
+		count: 4,
+		err:   awserr.New("InternalError", "internal error", nil),
+	})
+
+	if c.sent != 16 {
+		t.Errorf("Expected 16 messages to be recorded as sent, got %d", c.sent)
+	}
+	if c.throttled != 1 {
+		t.Errorf("Expected 1 throttled request, got %d", c.throttled)
+	}
+}
+
+func TestSendAdaptive(t *testing.T) {
+	cli := &mockSQSSender{}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	err := run(cg, []string{tCmd, "-u=http://queue", "-n", "1000", "-adaptive", "-c", "4"}, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if cli.msgsSent != 1000 {
+		t.Errorf("Expected %d messages to be sent, got %d", 1000, cli.msgsSent)
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+	opts := mustReadOpts(t, "-u=http://queue", "-c", "50", "-timeout", "3s")
+
+	cli := opts.httpClient()
+	if cli.Timeout != 3*time.Second {
+		t.Errorf("Expected a timeout of 3s, got %s", cli.Timeout)
+	}
+	if n := cli.Transport.(*http.Transport).MaxIdleConnsPerHost; n != 50 {
+		t.Errorf("Expected 50 idle connections per host, got %d", n)
+	}
+
+	opts = mustReadOpts(t, "-u=http://queue", "-c", "50", "-max-idle-conns", "10")
+
+	if n := opts.httpClient().Transport.(*http.Transport).MaxIdleConnsPerHost; n != 10 {
+		t.Errorf("Expected 10 idle connections per host, got %d", n)
+	}
+}
+
+func TestConcurrencyArgs(t *testing.T) {
+	cli := &mockSQSSender{}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	t.Run("-adaptive with a FIFO queue", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue/MyQueue.fifo", "-adaptive"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "adaptive mode isn't supported by FIFO queues"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+}
+
+// concurrencyTracker is a mockSQSSender which records the maximum number of
+// concurrent requests.
+type concurrencyTracker struct {
+	*mockSQSSender
+
+	delay time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *concurrencyTracker) SendMessageBatch(in *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+
+	time.Sleep(c.delay)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+
+	return c.mockSQSSender.SendMessageBatch(in)
+}
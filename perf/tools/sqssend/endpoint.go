@@ -0,0 +1,93 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// defaultEndpointRegion is the region used to sign requests sent to a custom
+// endpoint, when no region was provided. SQS-compatible emulators such as
+// ElasticMQ and LocalStack accept any region, but the AWS SDK requires one.
+const defaultEndpointRegion = "us-east-1"
+
+// awsHostSuffixes are the suffixes of the hosts of AWS endpoints, in the
+// standard and China partitions.
+var awsHostSuffixes = []string{
+	".amazonaws.com",
+	".amazonaws.com.cn",
+}
+
+// awsConfig returns the configuration of SQS clients described by the
+// command's options.
+func (o *cmdOpts) awsConfig() *aws.Config {
+	cfg := aws.NewConfig().WithHTTPClient(o.httpClient())
+
+	endpoint := o.endpoint
+	if endpoint == nil {
+		endpoint = endpointFromQueueURL(o.queueURL)
+	}
+	if endpoint != nil {
+		cfg.WithEndpoint(endpoint.String())
+	}
+
+	region := parseRegionFromQueueURL(o.queueURL)
+	if *o.region != "" {
+		region = o.region
+	}
+	if region == nil && endpoint != nil {
+		region = aws.String(defaultEndpointRegion)
+	}
+	if region != nil {
+		cfg.WithRegion(*region)
+	}
+
+	if *o.accessKeyID != "" {
+		cfg.WithCredentials(credentials.NewStaticCredentials(*o.accessKeyID, *o.secretAccessKey, ""))
+	}
+
+	return cfg
+}
+
+// endpointFromQueueURL returns the URL of the endpoint which serves the given
+// queue, if the queue isn't hosted on AWS. For example, the endpoint of the
+// ElasticMQ queue "http://localhost:9324/000000000000/MyQueue" is
+// "http://localhost:9324".
+func endpointFromQueueURL(queueURL *url.URL) *url.URL {
+	if queueURL.Host == "" || isAWSHost(queueURL.Hostname()) {
+		return nil
+	}
+
+	return &url.URL{
+		Scheme: queueURL.Scheme,
+		Host:   queueURL.Host,
+	}
+}
+
+// isAWSHost returns whether the given host belongs to an AWS endpoint.
+func isAWSHost(host string) bool {
+	for _, suffix := range awsHostSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestSendToEmulator(t *testing.T) {
+	const numMsg = 25
+
+	srv := newFakeSQSServer()
+	defer srv.Close()
+
+	queueURL := srv.URL + "/000000000000/MyQueue"
+
+	cg := &clientGetter{configProvider: session.Must(session.NewSession())}
+
+	var stderr strings.Builder
+
+	err := run(cg, []string{tCmd, "-u=" + queueURL, "-n", strconv.Itoa(numMsg), "-mode", modeBinary,
+		"-access-key-id=fake", "-secret-access-key=fake"}, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.numReq != numMsg/maxBatchEntries+1 {
+		t.Errorf("Expected %d requests to be received, got %d", numMsg/maxBatchEntries+1, srv.numReq)
+	}
+
+	if n := len(srv.msgs); n != numMsg {
+		t.Fatalf("Expected %d messages to be received, got %d", numMsg, n)
+	}
+
+	for _, m := range srv.msgs {
+		if m.queueURL != queueURL {
+			t.Errorf("Expected message to be sent to queue %q, got %q", queueURL, m.queueURL)
+		}
+		if len(m.body) != defaultMsgSizeBytes {
+			t.Errorf("Expected a body of %d B, got %d B", defaultMsgSizeBytes, len(m.body))
+		}
+		if _, ok := m.attrs[attrStampTime]; !ok {
+			t.Errorf("Message is missing the %q attribute. Attributes: %v", attrStampTime, m.attrs)
+		}
+	}
+}
+
+func TestAWSConfig(t *testing.T) {
+	testCases := []struct {
+		name           string
+		args           []string
+		expectEndpoint *string
+		expectRegion   *string
+		expectCreds    bool
+	}{
+		{
+			name:         "AWS queue URL",
+			args:         []string{"-u=https://sqs.us-west-2.amazonaws.com/123456789012/MyQueue"},
+			expectRegion: aws.String("us-west-2"),
+		},
+		{
+			name:         "AWS queue URL with region override",
+			args:         []string{"-u=https://sqs.us-west-2.amazonaws.com/123456789012/MyQueue", "-region=eu-west-1"},
+			expectRegion: aws.String("eu-west-1"),
+		},
+		{
+			name:         "AWS China queue URL",
+			args:         []string{"-u=https://sqs.cn-north-1.amazonaws.com.cn/123456789012/MyQueue"},
+			expectRegion: aws.String("cn-north-1"),
+		},
+		{
+			name: "legacy AWS queue URL",
+			args: []string{"-u=https://queue.amazonaws.com/123456789012/MyQueue"},
+		},
+		{
+			name:           "emulator queue URL",
+			args:           []string{"-u=http://localhost:9324/000000000000/MyQueue"},
+			expectEndpoint: aws.String("http://localhost:9324"),
+			expectRegion:   aws.String(defaultEndpointRegion),
+		},
+		{
+			name: "explicit endpoint and credentials",
+			args: []string{"-u=https://sqs.us-west-2.amazonaws.com/123456789012/MyQueue",
+				"-endpoint=http://localstack:4566", "-access-key-id=test", "-secret-access-key=test"},
+			expectEndpoint: aws.String("http://localstack:4566"),
+			expectRegion:   aws.String("us-west-2"),
+			expectCreds:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := mustReadOpts(t, tc.args...).awsConfig()
+
+			if e, g := aws.StringValue(tc.expectEndpoint), aws.StringValue(cfg.Endpoint); e != g {
+				t.Errorf("Expected endpoint %q, got %q", e, g)
+			}
+			if e, g := aws.StringValue(tc.expectRegion), aws.StringValue(cfg.Region); e != g {
+				t.Errorf("Expected region %q, got %q", e, g)
+			}
+			if hasCreds := cfg.Credentials != nil; hasCreds != tc.expectCreds {
+				t.Errorf("Expected credentials to be set: %t, got %t", tc.expectCreds, hasCreds)
+			}
+		})
+	}
+}
+
+func TestEndpointArgs(t *testing.T) {
+	cli := &mockSQSSender{}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	t.Run("-endpoint without host", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-endpoint", "localhost"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "must include a scheme and a host"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("-access-key-id without -secret-access-key", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-access-key-id", "test"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "access key ID and secret access key must be set together"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+}
+
+// fakeSQSServer is a HTTP server which implements the SendMessageBatch action
+// of the Amazon SQS query API.
+type fakeSQSServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	numReq int
+	msgs   []fakeSQSMessage
+}
+
+// fakeSQSMessage is a message received by a fakeSQSServer.
+type fakeSQSMessage struct {
+	queueURL string
+	body     string
+	attrs    map[string]string
+}
+
+// newFakeSQSServer returns a started fakeSQSServer. Callers are responsible
+// for closing it.
+func newFakeSQSServer() *fakeSQSServer {
+	s := &fakeSQSServer{}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *fakeSQSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if a := r.PostForm.Get("Action"); a != "SendMessageBatch" {
+		http.Error(w, "unsupported action "+a, http.StatusBadRequest)
+		return
+	}
+
+	const entryPrefix = "SendMessageBatchRequestEntry."
+
+	resp := sendMessageBatchResponse{}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.numReq++
+
+	for i := 1; ; i++ {
+		entry := entryPrefix + strconv.Itoa(i) + "."
+
+		id := r.PostForm.Get(entry + "Id")
+		if id == "" {
+			break
+		}
+
+		msg := fakeSQSMessage{
+			queueURL: r.PostForm.Get("QueueUrl"),
+			body:     r.PostForm.Get(entry + "MessageBody"),
+			attrs:    make(map[string]string),
+		}
+
+		for j := 1; ; j++ {
+			attr := entry + "MessageAttribute." + strconv.Itoa(j) + "."
+
+			name := r.PostForm.Get(attr + "Name")
+			if name == "" {
+				break
+			}
+			msg.attrs[name] = r.PostForm.Get(attr + "Value.StringValue")
+		}
+
+		s.msgs = append(s.msgs, msg)
+
+		sum := md5.Sum([]byte(msg.body))
+		resp.Entries = append(resp.Entries, sendMessageBatchResultEntry{
+			ID:        id,
+			MessageID: strconv.Itoa(len(s.msgs)),
+			MD5OfBody: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	_ = xml.NewEncoder(w).Encode(&resp)
+}
+
+// sendMessageBatchResponse is the XML response of the SendMessageBatch action.
+type sendMessageBatchResponse struct {
+	XMLName xml.Name                      `xml:"SendMessageBatchResponse"`
+	Entries []sendMessageBatchResultEntry `xml:"SendMessageBatchResult>SendMessageBatchResultEntry"`
+}
+
+// sendMessageBatchResultEntry is a successful entry of a
+// sendMessageBatchResponse.
+type sendMessageBatchResultEntry struct {
+	ID        string `xml:"Id"`
+	MessageID string `xml:"MessageId"`
+	MD5OfBody string `xml:"MD5OfMessageBody"`
+}
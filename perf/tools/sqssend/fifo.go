@@ -0,0 +1,144 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// FIFO queues support up to 300 API calls per second, per API method, unless
+// high throughput is enabled.
+// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/SQSDeveloperGuide/quotas-messages.html
+const defaultFIFOTPS = 300
+
+// Strategies used to distribute messages across message groups.
+const (
+	distRoundRobin = "roundrobin"
+	distRandom     = "random"
+)
+
+// isFIFOQueue returns whether the queue with the given URL is a FIFO queue.
+func isFIFOQueue(queueURL *url.URL) bool {
+	return strings.HasSuffix(queueURL.Path, ".fifo")
+}
+
+// groupPicker picks the message group of each message.
+type groupPicker struct {
+	groups []string
+	dist   string
+
+	pos  int
+	rand *rand.Rand
+}
+
+// newGroupPicker returns a groupPicker which distributes messages across the
+// given number of groups, with the given strategy.
+func newGroupPicker(numGroups uint, dist string) *groupPicker {
+	groups := make([]string, numGroups)
+	for i := range groups {
+		groups[i] = "group-" + strconv.Itoa(i)
+	}
+
+	return &groupPicker{
+		groups: groups,
+		dist:   dist,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the message group of the next message.
+func (p *groupPicker) next() *string {
+	if p.dist == distRandom {
+		return &p.groups[p.rand.Intn(len(p.groups))]
+	}
+
+	g := &p.groups[p.pos]
+	p.pos = (p.pos + 1) % len(p.groups)
+	return g
+}
+
+// dispatchByGroup dispatches the batches read from batchCh to the given number
+// of channels, so that all batches of a message group are written to the same
+// channel. The returned channels are closed once batchCh is closed.
+func dispatchByGroup(batchCh <-chan *sqs.SendMessageBatchInput, n int) []<-chan *sqs.SendMessageBatchInput {
+	chs := make([]chan *sqs.SendMessageBatchInput, n)
+	outChs := make([]<-chan *sqs.SendMessageBatchInput, n)
+	for i := range chs {
+		chs[i] = make(chan *sqs.SendMessageBatchInput)
+		outChs[i] = chs[i]
+	}
+
+	go func() {
+		for b := range batchCh {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(aws.StringValue(b.Entries[0].MessageGroupId)))
+			chs[h.Sum32()%uint32(n)] <- b
+		}
+
+		for _, ch := range chs {
+			close(ch)
+		}
+	}()
+
+	return outChs
+}
+
+// limitRate returns a batchProducer which writes the batches of the given
+// producer within the throughput limit of FIFO queues, if the queue is a FIFO
+// queue.
+func (o *cmdOpts) limitRate(produce batchProducer) batchProducer {
+	if !o.fifo || *o.fifoTPS == 0 {
+		return produce
+	}
+	return limitBatchRate(produce, *o.fifoTPS)
+}
+
+// limitBatchRate returns a batchProducer which writes the batches of the given
+// producer at a rate of at most tps batches per second.
+func limitBatchRate(produce batchProducer, tps uint) batchProducer {
+	return func(ctx context.Context, batchCh chan<- *sqs.SendMessageBatchInput) {
+		unlimitedCh := make(chan *sqs.SendMessageBatchInput)
+
+		go func() {
+			produce(ctx, unlimitedCh)
+			close(unlimitedCh)
+		}()
+
+		bucket := newTokenBucket(float64(tps), 1)
+
+		for b := range unlimitedCh {
+			if _, ok := bucket.take(ctx, 1); !ok {
+				return
+			}
+
+			select {
+			case batchCh <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
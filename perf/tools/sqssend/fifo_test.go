@@ -0,0 +1,244 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestSendFIFO(t *testing.T) {
+	const numMsg = 100
+	const numGroups = 4
+
+	cli := &mockSQSSender{
+		recordBatches: true,
+	}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	err := run(cg, []string{tCmd, "-u=https://sqs.us-west-2.amazonaws.com/123456789012/MyQueue.fifo",
+		"-n", strconv.Itoa(numMsg), "-groups", strconv.Itoa(numGroups)}, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if cli.msgsSent != numMsg {
+		t.Fatalf("Expected %d messages to be sent, got %d", numMsg, cli.msgsSent)
+	}
+
+	dedupIDs := make(map[string]struct{}, numMsg)
+	// last sequence number sent in each group
+	lastSeqs := make(map[string]int, numGroups)
+
+	for _, b := range cli.batches {
+		group := aws.StringValue(b.Entries[0].MessageGroupId)
+
+		for _, e := range b.Entries {
+			if g := aws.StringValue(e.MessageGroupId); g != group {
+				t.Fatalf("Expected all messages of a batch to belong to group %q, got %q", group, g)
+			}
+
+			dedupID := aws.StringValue(e.MessageDeduplicationId)
+			if dedupID == "" {
+				t.Fatal("Message has no deduplication ID")
+			}
+			if _, isDup := dedupIDs[dedupID]; isDup {
+				t.Fatalf("Duplicate deduplication ID %q", dedupID)
+			}
+			dedupIDs[dedupID] = struct{}{}
+
+			seq, err := strconv.Atoi(*e.Id)
+			if err != nil {
+				t.Fatal("Unexpected message ID:", *e.Id)
+			}
+			if last, ok := lastSeqs[group]; ok && seq < last {
+				t.Errorf("Message %d of group %q was sent after message %d", seq, group, last)
+			}
+			lastSeqs[group] = seq
+		}
+	}
+
+	if len(lastSeqs) != numGroups {
+		t.Errorf("Expected messages to be distributed across %d groups, got %d", numGroups, len(lastSeqs))
+	}
+}
+
+func TestSendFIFOWithFailedEntries(t *testing.T) {
+	sleep = func(time.Duration) {}
+	t.Cleanup(func() { sleep = time.Sleep })
+
+	// entries 0-4 belong to group-a, entries 5-9 to group-b
+	newBatch := func() *sqs.SendMessageBatchInput {
+		b := &sqs.SendMessageBatchInput{QueueUrl: aws.String("http://queue/MyQueue.fifo")}
+		for i := 0; i < maxBatchEntries; i++ {
+			group := "group-a"
+			if i >= maxBatchEntries/2 {
+				group = "group-b"
+			}
+			b.Entries = append(b.Entries, &sqs.SendMessageBatchRequestEntry{
+				Id:             aws.String(strconv.Itoa(i)),
+				MessageBody:    aws.String("msg"),
+				MessageGroupId: aws.String(group),
+			})
+		}
+		return b
+	}
+
+	t.Run("entry followed by an accepted entry of its group", func(t *testing.T) {
+		// entry 1 fails, entry 2 of the same group is accepted
+		cli := &entryFailer{
+			mockSQSSender: &mockSQSSender{recordBatches: true},
+			failIDs:       map[string]struct{}{"1": {}},
+		}
+
+		err := (&batchSender{cli: cli}).sendBatch(newBatch())
+		if err == nil {
+			t.Fatal("Expected sending to fail")
+		}
+
+		if len(cli.batches) != 1 {
+			t.Errorf("Expected the failed entry not to be retried, got %d requests", len(cli.batches))
+		}
+
+		errSend := &errSendBatch{}
+		if !errors.As(err, &errSend) || errSend.count != 1 {
+			t.Errorf("Expected 1 failed message, got %v", err)
+		}
+	})
+
+	t.Run("trailing entries of a group", func(t *testing.T) {
+		// entries 3 and 4 fail, they are the last ones of group-a
+		cli := &entryFailer{
+			mockSQSSender: &mockSQSSender{recordBatches: true},
+			failIDs:       map[string]struct{}{"3": {}, "4": {}},
+		}
+
+		if err := (&batchSender{cli: cli}).sendBatch(newBatch()); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if len(cli.batches) != 2 {
+			t.Fatalf("Expected the failed entries to be retried in 1 request, got %d requests", len(cli.batches))
+		}
+
+		var ids []string
+		for _, e := range cli.batches[1].Entries {
+			ids = append(ids, *e.Id)
+		}
+		if got := strings.Join(ids, ","); got != "3,4" {
+			t.Errorf("Expected entries 3,4 to be retried in order, got %s", got)
+		}
+	})
+}
+
+func TestGroupPicker(t *testing.T) {
+	t.Run(distRoundRobin, func(t *testing.T) {
+		p := newGroupPicker(3, distRoundRobin)
+
+		var got []string
+		for i := 0; i < 4; i++ {
+			got = append(got, *p.next())
+		}
+
+		expect := "group-0,group-1,group-2,group-0"
+		if gotStr := strings.Join(got, ","); gotStr != expect {
+			t.Errorf("Expected groups %s, got %s", expect, gotStr)
+		}
+	})
+
+	t.Run(distRandom, func(t *testing.T) {
+		p := newGroupPicker(3, distRandom)
+
+		for i := 0; i < 100; i++ {
+			g := *p.next()
+			if g != "group-0" && g != "group-1" && g != "group-2" {
+				t.Fatalf("Unexpected group %q", g)
+			}
+		}
+	})
+}
+
+func TestFIFOArgs(t *testing.T) {
+	cli := &mockSQSSender{}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	t.Run("-groups with a standard queue", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue/MyQueue", "-groups", "2"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "only supported by FIFO queues"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("invalid -group-distribution value", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue/MyQueue.fifo", "-group-distribution", "hash"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := `invalid group distribution strategy "hash"`
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+}
+
+// entryFailer is a mockSQSSender which reports the entries with the given IDs
+// as throttled the first time they are sent, and accepts all other entries.
+type entryFailer struct {
+	*mockSQSSender
+
+	failIDs map[string]struct{}
+}
+
+func (m *entryFailer) SendMessageBatch(in *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	out, err := m.mockSQSSender.SendMessageBatch(in)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = &sqs.SendMessageBatchOutput{}
+	}
+
+	for _, e := range in.Entries {
+		if _, ok := m.failIDs[*e.Id]; !ok {
+			continue
+		}
+		delete(m.failIDs, *e.Id)
+
+		out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{
+			Id:   e.Id,
+			Code: aws.String("RequestThrottled"),
+		})
+	}
+
+	return out, nil
+}
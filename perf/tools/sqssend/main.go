@@ -17,16 +17,25 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
-	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
@@ -38,14 +47,32 @@ import (
 const (
 	// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/SQSDeveloperGuide/sqs-batch-api-actions.html
 	maxBatchSizeBytes = 256 * 1024 // 256 KiB
-	msgBatchSize      = 8
+	maxBatchEntries   = 10
 
 	defaultMsgSizeBytes = 2 * 1024 // 2 KiB
 	defaultNumMsgs      = 100
 
-	maxMsgSizeBytes uint = maxBatchSizeBytes / msgBatchSize // 32 KiB
+	// a single message can fill an entire batch
+	maxMsgSizeBytes uint = maxBatchSizeBytes // 256 KiB
 )
 
+// Retries of batch entries which failed with a retryable error.
+const (
+	maxSendRetries = 5
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+// retryableEntryCodes are error codes of failed batch entries which denote a
+// transient condition on the server side.
+var retryableEntryCodes = map[string]struct{}{
+	"RequestThrottled":    {},
+	"ThrottlingException": {},
+	"ServiceUnavailable":  {},
+	"InternalError":       {},
+	"InternalFailure":     {},
+}
+
 func main() {
 	cg := &clientGetter{configProvider: session.Must(session.NewSession())}
 
@@ -66,9 +93,63 @@ func run(cg ClientGetter, args []string, stderr io.Writer) error {
 		return fmt.Errorf("reading options: %w", err)
 	}
 
-	cli := cg.Get(parseRegionFromQueueURL(opts.queueURL))
+	s := &batchSender{
+		cli:      cg.Get(opts.awsConfig()),
+		stamp:    stamperFor(*opts.mode),
+		numProcs: opts.concurrency(),
+	}
 
-	return sendMsgBatches(cli, prepareMsgBatches(opts))
+	if *opts.adaptive {
+		max := int(*opts.numProcs)
+		if max == 0 {
+			max = defaultMaxAdaptiveProcs
+		}
+		s.adapt = newAdaptiveController(max, stderr)
+	}
+
+	if *opts.summary != "" {
+		s.stats = newRunStats()
+	}
+
+	if *opts.rate > 0 {
+		s.stats.begin()
+		err = sendSustained(s, opts, stderr)
+	} else {
+		produce := opts.limitRate(batchList(prepareMsgBatches(opts)))
+		s.stats.begin()
+		err = s.send(produce)
+	}
+
+	s.stats.finish()
+
+	if s.stats != nil {
+		// a summary is written even if some messages couldn't be sent
+		if errSum := writeSummary(s.stats.summary(), opts); errSum != nil && err == nil {
+			return fmt.Errorf("writing summary: %w", errSum)
+		}
+	}
+
+	return err
+}
+
+// writeSummary writes the given summary to the destination set in the
+// command's options.
+func writeSummary(sum *runSummary, o *cmdOpts) error {
+	if *o.summaryFile == "" {
+		return sum.write(os.Stdout, *o.summary)
+	}
+
+	f, err := os.Create(*o.summaryFile)
+	if err != nil {
+		return err
+	}
+
+	if err := sum.write(f, *o.summary); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
 }
 
 // cmdOpts are the options that can be passed to the command.
@@ -76,6 +157,35 @@ type cmdOpts struct {
 	queueURL *url.URL
 	numMsgs  *uint
 	msgSize  *uint
+
+	mode        *string
+	data        []byte
+	tmpl        *template.Template
+	ceType      *string
+	ceSource    *string
+	contentType *string
+
+	rate     *uint
+	duration *time.Duration
+
+	// FIFO queues
+	fifo      bool
+	groups    *uint
+	groupDist *string
+	fifoTPS   *uint
+
+	endpoint        *url.URL
+	region          *string
+	accessKeyID     *string
+	secretAccessKey *string
+
+	summary     *string
+	summaryFile *string
+
+	numProcs     *uint
+	adaptive     *bool
+	maxIdleConns *uint
+	timeout      *time.Duration
 }
 
 // readOpts parses and validates options from commmand-line flags.
@@ -84,6 +194,48 @@ func readOpts(f *flag.FlagSet, args []string) (*cmdOpts, error) {
 	queueURL := f.String("u", "", "URL of the Amazon SQS queue to send messages to")
 	opts.numMsgs = f.Uint("n", defaultNumMsgs, "Number of messages to send")
 	opts.msgSize = f.Uint("s", defaultMsgSizeBytes, "Size of the messages in bytes")
+	opts.mode = f.String("mode", modeRaw, "Content mode of messages. "+modeRaw+" sends the data as is, "+
+		modeStructured+" sends CloudEvents in the JSON event format, "+modeBinary+" sends the data with "+
+		"CloudEvent attributes as message attributes. One of ["+modeRaw+", "+modeStructured+", "+modeBinary+"]")
+	data := f.String("d", "", "Data to send in messages instead of a payload of the size set by -s. "+
+		"Prefix with '@' to read from a file")
+	tmpl := f.Bool("template", false, "Interpret the data as a Go template, rendered for each message with "+
+		"the fields .Seq (sequence number) and .ID (event ID)")
+	opts.ceType = f.String("type", ceType, "Value to set as the CloudEvent type context attribute")
+	opts.ceSource = f.String("source", ceSource, "Value to set as the CloudEvent source context attribute")
+	opts.contentType = f.String("datacontenttype", contentTypeJSON, "Value to set as the CloudEvent "+
+		"datacontenttype context attribute")
+	opts.rate = f.Uint("rate", 0, "Number of messages to send per second, during the time set by -duration. "+
+		"0 = send the number of messages set by -n in a single burst")
+	opts.duration = f.Duration("duration", 0, "Duration of the sending of messages at the rate set by -rate")
+	opts.groups = f.Uint("groups", 1, "Number of message groups which messages are distributed across, "+
+		"in FIFO queues")
+	opts.groupDist = f.String("group-distribution", distRoundRobin, "Strategy used to distribute messages "+
+		"across message groups, in FIFO queues. One of ["+distRoundRobin+", "+distRandom+"]")
+	opts.fifoTPS = f.Uint("fifo-tps", defaultFIFOTPS, "Maximum number of batch requests per second sent to "+
+		"FIFO queues. Can be raised for queues in high throughput mode. 0 = unlimited")
+
+	endpoint := f.String("endpoint", "", "URL of the endpoint of an SQS-compatible service to send messages to, "+
+		"such as a local emulator. Defaults to the scheme and host of the queue URL if it isn't an AWS URL")
+	opts.region = f.String("region", "", "AWS region of the queue. Defaults to the region parsed from the "+
+		"queue URL, or "+defaultEndpointRegion+" with a custom endpoint")
+	opts.accessKeyID = f.String("access-key-id", "", "Access key ID used to sign requests, instead of the "+
+		"credentials of the default AWS credentials chain. Requires -secret-access-key")
+	opts.secretAccessKey = f.String("secret-access-key", "", "Secret access key used to sign requests. "+
+		"Requires -access-key-id")
+
+	opts.summary = f.String("summary", "", "Format of a summary of the run written at the end of the run. "+
+		"One of ["+summaryJSON+", "+summaryCSV+"]. Empty = no summary")
+	opts.summaryFile = f.String("summary-file", "", "Path of the file to write the summary to. "+
+		"Defaults to the standard output")
+
+	opts.numProcs = f.Uint("c", 0, "Number of batch requests sent concurrently. Maximum number of concurrent "+
+		"requests in adaptive mode. 0 = 4 per CPU, or "+strconv.Itoa(defaultMaxAdaptiveProcs)+" in adaptive mode")
+	opts.adaptive = f.Bool("adaptive", false, "Increase the number of concurrent batch requests gradually, "+
+		"until the throughput stops improving or requests get throttled")
+	opts.maxIdleConns = f.Uint("max-idle-conns", 0, "Maximum number of idle HTTP connections kept open to the "+
+		"SQS endpoint. 0 = as many as concurrent batch requests")
+	opts.timeout = f.Duration("timeout", 0, "Timeout of each HTTP request sent to the SQS endpoint. 0 = no timeout")
 
 	err := f.Parse(args[1:])
 	if err != nil {
@@ -97,97 +249,401 @@ func readOpts(f *flag.FlagSet, args []string) (*cmdOpts, error) {
 		return nil, fmt.Errorf("invalid queue URL: %w", err)
 	}
 
-	if s := *opts.msgSize; s > maxMsgSizeBytes {
+	if *endpoint != "" {
+		if opts.endpoint, err = url.Parse(*endpoint); err != nil {
+			return nil, fmt.Errorf("invalid endpoint URL: %w", err)
+		}
+		if opts.endpoint.Scheme == "" || opts.endpoint.Host == "" {
+			return nil, fmt.Errorf("endpoint URL %q must include a scheme and a host", *endpoint)
+		}
+	}
+
+	if (*opts.accessKeyID == "") != (*opts.secretAccessKey == "") {
+		return nil, fmt.Errorf("access key ID and secret access key must be set together")
+	}
+
+	switch *opts.summary {
+	case "", summaryJSON, summaryCSV:
+	default:
+		return nil, fmt.Errorf("invalid summary format %q", *opts.summary)
+	}
+	if *opts.summaryFile != "" && *opts.summary == "" {
+		return nil, fmt.Errorf("summary file requires a summary format to be set")
+	}
+
+	opts.fifo = isFIFOQueue(opts.queueURL)
+
+	if opts.fifo {
+		if *opts.groups == 0 {
+			return nil, fmt.Errorf("number of message groups must be greater than 0")
+		}
+		switch *opts.groupDist {
+		case distRoundRobin, distRandom:
+		default:
+			return nil, fmt.Errorf("invalid group distribution strategy %q", *opts.groupDist)
+		}
+		if *opts.adaptive {
+			return nil, fmt.Errorf("adaptive mode isn't supported by FIFO queues")
+		}
+	} else if isFlagSet(f, "groups") || isFlagSet(f, "group-distribution") || isFlagSet(f, "fifo-tps") {
+		return nil, fmt.Errorf("message groups and FIFO throughput are only supported by FIFO queues")
+	}
+
+	switch *opts.mode {
+	case modeRaw:
+		if isFlagSet(f, "type") || isFlagSet(f, "source") || isFlagSet(f, "datacontenttype") {
+			return nil, fmt.Errorf("CloudEvent attributes require the %s or %s mode", modeStructured, modeBinary)
+		}
+	case modeStructured, modeBinary:
+	default:
+		return nil, fmt.Errorf("invalid content mode %q", *opts.mode)
+	}
+
+	if *data != "" {
+		if isFlagSet(f, "s") {
+			return nil, fmt.Errorf("data and message size are mutually exclusive")
+		}
+
+		opts.data = []byte(*data)
+
+		if strings.HasPrefix(*data, "@") {
+			if opts.data, err = ioutil.ReadFile(strings.TrimPrefix(*data, "@")); err != nil {
+				return nil, fmt.Errorf("reading data from file: %w", err)
+			}
+		}
+	}
+
+	if *tmpl {
+		if opts.tmpl, err = parseDataTemplate(opts.data); err != nil {
+			return nil, err
+		}
+	}
+
+	// the size of messages with a payload of the size set by -s may
+	// exceed that size in CloudEvent modes
+	if s := msgSize(newMsgGenerator(opts).next()); s > int(maxMsgSizeBytes) {
 		return nil, fmt.Errorf("message size %d B exceeds the maximum of %d B", s, maxMsgSizeBytes)
 	}
 
+	if *opts.rate > 0 {
+		if *opts.duration <= 0 {
+			return nil, fmt.Errorf("duration must be greater than 0 when a rate is set")
+		}
+		if isFlagSet(f, "n") {
+			return nil, fmt.Errorf("number of messages and rate are mutually exclusive")
+		}
+	} else if *opts.duration != 0 {
+		return nil, fmt.Errorf("duration requires a rate to be set")
+	}
+
 	return opts, nil
 }
 
+// isFlagSet returns whether the flag with the given name was set on the
+// command line.
+func isFlagSet(f *flag.FlagSet, name string) bool {
+	var isSet bool
+	f.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			isSet = true
+		}
+	})
+	return isSet
+}
+
 var awsRegionRegexp = regexp.MustCompile(`[a-z]{2}(-gov)?-[a-z]+-\d`)
 
 // parseRegionFromQueueURL reads the AWS region from the SQS queue's URL.
 func parseRegionFromQueueURL(url *url.URL) (region *string) {
-	// The expected host format is "sqs.us-west-2.amazonaws.com/123456789012/MyQueue"
+	// The expected host format is "sqs.us-west-2.amazonaws.com/123456789012/MyQueue",
+	// or "sqs.cn-north-1.amazonaws.com.cn/123456789012/MyQueue" in China
 	subs := strings.Split(url.Host, ".")
 
-	if len(subs) == 4 && awsRegionRegexp.MatchString(subs[1]) {
+	if (len(subs) == 4 || len(subs) == 5 && subs[4] == "cn") && awsRegionRegexp.MatchString(subs[1]) {
 		region = &subs[1]
 	}
 
 	return
 }
 
-// calculateNumBatches returns the appropriate number of batch requests to
-// generate for the total number of messages to send.
-func calculateNumBatches(numMsgs uint) uint {
-	numBatches := numMsgs / msgBatchSize
-	if numMsgs%msgBatchSize != 0 {
-		numBatches++
+// prepareMsgBatches builds a list of batch requests containing the messages to
+// be sent to the queue.
+func prepareMsgBatches(o *cmdOpts) []*sqs.SendMessageBatchInput {
+	gen := newMsgGenerator(o)
+	p := newBatchPacker(o.queueURL.String())
+
+	for i := uint(0); i < *o.numMsgs; i++ {
+		p.add(gen.next())
 	}
 
-	return numBatches
+	return p.batches
 }
 
-// prepareMsgBatches builds a list of batch requests containing the messages to
-// be sent to the queue.
-func prepareMsgBatches(o *cmdOpts) []*sqs.SendMessageBatchInput {
-	payload := strings.Repeat("0", int(*o.msgSize))
-	queueURL := o.queueURL.String()
+// msgGenerator generates messages in the content mode set in the command's
+// options.
+type msgGenerator struct {
+	mode string
+
+	// static data, used when no template is set
+	data string
+	// template rendered into the data of each message
+	tmpl    *template.Template
+	tmplBuf bytes.Buffer
+
+	// context attributes of CloudEvents
+	ceType      string
+	ceSource    string
+	contentType string
+	// whether the data can be embedded as is in structured events,
+	// instead of being encoded as a JSON string
+	dataIsJSON bool
+
+	// unique prefix of event IDs and deduplication IDs, so that messages
+	// aren't deduplicated across runs
+	runID string
+	seq   uint
+
+	// set for FIFO queues only
+	groups *groupPicker
+}
 
-	batches := make([]*sqs.SendMessageBatchInput, 0, calculateNumBatches(*o.numMsgs))
+// newMsgGenerator returns a msgGenerator for the messages described by the
+// command's options.
+func newMsgGenerator(o *cmdOpts) *msgGenerator {
+	g := &msgGenerator{
+		mode:        *o.mode,
+		data:        string(o.data),
+		tmpl:        o.tmpl,
+		ceType:      *o.ceType,
+		ceSource:    *o.ceSource,
+		contentType: *o.contentType,
+		runID:       strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
 
-	for i := uint(0); i < *o.numMsgs; i++ {
-		if i%msgBatchSize == 0 {
-			batches = append(batches, &sqs.SendMessageBatchInput{
-				Entries:  make([]*sqs.SendMessageBatchRequestEntry, 0, msgBatchSize),
-				QueueUrl: &queueURL,
-			})
-		}
+	if o.data == nil {
+		g.data = strings.Repeat("0", int(*o.msgSize))
+	}
+
+	if o.fifo {
+		g.groups = newGroupPicker(*o.groups, *o.groupDist)
+	}
+
+	if g.mode == modeStructured {
+		sample := g.renderData(0, g.runID)
+		g.dataIsJSON = isJSONMediaType(g.contentType) && json.Valid([]byte(*sample))
+	}
+
+	return g
+}
+
+// next returns a new message.
+func (g *msgGenerator) next() *sqs.SendMessageBatchRequestEntry {
+	seq := strconv.FormatUint(uint64(g.seq), 10)
+	id := g.runID + "-" + seq
+
+	data := g.renderData(g.seq, id)
+
+	msg := &sqs.SendMessageBatchRequestEntry{
+		Id: aws.String(fmt.Sprintf("%05d", g.seq)),
+	}
+
+	switch g.mode {
+	case modeStructured:
+		msg.MessageBody = aws.String(g.structuredEvent(id, seq, *data))
+	case modeBinary:
+		msg.MessageBody = data
+		msg.MessageAttributes = g.eventAttributes(id, seq)
+	default:
+		msg.MessageBody = data
+	}
+
+	if g.groups != nil {
+		msg.MessageGroupId = g.groups.next()
+		msg.MessageDeduplicationId = &id
+	}
+
+	g.seq++
+
+	return msg
+}
+
+// renderData returns the data of the message with the given sequence number
+// and ID. Static data is shared by all messages.
+func (g *msgGenerator) renderData(seq uint, id string) *string {
+	if g.tmpl == nil {
+		return &g.data
+	}
+
+	g.tmplBuf.Reset()
+	// the template was validated against a sample message
+	_ = g.tmpl.Execute(&g.tmplBuf, &tmplData{Seq: seq, ID: id})
+
+	data := g.tmplBuf.String()
+	return &data
+}
+
+// tmplData contains the fields available to data templates.
+type tmplData struct {
+	Seq uint
+	ID  string
+}
+
+// parseDataTemplate parses the given data as a template, and verifies that
+// it can be rendered.
+func parseDataTemplate(data []byte) (*template.Template, error) {
+	tmpl, err := template.New("data").Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parsing data template: %w", err)
+	}
+
+	if err := tmpl.Execute(ioutil.Discard, &tmplData{}); err != nil {
+		return nil, fmt.Errorf("rendering data template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// batchPacker packs messages into batch requests, each filled up to the
+// limits of Amazon SQS in number of entries and total size.
+// Messages with different message groups are packed into different batches,
+// so that the messages of a group can be sent in order.
+type batchPacker struct {
+	queueURL *string
+
+	batches []*sqs.SendMessageBatchInput
+	// last batch of each message group, with the total size of its messages
+	open map[string]*openBatch
+}
+
+// openBatch is a batch which can still receive messages.
+type openBatch struct {
+	batch *sqs.SendMessageBatchInput
+	size  int
+}
+
+// newBatchPacker returns a batchPacker for the queue with the given URL.
+func newBatchPacker(queueURL string) *batchPacker {
+	return &batchPacker{
+		queueURL: &queueURL,
+		open:     make(map[string]*openBatch),
+	}
+}
 
-		msg := &sqs.SendMessageBatchRequestEntry{
-			Id:          aws.String(fmt.Sprintf("%05d", i)),
-			MessageBody: &payload,
+// add appends the given message to the last batch of its message group, or to
+// a new batch if the last one can't hold that message.
+func (p *batchPacker) add(msg *sqs.SendMessageBatchRequestEntry) {
+	size := msgSize(msg)
+	group := aws.StringValue(msg.MessageGroupId)
+
+	b := p.open[group]
+
+	if b == nil ||
+		len(b.batch.Entries) == maxBatchEntries ||
+		b.size+size > maxBatchSizeBytes {
+
+		b = &openBatch{
+			batch: &sqs.SendMessageBatchInput{
+				Entries:  make([]*sqs.SendMessageBatchRequestEntry, 0, maxBatchEntries),
+				QueueUrl: p.queueURL,
+			},
 		}
+		p.open[group] = b
+		p.batches = append(p.batches, b.batch)
+	}
 
-		curEntries := &(batches[len(batches)-1].Entries)
-		*curEntries = append(*curEntries, msg)
+	b.batch.Entries = append(b.batch.Entries, msg)
+	b.size += size
+}
+
+// msgSize returns the size of the given message, as accounted by Amazon SQS
+// towards the size limit of a batch.
+func msgSize(msg *sqs.SendMessageBatchRequestEntry) int {
+	size := len(aws.StringValue(msg.MessageBody))
+
+	// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/SQSDeveloperGuide/sqs-message-metadata.html#sqs-message-attributes
+	for name, attr := range msg.MessageAttributes {
+		size += len(name) + len(aws.StringValue(attr.DataType)) + len(aws.StringValue(attr.StringValue))
 	}
 
-	return batches
+	return size
 }
 
 // sendMsgBatches sends the given message batches concurrently.
 func sendMsgBatches(cli Client, batches []*sqs.SendMessageBatchInput) error {
-	if len(batches) == 0 {
-		return nil
+	return (&batchSender{cli: cli}).send(batchList(batches))
+}
+
+// batchList returns a batchProducer which writes the given batches.
+func batchList(batches []*sqs.SendMessageBatchInput) batchProducer {
+	return func(ctx context.Context, batchCh chan<- *sqs.SendMessageBatchInput) {
+		for _, b := range batches {
+			select {
+			case batchCh <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
 	}
+}
 
-	// try 1 batch first, and send the rest in bulk only if this succeeded
-	var firstBatch *sqs.SendMessageBatchInput
-	firstBatch, batches = batches[0], batches[1:]
+// batchProducer writes batches of messages to batchCh, until either it runs
+// out of batches or the context is cancelled.
+type batchProducer func(ctx context.Context, batchCh chan<- *sqs.SendMessageBatchInput)
+
+// batchSender sends batches of messages concurrently.
+type batchSender struct {
+	cli Client
+
+	// stamps messages with the time at which they are sent, if not nil
+	stamp stampFunc
+	// records the outcome of each batch, if not nil
+	prog *progress
+	// records statistics about the run, if not nil
+	stats *runStats
+
+	// number of concurrent batch processors, defaults to
+	// defaultConcurrency() if 0
+	numProcs int
+	// adjusts the number of batch processors, if not nil
+	adapt *adaptiveController
+}
 
-	if _, err := cli.SendMessageBatch(firstBatch); err != nil {
-		return fmt.Errorf("sending first batch of %d messages: %w", len(firstBatch.Entries), err)
-	}
+// send sends the message batches written by the given producer concurrently.
+func (s *batchSender) send(produce batchProducer) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	batchCh := make(chan *sqs.SendMessageBatchInput)
 
-	errCh := make(chan error, len(batches))
-	defer close(errCh)
+	go func() {
+		produce(ctx, batchCh)
+		close(batchCh)
+	}()
 
-	runBatchProcessors(cli, batchCh, errCh)
+	// try 1 batch first, and send the rest in bulk only if this succeeded
+	firstBatch, ok := <-batchCh
+	if !ok {
+		return nil
+	}
 
-	for i := range batches {
-		batchCh <- batches[i]
+	err := s.sendBatch(firstBatch)
+	s.record(len(firstBatch.Entries), err)
+	if err != nil {
+		return fmt.Errorf("sending first batch of %d messages: %w", len(firstBatch.Entries), err)
 	}
-	close(batchCh)
+
+	errCh := make(chan error)
+
+	// batches are homogeneous, either all messages have a group or none has
+	ordered := firstBatch.Entries[0].MessageGroupId != nil
+
+	s.runBatchProcessors(batchCh, errCh, ordered)
 
 	var errs []error
 	var failedMsgs int
 
-	for i := 0; i < cap(errCh); i++ {
-		if err := <-errCh; err != nil {
+	for err := range errCh {
+		if err != nil {
 			if errSend := (&errSendBatch{}); errors.As(err, &errSend) {
 				failedMsgs += errSend.count
 			}
@@ -203,42 +659,251 @@ func sendMsgBatches(cli Client, batches []*sqs.SendMessageBatchInput) error {
 }
 
 // runBatchProcessors runs background task processors that process batches of
-// messages from batchCh and send their results to errCh.
-func runBatchProcessors(cli Client, batchCh <-chan *sqs.SendMessageBatchInput, errCh chan<- error) {
-	// Each processor spends most of its time waiting for the network, so
-	// we can run more than one per thread.
-	const processorPerProc = 4
+// messages from batchCh and send their results to errCh. errCh is closed once
+// all batches have been processed.
+// When ordered is true, all batches of a given message group are processed by
+// the same processor, which preserves the order of the messages in the group.
+func (s *batchSender) runBatchProcessors(batchCh <-chan *sqs.SendMessageBatchInput, errCh chan<- error,
+	ordered bool) {
+
+	numProcs := s.numProcs
+	if numProcs == 0 {
+		numProcs = defaultConcurrency()
+	}
 
-	for i := 0; i < runtime.GOMAXPROCS(-1)*processorPerProc; i++ {
+	var wg sync.WaitGroup
+
+	// done is closed once a processor observed that all batches have been
+	// read, at which point the number of processors is no longer adjusted
+	done := make(chan struct{})
+	var doneOnce sync.Once
+
+	startProc := func(batchCh <-chan *sqs.SendMessageBatchInput, stop <-chan struct{}) {
+		wg.Add(1)
 		go func() {
-			for {
-				b, ok := <-batchCh
-				if !ok {
-					return
-				}
-
-				_, err := cli.SendMessageBatch(b)
-
-				if err != nil {
-					err = &errSendBatch{
-						count: len(b.Entries),
-						err:   err,
-					}
-				}
-
-				// always write to errCh to notify the batch has been processed
-				errCh <- err
+			defer wg.Done()
+			if drained := s.processBatches(batchCh, errCh, stop); drained {
+				doneOnce.Do(func() { close(done) })
 			}
 		}()
 	}
+
+	switch {
+	case ordered:
+		for _, ch := range dispatchByGroup(batchCh, numProcs) {
+			startProc(ch, nil)
+		}
+
+	case s.adapt != nil:
+		// the controller starts processors until it settles, so it must
+		// be waited for before errCh can be closed
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.adapt.run(func(stop <-chan struct{}) { startProc(batchCh, stop) }, done)
+		}()
+
+	default:
+		for i := 0; i < numProcs; i++ {
+			startProc(batchCh, nil)
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
+}
+
+// processBatches sends the batches of messages read from batchCh, and writes
+// their results to errCh, until either batchCh or stop is closed. It returns
+// whether batchCh was closed.
+func (s *batchSender) processBatches(batchCh <-chan *sqs.SendMessageBatchInput, errCh chan<- error,
+	stop <-chan struct{}) (drained bool) {
+
+	for {
+		var b *sqs.SendMessageBatchInput
+		var ok bool
+
+		select {
+		case <-stop:
+			return false
+		case b, ok = <-batchCh:
+		}
+
+		if !ok {
+			return true
+		}
+
+		err := s.sendBatch(b)
+		s.record(len(b.Entries), err)
+
+		// always write to errCh to notify the batch has been processed
+		errCh <- err
+	}
+}
+
+// sendBatch sends the given batch of messages. Entries which the API reports
+// as failed with a retryable error are sent again in a new batch, after an
+// exponential backoff, up to maxSendRetries times, unless this would break the
+// order of their message group. Retried messages keep the send time of their
+// first attempt.
+// The returned error, if any, is a *errSendBatch.
+func (s *batchSender) sendBatch(b *sqs.SendMessageBatchInput) error {
+	if s.stamp != nil {
+		now := time.Now()
+		for _, e := range b.Entries {
+			s.stamp(e, now)
+		}
+	}
+
+	var failed []*sqs.BatchResultErrorEntry
+
+	for attempt := 0; ; attempt++ {
+		callStart := time.Now()
+		out, err := s.cli.SendMessageBatch(b)
+		s.stats.recordCall(time.Since(callStart))
+		if err != nil {
+			return &errSendBatch{
+				count:  len(b.Entries) + len(failed),
+				err:    err,
+				failed: failed,
+			}
+		}
+		if out == nil || len(out.Failed) == 0 {
+			break
+		}
+
+		s.adapt.recordFailedEntries(out.Failed)
+
+		var retry []*sqs.SendMessageBatchRequestEntry
+		retry, failed = splitFailedEntries(b.Entries, out.Failed, attempt == maxSendRetries, failed)
+
+		if len(retry) == 0 {
+			break
+		}
+
+		sleep(retryDelay(attempt))
+
+		b = &sqs.SendMessageBatchInput{
+			Entries:  retry,
+			QueueUrl: b.QueueUrl,
+		}
+	}
+
+	if len(failed) > 0 {
+		return &errSendBatch{
+			count: len(failed),
+			err:   &errFailedEntries{entries: failed},
+		}
+	}
+
+	return nil
 }
 
+// record records the outcome of the sending of a batch of the given number
+// of messages.
+func (s *batchSender) record(numMsgs int, err error) {
+	s.prog.record(numMsgs, err)
+	s.stats.record(numMsgs, err)
+	s.adapt.record(numMsgs, err)
+}
+
+// splitFailedEntries returns the entries of a batch which were reported as
+// failed and should be sent again, in the order in which they appear in the
+// batch, and appends the failures which shouldn't be retried to failed.
+// Entries which belong to a message group are retried only if no entry which
+// follows them in the same group was accepted, because resending them would
+// deliver them after messages that were generated later.
+func splitFailedEntries(entries []*sqs.SendMessageBatchRequestEntry, failures []*sqs.BatchResultErrorEntry,
+	lastAttempt bool, failed []*sqs.BatchResultErrorEntry) ([]*sqs.SendMessageBatchRequestEntry,
+	[]*sqs.BatchResultErrorEntry) {
+
+	failuresByID := make(map[string]*sqs.BatchResultErrorEntry, len(failures))
+	for _, f := range failures {
+		failuresByID[aws.StringValue(f.Id)] = f
+	}
+
+	var retry []*sqs.SendMessageBatchRequestEntry
+
+	for i, e := range entries {
+		f, ok := failuresByID[aws.StringValue(e.Id)]
+		if !ok {
+			continue
+		}
+
+		if lastAttempt || !isRetryable(f) || acceptedLater(entries[i+1:], e.MessageGroupId, failuresByID) {
+			failed = append(failed, f)
+		} else {
+			retry = append(retry, e)
+		}
+	}
+
+	// failures which don't match any entry of the batch
+	for _, f := range failures {
+		if findEntry(entries, aws.StringValue(f.Id)) == nil {
+			failed = append(failed, f)
+		}
+	}
+
+	return retry, failed
+}
+
+// acceptedLater returns whether any of the given entries belongs to the given
+// message group and wasn't reported as failed.
+func acceptedLater(entries []*sqs.SendMessageBatchRequestEntry, group *string,
+	failuresByID map[string]*sqs.BatchResultErrorEntry) bool {
+
+	if group == nil {
+		return false
+	}
+
+	for _, e := range entries {
+		if aws.StringValue(e.MessageGroupId) != *group {
+			continue
+		}
+		if _, isFailed := failuresByID[aws.StringValue(e.Id)]; !isFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// findEntry returns the entry with the given ID, or nil if no entry has this ID.
+func findEntry(entries []*sqs.SendMessageBatchRequestEntry, id string) *sqs.SendMessageBatchRequestEntry {
+	for _, e := range entries {
+		if aws.StringValue(e.Id) == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// isRetryable returns whether the failed batch entry can be sent again, which
+// is the case when its error code denotes a transient condition.
+func isRetryable(f *sqs.BatchResultErrorEntry) bool {
+	_, ok := retryableEntryCodes[aws.StringValue(f.Code)]
+	return ok
+}
+
+// retryDelay returns the time to wait before the given retry attempt (from 0),
+// which grows exponentially with a random jitter.
+func retryDelay(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+	if d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+var sleep = time.Sleep
+
 // Client is an alias for sqsiface.SQSAPI.
 type Client = sqsiface.SQSAPI
 
 // ClientGetter can obtain SQS clients.
 type ClientGetter interface {
-	Get(region *string) Client
+	Get(cfg *aws.Config) Client
 }
 
 // clientGetter gets SQS clients using a awsclient.ConfigProvider.
@@ -250,25 +915,19 @@ type clientGetter struct {
 var _ ClientGetter = (*clientGetter)(nil)
 
 // Get implements ClientGetter.
-func (g *clientGetter) Get(region *string) Client {
-	var cfgs []*aws.Config
-
-	if region != nil {
-		cfgs = append(cfgs, aws.NewConfig().WithRegion(*region))
-	}
-
-	return sqs.New(g.configProvider, cfgs...)
+func (g *clientGetter) Get(cfg *aws.Config) Client {
+	return sqs.New(g.configProvider, cfg)
 }
 
 // ClientGetterFunc allows the use of ordinary functions as ClientGetter.
-type ClientGetterFunc func(region *string) Client
+type ClientGetterFunc func(cfg *aws.Config) Client
 
 // ClientGetterFunc implements ClientGetter.
 var _ ClientGetter = (ClientGetterFunc)(nil)
 
 // Get implements ClientGetter.
-func (f ClientGetterFunc) Get(region *string) Client {
-	return f(region)
+func (f ClientGetterFunc) Get(cfg *aws.Config) Client {
+	return f(cfg)
 }
 
 type errList struct {
@@ -286,9 +945,34 @@ func (e *errList) Error() string {
 type errSendBatch struct {
 	count int
 	err   error
+	// entries which failed on an earlier attempt and weren't retried,
+	// when err is the error of a retry call
+	failed []*sqs.BatchResultErrorEntry
 }
 
 // Error implements the error interface.
 func (e *errSendBatch) Error() string {
 	return e.err.Error()
 }
+
+// errFailedEntries indicates that entries of a batch of messages were reported
+// as failed by the API.
+type errFailedEntries struct {
+	entries []*sqs.BatchResultErrorEntry
+}
+
+// Error implements the error interface.
+func (e *errFailedEntries) Error() string {
+	countByCode := make(map[string]int)
+	for _, f := range e.entries {
+		countByCode[aws.StringValue(f.Code)]++
+	}
+
+	codes := make([]string, 0, len(countByCode))
+	for c, n := range countByCode {
+		codes = append(codes, fmt.Sprintf("%s (%d)", c, n))
+	}
+	sort.Strings(codes)
+
+	return fmt.Sprintf("%d entries failed: %s", len(e.entries), strings.Join(codes, ", "))
+}
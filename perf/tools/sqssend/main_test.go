@@ -18,11 +18,15 @@ package main
 
 import (
 	"errors"
+	"flag"
+	"io/ioutil"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
@@ -45,16 +49,16 @@ func TestSend(t *testing.T) {
 			expectReq: 1,
 		},
 		{
-			numMsg:    msgBatchSize,
+			numMsg:    maxBatchEntries,
 			expectReq: 1,
 		},
 		{
-			numMsg:    msgBatchSize + 1,
+			numMsg:    maxBatchEntries + 1,
 			expectReq: 2,
 		},
 		{
 			numMsg:    9_999,
-			expectReq: 1250, // assuming msgBatchSize is 8
+			expectReq: 1000, // assuming maxBatchEntries is 10
 		},
 	}
 
@@ -83,9 +87,66 @@ func TestSend(t *testing.T) {
 	}
 }
 
+func TestBatchPacking(t *testing.T) {
+	testCases := []struct {
+		msgSize uint
+		numMsg  uint
+		// number of messages in each batch
+		expectBatches []int
+	}{
+		{
+			msgSize:       1,
+			numMsg:        25,
+			expectBatches: []int{10, 10, 5},
+		},
+		{
+			msgSize:       maxBatchSizeBytes / 3,
+			numMsg:        7,
+			expectBatches: []int{3, 3, 1},
+		},
+		{
+			msgSize:       maxBatchSizeBytes/2 + 1,
+			numMsg:        3,
+			expectBatches: []int{1, 1, 1},
+		},
+		{
+			msgSize:       maxMsgSizeBytes,
+			numMsg:        2,
+			expectBatches: []int{1, 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strconv.FormatUint(uint64(tc.msgSize), 10)+" B messages", func(t *testing.T) {
+			batches := prepareMsgBatches(mustReadOpts(t,
+				"-u=http://queue",
+				"-n", strconv.FormatUint(uint64(tc.numMsg), 10),
+				"-s", strconv.FormatUint(uint64(tc.msgSize), 10),
+			))
+
+			gotBatches := make([]int, len(batches))
+			for i, b := range batches {
+				gotBatches[i] = len(b.Entries)
+
+				var size int
+				for _, e := range b.Entries {
+					size += msgSize(e)
+				}
+				if size > maxBatchSizeBytes {
+					t.Errorf("Batch %d exceeds the maximum size: %d B", i, size)
+				}
+			}
+
+			if !reflect.DeepEqual(gotBatches, tc.expectBatches) {
+				t.Errorf("Expected batches of %v messages, got %v", tc.expectBatches, gotBatches)
+			}
+		})
+	}
+}
+
 func TestSendWithError(t *testing.T) {
 	const numRequests = 10
-	const numMsg = msgBatchSize * numRequests
+	const numMsg = maxBatchEntries * numRequests
 
 	testCases := []struct {
 		failEvery int
@@ -93,11 +154,11 @@ func TestSendWithError(t *testing.T) {
 	}{
 		{
 			failEvery: 1,
-			expectMsg: "sending first batch of " + strconv.Itoa(msgBatchSize) + " messages: fake error",
+			expectMsg: "sending first batch of " + strconv.Itoa(maxBatchEntries) + " messages: fake error",
 		},
 		{
 			failEvery: 3,
-			expectMsg: "sending " + strconv.Itoa(msgBatchSize*(numRequests/3)) + ` messages: ["fake error" `,
+			expectMsg: "sending " + strconv.Itoa(maxBatchEntries*(numRequests/3)) + ` messages: ["fake error" `,
 		},
 	}
 
@@ -122,6 +183,113 @@ func TestSendWithError(t *testing.T) {
 	}
 }
 
+func TestSendWithFailedEntries(t *testing.T) {
+	sleep = func(time.Duration) {}
+	t.Cleanup(func() { sleep = time.Sleep })
+
+	const numRequests = 10
+	const numMsg = maxBatchEntries * numRequests
+
+	testCases := []struct {
+		name          string
+		errCode       string
+		senderFault   bool
+		failures      int
+		expectReq     int
+		expectErrMsgs []string
+	}{
+		{
+			name:      "throttled entries succeed after retries",
+			errCode:   "RequestThrottled",
+			failures:  2,
+			expectReq: numRequests * 3,
+		},
+		{
+			name:      "throttled entries exceed retries",
+			errCode:   "RequestThrottled",
+			failures:  maxSendRetries + 1,
+			expectReq: maxSendRetries + 1,
+			expectErrMsgs: []string{
+				"sending first batch of " + strconv.Itoa(maxBatchEntries) + " messages: ",
+				strconv.Itoa(maxBatchEntries) + " entries failed: RequestThrottled (" + strconv.Itoa(maxBatchEntries) + ")",
+			},
+		},
+		{
+			name:        "entries fail with a sender fault",
+			errCode:     "InvalidMessageContents",
+			senderFault: true,
+			failures:    1,
+			expectReq:   1,
+			expectErrMsgs: []string{
+				strconv.Itoa(maxBatchEntries) + " entries failed: InvalidMessageContents",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cli := &mockSQSSender{
+				entryErrCode:     tc.errCode,
+				entrySenderFault: tc.senderFault,
+				entryFailures:    tc.failures,
+			}
+			cg := staticClientGetter(cli)
+
+			var stderr strings.Builder
+
+			err := run(cg, []string{tCmd, "-u=http://queue", "-n", strconv.Itoa(numMsg)}, &stderr)
+
+			if gotReq := cli.reqSent; gotReq != tc.expectReq {
+				t.Errorf("Expected %d requests to be sent, got %d", tc.expectReq, gotReq)
+			}
+
+			if len(tc.expectErrMsgs) == 0 {
+				if err != nil {
+					t.Fatal("Unexpected error: ", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("Expected command to fail")
+			}
+			for _, expectMsg := range tc.expectErrMsgs {
+				if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+					t.Fatalf("Unexpected error message: %q", errStr)
+				}
+			}
+		})
+	}
+
+	t.Run("failed entries are reported", func(t *testing.T) {
+		cli := &mockSQSSender{}
+		batches := prepareMsgBatches(mustReadOpts(t,
+			"-u=http://queue",
+			"-n", strconv.FormatUint(uint64(numMsg), 10),
+			"-s", "1",
+		))
+
+		// fail entries of all but the first batch, after it was sent
+		cli.entryErrCode = "InvalidMessageContents"
+		cli.entrySenderFault = true
+		cli.entryFailures = 1
+		cli.entryAttempts = map[string]int{}
+		for _, e := range batches[0].Entries {
+			cli.entryAttempts[*e.Id] = 1
+		}
+
+		err := sendMsgBatches(cli, batches)
+		if err == nil {
+			t.Fatal("Expected sending to fail")
+		}
+
+		expectMsg := "sending " + strconv.Itoa(numMsg-maxBatchEntries) + " messages: "
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+}
+
 func TestArgs(t *testing.T) {
 	cli := &mockSQSSender{}
 	cg := staticClientGetter(cli)
@@ -152,6 +320,30 @@ func TestArgs(t *testing.T) {
 		}
 	})
 
+	t.Run("-rate without -duration", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-rate", "100"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "duration must be greater than 0 when a rate is set"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
+	t.Run("-rate with -n", func(t *testing.T) {
+		err := run(cg, []string{tCmd, "-u=http://queue", "-rate", "100", "-duration", "1s", "-n", "10"}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := "number of messages and rate are mutually exclusive"
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+
 	t.Run("value of -s exceeds limit", func(t *testing.T) {
 		aboveLimit := strconv.FormatUint(uint64(maxMsgSizeBytes)+1, 10)
 
@@ -210,9 +402,24 @@ func TestParseRegionFromQueueURL(t *testing.T) {
 	}
 }
 
+// mustReadOpts parses the given command-line arguments, and fails the test if
+// they are invalid.
+func mustReadOpts(t *testing.T, args ...string) *cmdOpts {
+	t.Helper()
+
+	f := flag.NewFlagSet(tCmd, flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+
+	opts, err := readOpts(f, append([]string{tCmd}, args...))
+	if err != nil {
+		t.Fatalf("Failed to parse arguments %q: %s", args, err)
+	}
+	return opts
+}
+
 // staticClientGetter transforms the given client interface into a ClientGetter.
 func staticClientGetter(cli Client) ClientGetterFunc {
-	return func(*string) Client {
+	return func(*aws.Config) Client {
 		return cli
 	}
 }
@@ -223,11 +430,22 @@ type mockSQSSender struct {
 	sync.Mutex
 	reqSent  int
 	msgsSent int
+	// batches in the order they were sent, when recordBatches is true
+	recordBatches bool
+	batches       []*sqs.SendMessageBatchInput
 
 	failEvery int
+
+	// entries are reported as failed with entryErrCode the first
+	// entryFailures times they are sent
+	entryErrCode     string
+	entrySenderFault bool
+	entryFailures    int
+	entryAttempts    map[string]int
 }
 
 func (m *mockSQSSender) SendMessageBatch(in *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	var out *sqs.SendMessageBatchOutput
 	var err error
 
 	if in != nil {
@@ -235,12 +453,35 @@ func (m *mockSQSSender) SendMessageBatch(in *sqs.SendMessageBatchInput) (*sqs.Se
 		m.reqSent++
 		m.msgsSent += len(in.Entries)
 
+		if m.recordBatches {
+			m.batches = append(m.batches, in)
+		}
+
 		if m.failEvery > 0 && m.reqSent%m.failEvery == 0 {
 			err = errors.New("fake error")
 		}
 
+		if m.entryErrCode != "" {
+			out = &sqs.SendMessageBatchOutput{}
+
+			if m.entryAttempts == nil {
+				m.entryAttempts = make(map[string]int)
+			}
+
+			for _, e := range in.Entries {
+				id := *e.Id
+				if m.entryAttempts[id]++; m.entryAttempts[id] <= m.entryFailures {
+					out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{
+						Id:          e.Id,
+						Code:        &m.entryErrCode,
+						SenderFault: &m.entrySenderFault,
+					})
+				}
+			}
+		}
+
 		m.Unlock()
 	}
 
-	return nil, err
+	return out, err
 }
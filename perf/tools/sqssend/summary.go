@@ -0,0 +1,272 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Formats of run summaries.
+const (
+	summaryJSON = "json"
+	summaryCSV  = "csv"
+)
+
+// errCodeUnknown is the error code of failures which didn't originate from
+// the AWS SDK.
+const errCodeUnknown = "Unknown"
+
+// runStats records statistics about the sending of messages, from which a
+// runSummary is computed.
+type runStats struct {
+	mu sync.Mutex
+
+	attempted      int
+	failed         int
+	failuresByCode map[string]int
+	// latencies of SendMessageBatch calls, including retries
+	callLatencies []time.Duration
+
+	start time.Time
+	end   time.Time
+}
+
+// newRunStats returns an initialized runStats.
+func newRunStats() *runStats {
+	return &runStats{
+		failuresByCode: make(map[string]int),
+	}
+}
+
+// begin marks the start of the run.
+func (s *runStats) begin() {
+	if s == nil {
+		return
+	}
+	s.start = time.Now()
+}
+
+// finish marks the end of the run.
+func (s *runStats) finish() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+}
+
+// recordCall records the latency of a SendMessageBatch call.
+func (s *runStats) recordCall(latency time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.callLatencies = append(s.callLatencies, latency)
+}
+
+// record records the outcome of the sending of a batch of the given number of
+// messages.
+func (s *runStats) record(numMsgs int, err error) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempted += numMsgs
+
+	errSend := &errSendBatch{}
+	if !errors.As(err, &errSend) {
+		return
+	}
+
+	s.failed += errSend.count
+
+	if errEntries := (&errFailedEntries{}); errors.As(errSend.err, &errEntries) {
+		s.recordFailedEntries(errEntries.entries)
+		return
+	}
+
+	// entries which failed before the failed call keep their own code
+	s.recordFailedEntries(errSend.failed)
+
+	code := errCodeUnknown
+	if awsErr := awserr.Error(nil); errors.As(errSend.err, &awsErr) {
+		code = awsErr.Code()
+	}
+	s.failuresByCode[code] += errSend.count - len(errSend.failed)
+}
+
+// recordFailedEntries records the codes of batch entries reported as failed
+// by the API. The caller must hold the lock.
+func (s *runStats) recordFailedEntries(failed []*sqs.BatchResultErrorEntry) {
+	for _, f := range failed {
+		s.failuresByCode[aws.StringValue(f.Code)]++
+	}
+}
+
+// summary returns the runSummary of the recorded statistics.
+func (s *runStats) summary() *runSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dur := s.end.Sub(s.start)
+
+	sum := &runSummary{
+		Attempted:       s.attempted,
+		Sent:            s.attempted - s.failed,
+		Failed:          s.failed,
+		FailuresByCode:  s.failuresByCode,
+		DurationSeconds: dur.Seconds(),
+		BatchCalls:      len(s.callLatencies),
+	}
+
+	if dur > 0 {
+		sum.Throughput = float64(sum.Sent) / dur.Seconds()
+	}
+
+	if len(s.callLatencies) > 0 {
+		lats := make([]time.Duration, len(s.callLatencies))
+		copy(lats, s.callLatencies)
+		sort.Slice(lats, func(i, j int) bool { return lats[i] < lats[j] })
+
+		sum.LatencyMs = &latencySummary{
+			Min: durationMs(lats[0]),
+			P50: durationMs(percentile(lats, 50)),
+			P90: durationMs(percentile(lats, 90)),
+			P99: durationMs(percentile(lats, 99)),
+			Max: durationMs(lats[len(lats)-1]),
+		}
+	}
+
+	return sum
+}
+
+// percentile returns the p-th percentile of the given sorted durations, using
+// the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// durationMs returns the given duration in milliseconds.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// runSummary is a machine-readable summary of a run of the command.
+type runSummary struct {
+	Attempted       int             `json:"attempted"`
+	Sent            int             `json:"sent"`
+	Failed          int             `json:"failed"`
+	FailuresByCode  map[string]int  `json:"failuresByCode"`
+	DurationSeconds float64         `json:"durationSeconds"`
+	Throughput      float64         `json:"throughputMsgsPerSecond"`
+	BatchCalls      int             `json:"batchCalls"`
+	LatencyMs       *latencySummary `json:"batchCallLatencyMs,omitempty"`
+}
+
+// latencySummary summarizes the distribution of the latencies of
+// SendMessageBatch calls.
+type latencySummary struct {
+	Min float64 `json:"min"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// summaryCSVHeader is the header of summaries in the CSV format.
+var summaryCSVHeader = []string{
+	"attempted", "sent", "failed", "failuresByCode", "durationSeconds", "throughputMsgsPerSecond",
+	"batchCalls", "latencyMinMs", "latencyP50Ms", "latencyP90Ms", "latencyP99Ms", "latencyMaxMs",
+}
+
+// write writes the summary to w in the given format.
+func (s *runSummary) write(w io.Writer, format string) error {
+	switch format {
+	case summaryJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+
+	case summaryCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write(summaryCSVHeader)
+		_ = cw.Write(s.csvRecord())
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("invalid summary format %q", format)
+	}
+}
+
+// csvRecord returns the summary as a CSV record matching summaryCSVHeader.
+// Failures are formatted as "Code:count" pairs separated by semicolons.
+func (s *runSummary) csvRecord() []string {
+	codes := make([]string, 0, len(s.FailuresByCode))
+	for c, n := range s.FailuresByCode {
+		codes = append(codes, c+":"+strconv.Itoa(n))
+	}
+	sort.Strings(codes)
+
+	lat := s.LatencyMs
+	if lat == nil {
+		lat = &latencySummary{}
+	}
+
+	return []string{
+		strconv.Itoa(s.Attempted),
+		strconv.Itoa(s.Sent),
+		strconv.Itoa(s.Failed),
+		strings.Join(codes, ";"),
+		formatFloat(s.DurationSeconds),
+		formatFloat(s.Throughput),
+		strconv.Itoa(s.BatchCalls),
+		formatFloat(lat.Min),
+		formatFloat(lat.P50),
+		formatFloat(lat.P90),
+		formatFloat(lat.P99),
+		formatFloat(lat.Max),
+	}
+}
+
+// formatFloat formats f with a precision of 3 decimal places.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestSummary(t *testing.T) {
+	const numRequests = 10
+	const numMsg = maxBatchEntries * numRequests
+
+	t.Run("JSON summary of a run with failed batches", func(t *testing.T) {
+		cli := &mockSQSSender{
+			failEvery: 3,
+		}
+		cg := staticClientGetter(cli)
+
+		summaryFile := filepath.Join(t.TempDir(), "summary.json")
+
+		var stderr strings.Builder
+
+		err := run(cg, []string{tCmd, "-u=http://queue", "-n", strconv.Itoa(numMsg),
+			"-summary", summaryJSON, "-summary-file", summaryFile}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		f, err := os.Open(summaryFile)
+		if err != nil {
+			t.Fatal("Failed to open summary file:", err)
+		}
+		defer f.Close()
+
+		sum := &runSummary{}
+		if err := json.NewDecoder(f).Decode(sum); err != nil {
+			t.Fatal("Failed to decode summary:", err)
+		}
+
+		const expectFailed = maxBatchEntries * (numRequests / 3)
+
+		if sum.Attempted != numMsg {
+			t.Errorf("Expected %d attempted messages, got %d", numMsg, sum.Attempted)
+		}
+		if sum.Sent != numMsg-expectFailed {
+			t.Errorf("Expected %d sent messages, got %d", numMsg-expectFailed, sum.Sent)
+		}
+		if sum.Failed != expectFailed {
+			t.Errorf("Expected %d failed messages, got %d", expectFailed, sum.Failed)
+		}
+		if expect := map[string]int{errCodeUnknown: expectFailed}; !reflect.DeepEqual(sum.FailuresByCode, expect) {
+			t.Errorf("Expected failures %v, got %v", expect, sum.FailuresByCode)
+		}
+		if sum.BatchCalls != numRequests {
+			t.Errorf("Expected %d batch calls, got %d", numRequests, sum.BatchCalls)
+		}
+		if sum.LatencyMs == nil {
+			t.Error("Expected the summary to include call latencies")
+		}
+	})
+
+	t.Run("CSV summary of a run with failed entries", func(t *testing.T) {
+		cli := &mockSQSSender{
+			entryErrCode:     "InvalidMessageContents",
+			entrySenderFault: true,
+			entryFailures:    1,
+		}
+		cg := staticClientGetter(cli)
+
+		summaryFile := filepath.Join(t.TempDir(), "summary.csv")
+
+		var stderr strings.Builder
+
+		err := run(cg, []string{tCmd, "-u=http://queue", "-n", strconv.Itoa(numMsg),
+			"-summary", summaryCSV, "-summary-file", summaryFile}, &stderr)
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		f, err := os.Open(summaryFile)
+		if err != nil {
+			t.Fatal("Failed to open summary file:", err)
+		}
+		defer f.Close()
+
+		records, err := csv.NewReader(f).ReadAll()
+		if err != nil {
+			t.Fatal("Failed to read summary:", err)
+		}
+
+		if len(records) != 2 {
+			t.Fatalf("Expected a header and 1 record, got %d records", len(records))
+		}
+		if !reflect.DeepEqual(records[0], summaryCSVHeader) {
+			t.Errorf("Unexpected header %q", records[0])
+		}
+
+		// the run stops after the first batch fails
+		expect := []string{"10", "0", "10", "InvalidMessageContents:10"}
+		if got := records[1][:len(expect)]; !reflect.DeepEqual(got, expect) {
+			t.Errorf("Expected record to start with %q, got %q", expect, got)
+		}
+	})
+
+	t.Run("invalid -summary value", func(t *testing.T) {
+		err := run(staticClientGetter(&mockSQSSender{}), []string{tCmd, "-u=http://queue", "-summary", "xml"},
+			&strings.Builder{})
+		if err == nil {
+			t.Fatal("Expected command to fail")
+		}
+
+		expectMsg := `invalid summary format "xml"`
+		if errStr := err.Error(); !strings.Contains(errStr, expectMsg) {
+			t.Fatalf("Unexpected error message: %q", errStr)
+		}
+	})
+}
+
+func TestSummaryRetryCallFailure(t *testing.T) {
+	cli := &retryCallFailer{
+		mockSQSSender: &mockSQSSender{},
+	}
+
+	stats := newRunStats()
+	s := &batchSender{
+		cli:   cli,
+		stats: stats,
+	}
+
+	defer func() { sleep = time.Sleep }()
+	sleep = func(time.Duration) {}
+
+	b := &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String("http://queue"),
+	}
+	for i := 0; i < maxBatchEntries; i++ {
+		b.Entries = append(b.Entries, &sqs.SendMessageBatchRequestEntry{
+			Id:          aws.String(strconv.Itoa(i)),
+			MessageBody: aws.String("msg"),
+		})
+	}
+
+	s.record(len(b.Entries), s.sendBatch(b))
+
+	sum := stats.summary()
+
+	if sum.Failed != 5 {
+		t.Errorf("Expected 5 failed messages, got %d", sum.Failed)
+	}
+
+	// 3 entries failed with a non-retryable error on the first attempt,
+	// the 2 entries which were retried failed with the error of the call
+	expect := map[string]int{"InvalidMessageContents": 3, "ServiceUnavailable": 2}
+	if !reflect.DeepEqual(sum.FailuresByCode, expect) {
+		t.Errorf("Expected failures %v, got %v", expect, sum.FailuresByCode)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	lats := make([]time.Duration, 100)
+	for i := range lats {
+		lats[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	testCases := []struct {
+		p      float64
+		expect time.Duration
+	}{
+		{p: 0, expect: 1 * time.Millisecond},
+		{p: 50, expect: 50 * time.Millisecond},
+		{p: 90, expect: 90 * time.Millisecond},
+		{p: 99, expect: 99 * time.Millisecond},
+		{p: 100, expect: 100 * time.Millisecond},
+	}
+
+	for _, tc := range testCases {
+		t.Run("p"+strconv.FormatFloat(tc.p, 'f', -1, 64), func(t *testing.T) {
+			if got := percentile(lats, tc.p); got != tc.expect {
+				t.Errorf("Expected %s, got %s", tc.expect, got)
+			}
+		})
+	}
+}
+
+// retryCallFailer is a mockSQSSender which reports the first 3 entries of the
+// first batch as failed with a non-retryable error, the next 2 entries as
+// failed with a retryable error, and fails every subsequent call.
+type retryCallFailer struct {
+	*mockSQSSender
+
+	calls int
+}
+
+func (m *retryCallFailer) SendMessageBatch(in *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	if m.calls++; m.calls > 1 {
+		return nil, awserr.New("ServiceUnavailable", "service unavailable", nil)
+	}
+
+	out := &sqs.SendMessageBatchOutput{}
+	for i, e := range in.Entries[:5] {
+		f := &sqs.BatchResultErrorEntry{
+			Id:          e.Id,
+			Code:        aws.String("InvalidMessageContents"),
+			SenderFault: aws.Bool(true),
+		}
+		if i >= 3 {
+			f.Code = aws.String("InternalError")
+			f.SenderFault = aws.Bool(false)
+		}
+		out.Failed = append(out.Failed, f)
+	}
+
+	return out, nil
+}
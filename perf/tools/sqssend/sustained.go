@@ -0,0 +1,224 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Copied to kinesissend on purpose: the tools under perf/tools are independent Go modules.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Interval at which the progress of the sustained mode is reported.
+const progressInterval = time.Second
+
+// sendSustained sends messages with the given batchSender at the rate set in
+// the command's options, for the duration set in the command's options, and
+// reports the progress of the sending to the given writer.
+func sendSustained(s *batchSender, o *cmdOpts, w io.Writer) error {
+	prog := newProgress(w)
+	s.prog = prog
+
+	stop := make(chan struct{})
+	reportDone := make(chan struct{})
+
+	go func() {
+		defer close(reportDone)
+
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				prog.report()
+			}
+		}
+	}()
+
+	err := s.send(o.limitRate(sustainedProducer(o)))
+
+	close(stop)
+	<-reportDone
+	prog.report()
+
+	return err
+}
+
+// sustainedProducer returns a batchProducer which generates batches of
+// messages at the rate set in the command's options, for the duration set in
+// the command's options.
+//
+// At high rates, batches are filled with up to maxBatchEntries messages. At
+// low rates, messages are sent as soon as they are due, in smaller batches.
+func sustainedProducer(o *cmdOpts) batchProducer {
+	queueURL := o.queueURL.String()
+
+	return func(ctx context.Context, batchCh chan<- *sqs.SendMessageBatchInput) {
+		ctx, cancel := context.WithTimeout(ctx, *o.duration)
+		defer cancel()
+
+		gen := newMsgGenerator(o)
+		bucket := newTokenBucket(float64(*o.rate), maxBatchEntries)
+
+		for {
+			n, ok := bucket.take(ctx, maxBatchEntries)
+			if !ok {
+				return
+			}
+
+			// large messages may not fit in a single batch
+			p := newBatchPacker(queueURL)
+			for i := 0; i < n; i++ {
+				p.add(gen.next())
+			}
+
+			for _, b := range p.batches {
+				select {
+				case batchCh <- b:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// tokenBucket is a token bucket which refills at a constant rate, up to a
+// maximum capacity. Each token allows one message to be sent.
+type tokenBucket struct {
+	// tokens per second
+	rate     float64
+	capacity float64
+
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns an empty tokenBucket with the given rate and capacity.
+func newTokenBucket(rate float64, capacity int) *tokenBucket {
+	return &tokenBucket{
+		rate:     rate,
+		capacity: float64(capacity),
+		last:     time.Now(),
+	}
+}
+
+// take waits until at least one token is available, then takes up to max
+// available tokens from the bucket and returns their number. It returns false
+// if the context is cancelled before a token becomes available.
+func (b *tokenBucket) take(ctx context.Context, max int) (int, bool) {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		b.refill(time.Now())
+
+		if b.tokens >= 1 {
+			n := int(b.tokens)
+			if n > max {
+				n = max
+			}
+			b.tokens -= float64(n)
+			return n, true
+		}
+
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+
+		if timer == nil {
+			timer = time.NewTimer(wait)
+		} else {
+			timer.Reset(wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case <-timer.C:
+		}
+	}
+}
+
+// refill adds the tokens accumulated since the last refill to the bucket.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// progress keeps track of the number of messages sent, and reports it.
+// Its methods are no-ops on a nil *progress.
+type progress struct {
+	// accessed atomically
+	sent   uint64
+	failed uint64
+
+	start time.Time
+	w     io.Writer
+}
+
+// newProgress returns a progress which reports to the given writer.
+func newProgress(w io.Writer) *progress {
+	return &progress{
+		start: time.Now(),
+		w:     w,
+	}
+}
+
+// record records the outcome of the sending of a batch of the given number of
+// messages.
+func (p *progress) record(numMsgs int, err error) {
+	if p == nil {
+		return
+	}
+
+	var failed int
+	if errSend := (&errSendBatch{}); errors.As(err, &errSend) {
+		failed = errSend.count
+	}
+
+	atomic.AddUint64(&p.sent, uint64(numMsgs-failed))
+	atomic.AddUint64(&p.failed, uint64(failed))
+}
+
+// report writes the number of messages sent so far, and the average rate.
+func (p *progress) report() {
+	if p == nil {
+		return
+	}
+
+	elapsed := time.Since(p.start)
+	sent := atomic.LoadUint64(&p.sent)
+	failed := atomic.LoadUint64(&p.failed)
+
+	fmt.Fprintf(p.w, "[%s] %d messages sent (%.1f msg/s), %d failed\n",
+		elapsed.Round(time.Second), sent, float64(sent)/elapsed.Seconds(), failed)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2020 TriggerMesh Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Run("refill up to capacity", func(t *testing.T) {
+		b := newTokenBucket(100, 10)
+
+		b.refill(b.last.Add(50 * time.Millisecond))
+		if b.tokens != 5 {
+			t.Errorf("Expected 5 tokens after 50ms, got %v", b.tokens)
+		}
+
+		b.refill(b.last.Add(time.Second))
+		if b.tokens != 10 {
+			t.Errorf("Expected the bucket to be filled to capacity, got %v tokens", b.tokens)
+		}
+	})
+
+	t.Run("take available tokens", func(t *testing.T) {
+		b := newTokenBucket(100, 10)
+		b.tokens = 7.5
+
+		n, ok := b.take(context.Background(), 5)
+		if !ok || n != 5 {
+			t.Errorf("Expected to take 5 tokens, got %d", n)
+		}
+
+		n, ok = b.take(context.Background(), 5)
+		if !ok || n != 2 {
+			t.Errorf("Expected to take 2 tokens, got %d", n)
+		}
+	})
+
+	t.Run("wait for a token", func(t *testing.T) {
+		const rate = 20
+
+		b := newTokenBucket(rate, 1)
+
+		start := time.Now()
+		n, ok := b.take(context.Background(), 1)
+		if !ok || n != 1 {
+			t.Fatalf("Expected to take 1 token, got %d", n)
+		}
+
+		if elapsed := time.Since(start); elapsed < time.Second/rate/2 {
+			t.Errorf("Expected to wait for a token to be added, waited %s", elapsed)
+		}
+	})
+
+	t.Run("cancelled wait", func(t *testing.T) {
+		b := newTokenBucket(0.001, 1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, ok := b.take(ctx, 1); ok {
+			t.Error("Expected take to return early")
+		}
+	})
+}
+
+func TestSendSustained(t *testing.T) {
+	const rate = 200
+	const duration = 500 * time.Millisecond
+	const expectMsgs = int(rate * duration / time.Second)
+
+	cli := &mockSQSSender{}
+	cg := staticClientGetter(cli)
+
+	var stderr strings.Builder
+
+	err := run(cg, []string{tCmd, "-u=http://queue", "-rate=" + strconv.Itoa(rate), "-duration=" + duration.String()}, &stderr)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// allow for the imprecision of timers
+	if gotMsg := cli.msgsSent; gotMsg < expectMsgs*8/10 || gotMsg > expectMsgs {
+		t.Errorf("Expected about %d messages to be sent, got %d", expectMsgs, gotMsg)
+	}
+
+	if out := stderr.String(); !strings.Contains(out, "messages sent") {
+		t.Errorf("Expected progress to be reported, got %q", out)
+	}
+}